@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const maxInsertStmtCache = 1024
+
+var insertValuesRegexp = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+[^;]+?\bVALUES\s*\(`)
+
+// insertStmt 可合并的单行INSERT语句,按 prefix + 多个tuple 拼接为多行语句
+type insertStmt struct {
+	prefix string
+	tuple  string
+	argNum int
+}
+
+type insertBatch struct {
+	stmt  *insertStmt
+	items []*queryContext
+}
+
+// batchResult 多行INSERT拆分到单行的结果
+type batchResult struct {
+	lastInsertID int64
+	rowsAffected int64
+	err          error
+}
+
+func (r *batchResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r *batchResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, r.err
+}
+
+// parseInsert 仅合并 INSERT INTO t (...) VALUES (?,...) 形式的语句,
+// 不含IGNORE/ON DUPLICATE KEY UPDATE等无法拆分单行结果的语句
+func (m *dbInstance) parseInsert(queryCtx *queryContext) *insertStmt {
+	if m.inserts == nil {
+		m.inserts = make(map[string]*insertStmt)
+	}
+	stmt, exist := m.inserts[queryCtx.query]
+	if !exist {
+		stmt = parseInsertStmt(queryCtx.query)
+		if len(m.inserts) < maxInsertStmtCache {
+			m.inserts[queryCtx.query] = stmt
+		}
+	}
+	if stmt == nil || stmt.argNum != len(queryCtx.args) {
+		return nil
+	}
+	return stmt
+}
+
+func parseInsertStmt(query string) *insertStmt {
+	loc := insertValuesRegexp.FindStringIndex(query)
+	if loc == nil {
+		return nil
+	}
+	prefix := query[:loc[1]-1]
+	rest := strings.TrimRight(strings.TrimSpace(query[loc[1]-1:]), ";")
+	rest = strings.TrimSpace(rest)
+	if len(rest) < 2 || rest[len(rest)-1] != ')' {
+		return nil
+	}
+	tuple := rest
+	inner := tuple[1 : len(tuple)-1]
+	if strings.ContainsAny(inner, "()'\"`") {
+		return nil
+	}
+	return &insertStmt{
+		prefix: prefix,
+		tuple:  tuple,
+		argNum: strings.Count(inner, "?"),
+	}
+}
+
+// execBatch 多行执行,截止时间取各调用方中最早的.
+// 失败时若确定未提交则逐行执行以便各调用方得到自己的错误,超时及连接错误可能已提交,直接返回给所有调用方
+func (m *dbInstance) execBatch(batch *insertBatch) {
+	items := make([]*queryContext, 0, len(batch.items))
+	var earliest time.Time
+	for _, queryCtx := range batch.items {
+		if queryCtx.execCtx != nil && queryCtx.execCtx.Err() != nil {
+			err := queryCtx.execCtx.Err()
+			if err == context.DeadlineExceeded {
+				err = ErrQueryTimeout
+			}
+			m.reply(queryCtx, nil, err)
+			continue
+		}
+		if queryCtx.execCtx != nil {
+			if d, ok := queryCtx.execCtx.Deadline(); ok && (earliest.IsZero() || d.Before(earliest)) {
+				earliest = d
+			}
+		}
+		items = append(items, queryCtx)
+	}
+	if len(items) == 0 {
+		return
+	}
+	if len(items) == 1 {
+		m.execute(items[0])
+		return
+	}
+	if m.breaker != nil && !m.breaker.allow() {
+		for _, queryCtx := range items {
+			m.reply(queryCtx, nil, ErrCircuitOpen)
+		}
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(batch.stmt.prefix)
+	args := make([]interface{}, 0, batch.stmt.argNum*len(items))
+	for i, queryCtx := range items {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(batch.stmt.tuple)
+		args = append(args, queryCtx.args...)
+	}
+
+	var execCtx context.Context
+	var deadline *opDeadline
+	if earliest.IsZero() {
+		execCtx, deadline = m.opContext(nil)
+		defer deadline.release()
+	} else {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithDeadline(context.Background(), earliest)
+		defer cancel()
+	}
+
+	startTime := time.Now()
+	ret, err := m.handle(execCtx, &Operation{
+		Type:  OpExec,
+		DbIdx: m.idx,
+		Query: sb.String(),
+		Args:  args,
+		Async: items[0].async,
+	})
+	m.stats.record(items[0].query, nil, len(items), time.Since(startTime), err)
+	if err != nil && (deadline.expired() || execCtx.Err() == context.DeadlineExceeded) {
+		err = ErrQueryTimeout
+	}
+	if m.breaker != nil {
+		m.breaker.record(err)
+	}
+	if err != nil {
+		if m.isTransientError(err) {
+			for _, queryCtx := range items {
+				m.reply(queryCtx, nil, err)
+			}
+			return
+		}
+		for _, queryCtx := range items {
+			m.execute(queryCtx)
+		}
+		return
+	}
+
+	// 单条多行INSERT的自增id连续分配,按首行id递增拆分,
+	// 要求auto_increment_increment=1,否则各行的LastInsertId不可靠
+	var firstID int64
+	var affectedErr error
+	res, _ := ret.(sql.Result)
+	affected := int64(len(items))
+	if res != nil {
+		firstID, _ = res.LastInsertId()
+		affected, affectedErr = res.RowsAffected()
+	}
+	if affectedErr == nil && affected != int64(len(items)) {
+		affectedErr = fmt.Errorf("database: batch insert affected %d of %d rows", affected, len(items))
+	}
+	for i, queryCtx := range items {
+		ret := &batchResult{err: affectedErr}
+		if affectedErr == nil {
+			ret.rowsAffected = 1
+		}
+		if firstID > 0 {
+			ret.lastInsertID = firstID + int64(i)
+		}
+		m.reply(queryCtx, ret, nil)
+	}
+}
@@ -0,0 +1,84 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+func asyncInserts(p *virgotest.Procedure, db *database.DB, ctx context.Context, n int) []interface{} {
+	results := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		db.AsyncExecContext(ctx, i, func(args []interface{}) {
+			if args[2] != nil {
+				results[args[0].(int)] = args[2]
+			} else {
+				results[args[0].(int)] = args[1]
+			}
+		}, 0, "INSERT INTO user (name) VALUES (?)", i)
+	}
+	for i := 0; i < n; i++ {
+		p.RunOne(time.Second)
+	}
+	return results
+}
+
+func TestBatchInsert(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 1, func(db *database.DB) {
+		db.SetBatchInsert(3, time.Millisecond*50)
+	})
+	defer db.Close()
+
+	// 合并为一条语句,按首行id拆分结果
+	rec.Expect("VALUES (?),(?),(?)").WillReturnResult(10, 3).Times(1)
+	for i, ret := range asyncInserts(p, db, nil, 3) {
+		res, ok := ret.(sql.Result)
+		if !ok {
+			t.Fatalf("row %d: %v", i, ret)
+		}
+		id, _ := res.LastInsertId()
+		affected, err := res.RowsAffected()
+		if id != int64(10+i) || affected != 1 || err != nil {
+			t.Fatalf("row %d: id %d, affected %d, %v", i, id, affected, err)
+		}
+	}
+	if n := len(rec.Statements()); n != 1 {
+		t.Fatalf("statements %d", n)
+	}
+
+	// 确定未提交的错误逐行重试
+	rec.Reset()
+	rec.Expect("VALUES (?),(?),(?)").WillReturnError(errors.New("duplicate entry")).Times(1)
+	for i, ret := range asyncInserts(p, db, nil, 3) {
+		if _, ok := ret.(sql.Result); !ok {
+			t.Fatalf("row %d: %v", i, ret)
+		}
+	}
+	if n := len(rec.Statements()); n != 4 {
+		t.Fatalf("statements %d", n)
+	}
+
+	// 超时可能已提交,不再逐行重试;截止时间取调用方的
+	rec.Reset()
+	rec.Expect("VALUES (?),(?),(?)").WillDelay(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	startTime := time.Now()
+	for i, ret := range asyncInserts(p, db, ctx, 3) {
+		if ret != database.ErrQueryTimeout {
+			t.Fatalf("row %d: %v", i, ret)
+		}
+	}
+	if elapsed := time.Since(startTime); elapsed > time.Millisecond*500 {
+		t.Fatalf("caller deadline ignored, elapsed %v", elapsed)
+	}
+	if n := len(rec.Statements()); n != 1 {
+		t.Fatalf("statements %d", n)
+	}
+}
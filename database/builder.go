@@ -0,0 +1,623 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidIdent 表名或列名不合法,只允许字母、数字、下划线及库名分隔符
+var ErrInvalidIdent = errors.New("database: invalid identifier")
+
+var identRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// sqlBuf 拼接语句及参数,占位符按方言编号
+type sqlBuf struct {
+	d    Dialect
+	sb   strings.Builder
+	args []interface{}
+	err  error
+}
+
+func (b *sqlBuf) write(s string) {
+	b.sb.WriteString(s)
+}
+
+func (b *sqlBuf) arg(v interface{}) {
+	b.args = append(b.args, v)
+	b.sb.WriteString(b.d.Placeholder(len(b.args)))
+}
+
+// ident 校验并引用标识符,db.table形式分别引用
+func (b *sqlBuf) ident(name string) {
+	if name == "*" {
+		b.sb.WriteByte('*')
+		return
+	}
+	if !identRegexp.MatchString(name) {
+		if b.err == nil {
+			b.err = fmt.Errorf("%w: %q", ErrInvalidIdent, name)
+		}
+		return
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		b.sb.WriteString(b.d.QuoteIdent(name[:i]))
+		b.sb.WriteByte('.')
+		name = name[i+1:]
+	}
+	b.sb.WriteString(b.d.QuoteIdent(name))
+}
+
+func (b *sqlBuf) idents(names []string) {
+	for i, name := range names {
+		if i > 0 {
+			b.sb.WriteByte(',')
+		}
+		b.ident(name)
+	}
+}
+
+// raw 写入原始表达式,其中的?替换为方言占位符,引号内的?原样保留,引号内以连续两个引号转义
+func (b *sqlBuf) raw(expr string, args []interface{}) {
+	n := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			b.sb.WriteString(expr[start:i])
+			start = i + 1
+			if n < len(args) {
+				b.arg(args[n])
+			} else if b.err == nil {
+				b.err = fmt.Errorf("database: expression %q needs more arguments", expr)
+			}
+			n++
+		}
+	}
+	b.sb.WriteString(expr[start:])
+	if n < len(args) && b.err == nil {
+		b.err = fmt.Errorf("database: expression has %d placeholders, got %d arguments", n, len(args))
+	}
+}
+
+func (b *sqlBuf) where(conds []Cond) {
+	if len(conds) == 0 {
+		return
+	}
+	b.write(" WHERE ")
+	And(conds...).build(b)
+}
+
+func (b *sqlBuf) result() (string, []interface{}, error) {
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	return b.sb.String(), b.args, nil
+}
+
+// Cond 查询条件
+type Cond interface {
+	build(b *sqlBuf)
+}
+
+type compareCond struct {
+	col string
+	op  string
+	v   interface{}
+}
+
+func (c *compareCond) build(b *sqlBuf) {
+	b.ident(c.col)
+	b.write(c.op)
+	b.arg(c.v)
+}
+
+// Eq col = v
+func Eq(col string, v interface{}) Cond {
+	return &compareCond{col: col, op: "=", v: v}
+}
+
+// Ne col <> v
+func Ne(col string, v interface{}) Cond {
+	return &compareCond{col: col, op: "<>", v: v}
+}
+
+// Gt col > v
+func Gt(col string, v interface{}) Cond {
+	return &compareCond{col: col, op: ">", v: v}
+}
+
+// Ge col >= v
+func Ge(col string, v interface{}) Cond {
+	return &compareCond{col: col, op: ">=", v: v}
+}
+
+// Lt col < v
+func Lt(col string, v interface{}) Cond {
+	return &compareCond{col: col, op: "<", v: v}
+}
+
+// Le col <= v
+func Le(col string, v interface{}) Cond {
+	return &compareCond{col: col, op: "<=", v: v}
+}
+
+// Like col LIKE v
+func Like(col string, v interface{}) Cond {
+	return &compareCond{col: col, op: " LIKE ", v: v}
+}
+
+type nullCond struct {
+	col string
+	not bool
+}
+
+func (c *nullCond) build(b *sqlBuf) {
+	b.ident(c.col)
+	if c.not {
+		b.write(" IS NOT NULL")
+	} else {
+		b.write(" IS NULL")
+	}
+}
+
+// IsNull col IS NULL
+func IsNull(col string) Cond {
+	return &nullCond{col: col}
+}
+
+// IsNotNull col IS NOT NULL
+func IsNotNull(col string) Cond {
+	return &nullCond{col: col, not: true}
+}
+
+type inCond struct {
+	col    string
+	values interface{}
+	not    bool
+}
+
+func (c *inCond) build(b *sqlBuf) {
+	values := expandValues(c.values)
+	if len(values) == 0 {
+		// 空列表: IN 恒假, NOT IN 恒真
+		if c.not {
+			b.write("1=1")
+		} else {
+			b.write("1=0")
+		}
+		return
+	}
+	b.ident(c.col)
+	if c.not {
+		b.write(" NOT IN (")
+	} else {
+		b.write(" IN (")
+	}
+	for i, v := range values {
+		if i > 0 {
+			b.write(",")
+		}
+		b.arg(v)
+	}
+	b.write(")")
+}
+
+// In col IN (...),values为切片或数组,按元素展开为占位符,空列表恒假
+func In(col string, values interface{}) Cond {
+	return &inCond{col: col, values: values}
+}
+
+// NotIn col NOT IN (...),空列表恒真
+func NotIn(col string, values interface{}) Cond {
+	return &inCond{col: col, values: values, not: true}
+}
+
+// expandValues 展开切片,[]byte视为单个值
+func expandValues(values interface{}) []interface{} {
+	if _, ok := values.([]byte); ok {
+		return []interface{}{values}
+	}
+	v := reflect.ValueOf(values)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return []interface{}{values}
+	}
+	arr := make([]interface{}, v.Len())
+	for i := range arr {
+		arr[i] = v.Index(i).Interface()
+	}
+	return arr
+}
+
+type logicCond struct {
+	op    string
+	conds []Cond
+}
+
+func (c *logicCond) build(b *sqlBuf) {
+	if len(c.conds) == 0 {
+		b.write("1=1")
+		return
+	}
+	if len(c.conds) == 1 {
+		c.conds[0].build(b)
+		return
+	}
+	for i, cond := range c.conds {
+		if i > 0 {
+			b.write(c.op)
+		}
+		b.write("(")
+		cond.build(b)
+		b.write(")")
+	}
+}
+
+// And 全部满足,无条件时恒真
+func And(conds ...Cond) Cond {
+	return &logicCond{op: " AND ", conds: conds}
+}
+
+// Or 任一满足
+func Or(conds ...Cond) Cond {
+	return &logicCond{op: " OR ", conds: conds}
+}
+
+type exprCond struct {
+	expr string
+	args []interface{}
+}
+
+func (c *exprCond) build(b *sqlBuf) {
+	b.raw(c.expr, c.args)
+}
+
+// Expr 原始条件表达式,?为参数占位符,表达式中的标识符不做引用
+func Expr(expr string, args ...interface{}) Cond {
+	return &exprCond{expr: expr, args: args}
+}
+
+// Builder 按方言生成语句及参数
+type Builder struct {
+	d Dialect
+}
+
+// NewBuilder 新建
+func NewBuilder(d Dialect) *Builder {
+	return &Builder{d: d}
+}
+
+// Select 查询,未指定列时为 *
+func (qb *Builder) Select(cols ...string) *SelectBuilder {
+	return &SelectBuilder{d: qb.d, cols: cols}
+}
+
+// Insert 插入
+func (qb *Builder) Insert(table string) *InsertBuilder {
+	return &InsertBuilder{d: qb.d, table: table}
+}
+
+// Update 更新
+func (qb *Builder) Update(table string) *UpdateBuilder {
+	return &UpdateBuilder{d: qb.d, table: table}
+}
+
+// Delete 删除
+func (qb *Builder) Delete(table string) *DeleteBuilder {
+	return &DeleteBuilder{d: qb.d, table: table}
+}
+
+type orderBy struct {
+	col  string
+	desc bool
+}
+
+// SelectBuilder 查询语句
+type SelectBuilder struct {
+	d         Dialect
+	cols      []string
+	exprs     []string
+	table     string
+	where     []Cond
+	groupBy   []string
+	having    []Cond
+	orderBy   []orderBy
+	limit     int
+	offset    int
+	forUpdate bool
+}
+
+// Expr 添加原始查询表达式,如 COUNT(*)
+func (sb *SelectBuilder) Expr(expr string) *SelectBuilder {
+	sb.exprs = append(sb.exprs, expr)
+	return sb
+}
+
+// From 表名
+func (sb *SelectBuilder) From(table string) *SelectBuilder {
+	sb.table = table
+	return sb
+}
+
+// Where 添加条件,多次调用的条件为AND关系
+func (sb *SelectBuilder) Where(conds ...Cond) *SelectBuilder {
+	sb.where = append(sb.where, conds...)
+	return sb
+}
+
+// GroupBy 分组
+func (sb *SelectBuilder) GroupBy(cols ...string) *SelectBuilder {
+	sb.groupBy = append(sb.groupBy, cols...)
+	return sb
+}
+
+// Having 分组条件
+func (sb *SelectBuilder) Having(conds ...Cond) *SelectBuilder {
+	sb.having = append(sb.having, conds...)
+	return sb
+}
+
+// OrderBy 升序
+func (sb *SelectBuilder) OrderBy(col string) *SelectBuilder {
+	sb.orderBy = append(sb.orderBy, orderBy{col: col})
+	return sb
+}
+
+// OrderByDesc 降序
+func (sb *SelectBuilder) OrderByDesc(col string) *SelectBuilder {
+	sb.orderBy = append(sb.orderBy, orderBy{col: col, desc: true})
+	return sb
+}
+
+// Limit 最大行数
+func (sb *SelectBuilder) Limit(n int) *SelectBuilder {
+	sb.limit = n
+	return sb
+}
+
+// Offset 跳过行数,未设置Limit时不限行数
+func (sb *SelectBuilder) Offset(n int) *SelectBuilder {
+	sb.offset = n
+	return sb
+}
+
+// Page 分页,page从1开始
+func (sb *SelectBuilder) Page(page int, size int) *SelectBuilder {
+	if page < 1 {
+		page = 1
+	}
+	sb.limit = size
+	sb.offset = (page - 1) * size
+	return sb
+}
+
+// ForUpdate 加锁读
+func (sb *SelectBuilder) ForUpdate() *SelectBuilder {
+	sb.forUpdate = true
+	return sb
+}
+
+// Build 生成语句及参数
+func (sb *SelectBuilder) Build() (string, []interface{}, error) {
+	b := &sqlBuf{d: sb.d}
+	b.write("SELECT ")
+	if len(sb.cols) == 0 && len(sb.exprs) == 0 {
+		b.write("*")
+	}
+	b.idents(sb.cols)
+	for i, expr := range sb.exprs {
+		if i > 0 || len(sb.cols) > 0 {
+			b.write(",")
+		}
+		b.write(expr)
+	}
+	b.write(" FROM ")
+	b.ident(sb.table)
+	b.where(sb.where)
+	if len(sb.groupBy) > 0 {
+		b.write(" GROUP BY ")
+		b.idents(sb.groupBy)
+	}
+	if len(sb.having) > 0 {
+		b.write(" HAVING ")
+		And(sb.having...).build(b)
+	}
+	for i, o := range sb.orderBy {
+		if i == 0 {
+			b.write(" ORDER BY ")
+		} else {
+			b.write(",")
+		}
+		b.ident(o.col)
+		if o.desc {
+			b.write(" DESC")
+		}
+	}
+	if sb.limit > 0 {
+		b.write(" LIMIT " + strconv.Itoa(sb.limit))
+	} else if sb.offset > 0 {
+		// MySQL及SQLite的OFFSET须跟在LIMIT后,不限行数时使用各自的最大值
+		switch sb.d.(type) {
+		case PostgresDialect:
+		case SqliteDialect:
+			b.write(" LIMIT -1")
+		default:
+			b.write(" LIMIT 18446744073709551615")
+		}
+	}
+	if sb.offset > 0 {
+		b.write(" OFFSET " + strconv.Itoa(sb.offset))
+	}
+	if sb.forUpdate {
+		b.write(" FOR UPDATE")
+	}
+	return b.result()
+}
+
+// InsertBuilder 插入语句
+type InsertBuilder struct {
+	d      Dialect
+	table  string
+	cols   []string
+	rows   [][]interface{}
+	keys   []string
+	upsert bool
+	err    error
+}
+
+// Columns 列名
+func (ib *InsertBuilder) Columns(cols ...string) *InsertBuilder {
+	ib.cols = cols
+	return ib
+}
+
+// Values 添加一行,多次调用为多行插入
+func (ib *InsertBuilder) Values(values ...interface{}) *InsertBuilder {
+	if len(values) != len(ib.cols) && ib.err == nil {
+		ib.err = fmt.Errorf("database: insert %d values into %d columns", len(values), len(ib.cols))
+	}
+	ib.rows = append(ib.rows, values)
+	return ib
+}
+
+// OnDuplicateKeyUpdate keys为唯一键列,冲突时更新其余列,没有其余列时忽略冲突.
+// MySQL生成 ON DUPLICATE KEY UPDATE,其他方言生成 ON CONFLICT (keys)
+func (ib *InsertBuilder) OnDuplicateKeyUpdate(keys ...string) *InsertBuilder {
+	ib.upsert = true
+	ib.keys = keys
+	return ib
+}
+
+// Build 生成语句及参数
+func (ib *InsertBuilder) Build() (string, []interface{}, error) {
+	if ib.err != nil {
+		return "", nil, ib.err
+	}
+	if len(ib.cols) == 0 || len(ib.rows) == 0 {
+		return "", nil, errors.New("database: insert without values")
+	}
+	// 插入语句由方言整体引用表名,不支持 db.table 形式
+	for _, name := range append(append([]string{ib.table}, ib.cols...), ib.keys...) {
+		if !identRegexp.MatchString(name) || strings.IndexByte(name, '.') >= 0 {
+			return "", nil, fmt.Errorf("%w: %q", ErrInvalidIdent, name)
+		}
+	}
+
+	var query string
+	if ib.upsert {
+		query = ib.d.Upsert(ib.table, ib.cols, ib.keys, len(ib.rows))
+	} else {
+		query = insertSQL(ib.d, "INSERT", ib.table, ib.cols, len(ib.rows))
+	}
+	args := make([]interface{}, 0, len(ib.cols)*len(ib.rows))
+	for _, row := range ib.rows {
+		args = append(args, row...)
+	}
+	return query, args, nil
+}
+
+type setClause struct {
+	col  string
+	expr string
+	args []interface{}
+}
+
+// UpdateBuilder 更新语句
+type UpdateBuilder struct {
+	d     Dialect
+	table string
+	sets  []setClause
+	where []Cond
+	limit int
+}
+
+// Set col = v
+func (ub *UpdateBuilder) Set(col string, v interface{}) *UpdateBuilder {
+	ub.sets = append(ub.sets, setClause{col: col, expr: "?", args: []interface{}{v}})
+	return ub
+}
+
+// SetExpr col = expr,如 SetExpr("gold", "gold+?", 100)
+func (ub *UpdateBuilder) SetExpr(col string, expr string, args ...interface{}) *UpdateBuilder {
+	ub.sets = append(ub.sets, setClause{col: col, expr: expr, args: args})
+	return ub
+}
+
+// Where 添加条件,多次调用的条件为AND关系
+func (ub *UpdateBuilder) Where(conds ...Cond) *UpdateBuilder {
+	ub.where = append(ub.where, conds...)
+	return ub
+}
+
+// Limit 最大更新行数,仅MySQL支持
+func (ub *UpdateBuilder) Limit(n int) *UpdateBuilder {
+	ub.limit = n
+	return ub
+}
+
+// Build 生成语句及参数
+func (ub *UpdateBuilder) Build() (string, []interface{}, error) {
+	if len(ub.sets) == 0 {
+		return "", nil, errors.New("database: update without set")
+	}
+	b := &sqlBuf{d: ub.d}
+	b.write("UPDATE ")
+	b.ident(ub.table)
+	b.write(" SET ")
+	for i, set := range ub.sets {
+		if i > 0 {
+			b.write(",")
+		}
+		b.ident(set.col)
+		b.write("=")
+		b.raw(set.expr, set.args)
+	}
+	b.where(ub.where)
+	if ub.limit > 0 {
+		b.write(" LIMIT " + strconv.Itoa(ub.limit))
+	}
+	return b.result()
+}
+
+// DeleteBuilder 删除语句
+type DeleteBuilder struct {
+	d     Dialect
+	table string
+	where []Cond
+	limit int
+}
+
+// Where 添加条件,多次调用的条件为AND关系
+func (db *DeleteBuilder) Where(conds ...Cond) *DeleteBuilder {
+	db.where = append(db.where, conds...)
+	return db
+}
+
+// Limit 最大删除行数,仅MySQL支持
+func (db *DeleteBuilder) Limit(n int) *DeleteBuilder {
+	db.limit = n
+	return db
+}
+
+// Build 生成语句及参数
+func (db *DeleteBuilder) Build() (string, []interface{}, error) {
+	b := &sqlBuf{d: db.d}
+	b.write("DELETE FROM ")
+	b.ident(db.table)
+	b.where(db.where)
+	if db.limit > 0 {
+		b.write(" LIMIT " + strconv.Itoa(db.limit))
+	}
+	return b.result()
+}
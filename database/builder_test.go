@@ -0,0 +1,80 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	qb := NewBuilder(MysqlDialect{})
+	cases := []struct {
+		build func() (string, []interface{}, error)
+		query string
+		args  []interface{}
+	}{
+		{
+			build: qb.Select("id", "name").From("guild_3").
+				Where(In("id", []int64{1, 2, 3}), Or(Gt("level", 5), IsNull("leader"))).
+				OrderByDesc("level").Page(3, 20).Build,
+			query: "SELECT `id`,`name` FROM `guild_3` WHERE (`id` IN (?,?,?)) AND ((`level`>?) OR (`leader` IS NULL)) ORDER BY `level` DESC LIMIT 20 OFFSET 40",
+			args:  []interface{}{int64(1), int64(2), int64(3), 5},
+		},
+		{
+			build: qb.Select().Expr("COUNT(*)").From("user").Where(In("id", []int{})).Build,
+			query: "SELECT COUNT(*) FROM `user` WHERE 1=0",
+		},
+		{
+			build: qb.Insert("user").Columns("id", "name").Values(1, "a").Values(2, "b").OnDuplicateKeyUpdate("id").Build,
+			query: "INSERT INTO `user` (`id`,`name`) VALUES (?,?),(?,?) ON DUPLICATE KEY UPDATE `name`=VALUES(`name`)",
+			args:  []interface{}{1, "a", 2, "b"},
+		},
+		{
+			build: qb.Update("user").Set("name", "c").SetExpr("gold", "gold+?", 10).Where(Eq("id", 1)).Build,
+			query: "UPDATE `user` SET `name`=?,`gold`=gold+? WHERE `id`=?",
+			args:  []interface{}{"c", 10, 1},
+		},
+		{
+			build: qb.Delete("user").Where(Expr("last_login < ?", 100), NotIn("id", []string{})).Limit(10).Build,
+			query: "DELETE FROM `user` WHERE (last_login < ?) AND (1=1) LIMIT 10",
+			args:  []interface{}{100},
+		},
+		{
+			build: qb.Select("id").From("user").Offset(10).Build,
+			query: "SELECT `id` FROM `user` LIMIT 18446744073709551615 OFFSET 10",
+		},
+		{
+			build: NewBuilder(SqliteDialect{}).Select("id").From("user").Offset(10).Build,
+			query: `SELECT "id" FROM "user" LIMIT -1 OFFSET 10`,
+		},
+		{
+			build: NewBuilder(PostgresDialect{}).Select("id").From("user").Offset(10).Build,
+			query: `SELECT "id" FROM "user" OFFSET 10`,
+		},
+		{
+			build: NewBuilder(PostgresDialect{}).Update("user").Set("name", "c").Where(In("id", []int{7, 8})).Build,
+			query: `UPDATE "user" SET "name"=$1 WHERE "id" IN ($2,$3)`,
+			args:  []interface{}{"c", 7, 8},
+		},
+		{
+			// 引号内的?不是占位符
+			build: NewBuilder(PostgresDialect{}).Select("id").From("user").
+				Where(Expr(`name <> '?' AND note <> 'it''s ?' AND "?" = ?`, 5), Eq("level", 1)).Build,
+			query: `SELECT "id" FROM "user" WHERE (name <> '?' AND note <> 'it''s ?' AND "?" = $1) AND ("level"=$2)`,
+			args:  []interface{}{5, 1},
+		},
+	}
+	for i, c := range cases {
+		query, args, err := c.build()
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if query != c.query || !reflect.DeepEqual(args, c.args) && len(args)+len(c.args) > 0 {
+			t.Fatalf("case %d:\n got %s %v\nwant %s %v", i, query, args, c.query, c.args)
+		}
+	}
+
+	if _, _, err := qb.Select("id").From("user; DROP TABLE user").Build(); !errors.Is(err, ErrInvalidIdent) {
+		t.Fatalf("expected ErrInvalidIdent, got %v", err)
+	}
+}
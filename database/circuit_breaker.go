@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 数据库不可用,熔断期间快速失败
+var ErrCircuitOpen = errors.New("database: circuit breaker open")
+
+// CircuitState 熔断器状态
+type CircuitState int32
+
+// 熔断器状态
+const (
+	CircuitClosed   CircuitState = iota // 正常
+	CircuitOpen                         // 熔断,操作快速失败
+	CircuitHalfOpen                     // 冷却结束,放行操作试探
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker 连续threshold次连接错误或超时后熔断,冷却cooldown后半开,
+// 半开期间只放行一个试探操作,试探成功即恢复,失败重新熔断
+type circuitBreaker struct {
+	mtx       sync.Mutex
+	state     CircuitState
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	probing   bool
+	probeAt   time.Time
+	connErr   func(error) bool
+	onChange  func(from CircuitState, to CircuitState)
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, connErr func(error) bool, onChange func(CircuitState, CircuitState)) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		connErr:   connErr,
+		onChange:  onChange,
+	}
+}
+
+// allow 是否放行,放行的操作须以record结束.半开期间试探未结束时拒绝,试探超过冷却时间未结束时放行新的试探
+func (cb *circuitBreaker) allow() bool {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	switch cb.state {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.setState(CircuitHalfOpen)
+	default:
+		if cb.probing && time.Since(cb.probeAt) < cb.cooldown {
+			return false
+		}
+	}
+	cb.probing = true
+	cb.probeAt = time.Now()
+	return true
+}
+
+// closed 是否闭合,用于结果无法及时确定、不能作为试探的操作
+func (cb *circuitBreaker) closed() bool {
+	return cb.current() == CircuitClosed
+}
+
+// record 记录操作结果,连接类错误及超时计入失败,其他错误说明数据库可达.
+// 调用方取消的操作不计结果
+func (cb *circuitBreaker) record(err error) {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	if errors.Is(err, context.Canceled) {
+		cb.probing = false
+		return
+	}
+	if err == nil || !(cb.connErr(err) || errors.Is(err, ErrQueryTimeout) || errors.Is(err, context.DeadlineExceeded)) {
+		cb.failures = 0
+		cb.probing = false
+		if cb.state != CircuitClosed {
+			cb.setState(CircuitClosed)
+		}
+		return
+	}
+	cb.failures++
+	if cb.state == CircuitHalfOpen || (cb.state == CircuitClosed && cb.failures >= cb.threshold) {
+		cb.probing = false
+		cb.openedAt = time.Now()
+		cb.setState(CircuitOpen)
+	}
+}
+
+// skip 放行的操作未经数据库执行,结束试探但不计结果
+func (cb *circuitBreaker) skip() {
+	cb.mtx.Lock()
+	cb.probing = false
+	cb.mtx.Unlock()
+}
+
+func (cb *circuitBreaker) current() CircuitState {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	return cb.state
+}
+
+func (cb *circuitBreaker) setState(state CircuitState) {
+	from := cb.state
+	cb.state = state
+	if cb.onChange != nil {
+		cb.onChange(from, state)
+	}
+}
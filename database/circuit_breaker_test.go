@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTestConn = errors.New("connection refused")
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []CircuitState
+	cb := newCircuitBreaker(2, time.Millisecond*50, func(err error) bool {
+		return err == errTestConn
+	}, func(from CircuitState, to CircuitState) {
+		changes = append(changes, to)
+	})
+
+	// 普通错误说明数据库可达,不计失败;调用方取消不计结果
+	cb.record(errTestConn)
+	cb.record(errors.New("syntax error"))
+	cb.record(errTestConn)
+	cb.record(context.Canceled)
+	if cb.current() != CircuitClosed {
+		t.Fatalf("state %v", cb.current())
+	}
+
+	// 超时计入失败
+	cb.record(ErrQueryTimeout)
+	if cb.current() != CircuitOpen || cb.allow() || cb.closed() {
+		t.Fatalf("state %v", cb.current())
+	}
+
+	// 冷却后只放行一个试探,试探失败重新熔断
+	time.Sleep(time.Millisecond * 60)
+	if !cb.allow() || cb.current() != CircuitHalfOpen {
+		t.Fatalf("probe not allowed, state %v", cb.current())
+	}
+	if cb.allow() || cb.closed() {
+		t.Fatal("second probe allowed")
+	}
+	cb.record(context.DeadlineExceeded)
+	if cb.current() != CircuitOpen || cb.allow() {
+		t.Fatalf("state %v", cb.current())
+	}
+
+	// 试探被取消时放行下一个试探,试探成功恢复
+	time.Sleep(time.Millisecond * 60)
+	if !cb.allow() {
+		t.Fatal("probe not allowed")
+	}
+	cb.record(context.Canceled)
+	if !cb.allow() {
+		t.Fatal("probe not allowed after cancel")
+	}
+	cb.record(nil)
+	if cb.current() != CircuitClosed || !cb.allow() || !cb.allow() {
+		t.Fatalf("state %v", cb.current())
+	}
+
+	want := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("changes %v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("changes %v", changes)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/panlibin/virgo"
+)
+
+// IDatabase 数据库操作接口,同步方法阻塞调用方,异步方法的回调在主线程执行
+type IDatabase interface {
+	Query(dbIdx uint32, query string, args ...interface{}) (*sql.Rows, error)
+	QueryContext(ctx context.Context, dbIdx uint32, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(dbIdx uint32, query string, args ...interface{}) *sql.Row
+	QueryRowContext(ctx context.Context, dbIdx uint32, query string, args ...interface{}) *sql.Row
+	Exec(dbIdx uint32, query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, dbIdx uint32, query string, args ...interface{}) (sql.Result, error)
+	QueryAll(dbIdx uint32, scanner RowScanner, query string, args ...interface{}) ([]interface{}, error)
+	QueryAllContext(ctx context.Context, dbIdx uint32, scanner RowScanner, query string, args ...interface{}) ([]interface{}, error)
+	Transaction(dbIdx uint32, f func(*sql.Tx) error) error
+	TransactionContext(ctx context.Context, dbIdx uint32, f func(*sql.Tx) error) error
+
+	AsyncQuery(ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{})
+	AsyncQueryContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{})
+	AsyncQueryRow(ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{})
+	AsyncQueryRowContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{})
+	AsyncExec(ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{})
+	AsyncExecContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{})
+	TryAsyncExec(ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{}) error
+	AsyncQueryAll(ctx interface{}, cb func([]interface{}), dbIdx uint32, scanner RowScanner, query string, args ...interface{})
+	AsyncQueryAllContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, scanner RowScanner, query string, args ...interface{})
+	AsyncTransaction(ctx interface{}, cb func([]interface{}), dbIdx uint32, f func(*sql.Tx) error)
+	AsyncTransactionContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, f func(*sql.Tx) error)
+
+	Dialect() Dialect
+}
+
+var _ IDatabase = (*DB)(nil)
+
+// procedureHolder 可取得回调所在主线程的数据库
+type procedureHolder interface {
+	procedure() virgo.IProcedure
+}
@@ -0,0 +1,1130 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+	"github.com/panlibin/virgo"
+)
+
+const defaultQueryChannelSize = 1024
+
+var spillSeq uint32
+
+const defaultTxRetry = 3
+const defaultHealthCheckInterval = time.Minute * 10
+const healthCheckTimeout = time.Second * 5
+const minOpenBackoff = time.Millisecond * 100
+
+// ErrQueryTimeout 查询超时
+var ErrQueryTimeout = errors.New("database: query timeout")
+
+// ErrQueueFull 实例队列已满
+var ErrQueueFull = errors.New("database: query queue full")
+
+const (
+	queryTypeQuery int32 = iota
+	queryTypeQueryRow
+	queryTypeExec
+	queryTypeTx
+	queryTypeScan
+	queryTypePrepare
+)
+
+type queryContext struct {
+	execCtx      context.Context
+	query        string
+	args         []interface{}
+	queryType    int32
+	callbackChan chan []interface{}
+	async        bool
+	cb           func([]interface{})
+	ctx          interface{}
+	txFunc       func(*sql.Tx) error
+	scanner      RowScanner
+	journalID    uint64
+	replica      bool
+}
+
+type dbConfig struct {
+	timeout    time.Duration
+	txRetry    int
+	batchRows  int
+	batchDelay time.Duration
+	stmtCache  int
+
+	healthInterval   time.Duration
+	openRetry        int
+	openMinBackoff   time.Duration
+	openMaxBackoff   time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	queueSize   int
+	spillDir    string
+	highWater   float64
+	highWaterCb func([]interface{})
+
+	dialect     Dialect
+	middlewares []Middleware
+}
+
+type dbInstance struct {
+	p         virgo.IProcedure
+	db        *sql.DB
+	queryChan chan *queryContext
+	wg        *sync.WaitGroup
+	cfg       *dbConfig
+	inserts   map[string]*insertStmt
+	stmts     *stmtCache
+	replicas  *replicaSet
+	breaker   *circuitBreaker
+	stats     *queryStats
+	idx       uint32
+	spill     *spillQueue
+	alerted   int32
+	journal   *journal
+	batchable bool
+	handle    Handler
+}
+
+func (m *dbInstance) open(db *sql.DB, wg *sync.WaitGroup, cfg *dbConfig) (err error) {
+	m.db = db
+	m.wg = wg
+	m.cfg = cfg
+	// 合并后按自增id拆分单行结果,依赖MySQL多行插入的LastInsertId语义
+	_, m.batchable = cfg.dialect.(MysqlDialect)
+	m.handle = m.handler()
+	m.queryChan = make(chan *queryContext, cfg.queueSize)
+	if cfg.stmtCache > 0 {
+		m.stmts = newStmtCache(cfg.stmtCache)
+	}
+	if cfg.spillDir != "" {
+		if err = os.MkdirAll(cfg.spillDir, 0755); err != nil {
+			return
+		}
+		path := filepath.Join(cfg.spillDir, fmt.Sprintf("spill_%d_%d_%d.dat", os.Getpid(), atomic.AddUint32(&spillSeq, 1), m.idx))
+		if m.spill, err = openSpillQueue(m, path); err != nil {
+			return
+		}
+	}
+
+	m.wg.Add(1)
+	go m.run()
+
+	return
+}
+
+func (m *dbInstance) close() {
+	if m.spill != nil {
+		m.spill.close()
+	}
+	if m.queryChan != nil {
+		m.queryChan <- nil
+	}
+}
+
+func (m *dbInstance) run() {
+	defer m.wg.Done()
+	var batch *insertBatch
+	var batchTimer *time.Timer
+	for {
+		var queryCtx *queryContext
+		if batch != nil {
+			select {
+			case queryCtx = <-m.queryChan:
+			case <-batchTimer.C:
+				m.execBatch(batch)
+				batch = nil
+				continue
+			}
+		} else {
+			queryCtx = <-m.queryChan
+		}
+		if queryCtx == nil {
+			break
+		}
+
+		if m.cfg.batchRows > 1 && m.batchable && queryCtx.queryType == queryTypeExec {
+			if stmt := m.parseInsert(queryCtx); stmt != nil {
+				if batch != nil && batch.stmt != stmt {
+					batchTimer.Stop()
+					m.execBatch(batch)
+					batch = nil
+				}
+				if batch == nil {
+					batch = &insertBatch{stmt: stmt}
+					batchTimer = time.NewTimer(m.cfg.batchDelay)
+				}
+				batch.items = append(batch.items, queryCtx)
+				if len(batch.items) >= m.cfg.batchRows {
+					batchTimer.Stop()
+					m.execBatch(batch)
+					batch = nil
+				}
+				continue
+			}
+		}
+
+		if batch != nil {
+			batchTimer.Stop()
+			m.execBatch(batch)
+			batch = nil
+		}
+		m.execute(queryCtx)
+	}
+	if batch != nil {
+		batchTimer.Stop()
+		m.execBatch(batch)
+	}
+	if m.stmts != nil {
+		m.stmts.close()
+	}
+	close(m.queryChan)
+}
+
+func (m *dbInstance) execute(queryCtx *queryContext) {
+	if queryCtx.queryType < queryTypeQuery || queryCtx.queryType > queryTypePrepare {
+		return
+	}
+
+	// QueryRow的错误要到Scan时才出现,不能作为半开试探,只在闭合时放行
+	if m.breaker != nil {
+		if queryCtx.queryType == queryTypeQueryRow {
+			if !m.breaker.closed() {
+				m.reply(queryCtx, failedRow(m.db, ErrCircuitOpen), ErrCircuitOpen)
+				return
+			}
+		} else if !m.breaker.allow() {
+			m.reply(queryCtx, nil, ErrCircuitOpen)
+			return
+		}
+	}
+
+	execCtx, deadline := m.opContext(queryCtx.execCtx)
+	startTime := time.Now()
+	ret, err := m.handle(execCtx, &Operation{
+		Type:     OpType(queryCtx.queryType),
+		DbIdx:    m.idx,
+		Query:    queryCtx.query,
+		Args:     queryCtx.args,
+		Async:    queryCtx.async,
+		queryCtx: queryCtx,
+	})
+	// 默认超时只约束语句执行,返回的结果集由调用方读取,不再受其约束
+	if queryCtx.queryType == queryTypeQuery || queryCtx.queryType == queryTypeQueryRow {
+		deadline.stop()
+	} else {
+		deadline.release()
+	}
+
+	// 中间件可能未调用next直接返回,QueryRow的结果统一为*sql.Row,错误在Scan时返回
+	if queryCtx.queryType == queryTypeQueryRow {
+		if row, ok := ret.(*sql.Row); !ok || row == nil {
+			if err == nil {
+				err = fmt.Errorf("database: unexpected query row result %T", ret)
+			}
+			ret = failedRow(m.db, err)
+		}
+	}
+	if queryCtx.queryType != queryTypePrepare {
+		m.stats.record(queryCtx.query, queryCtx.args, 1, time.Since(startTime), err)
+	}
+	if deadline.expired() || ((err != nil || queryCtx.queryType == queryTypeQueryRow) && execCtx.Err() == context.DeadlineExceeded) {
+		// 计时在返回后才停止时结果集已被取消;QueryRow的错误要到Scan时才出现,
+		// 替换为Scan返回ErrQueryTimeout的Row,同步与异步得到相同的错误
+		if rows, ok := ret.(*sql.Rows); ok && rows != nil {
+			rows.Close()
+			ret = nil
+		}
+		err = ErrQueryTimeout
+		if queryCtx.queryType == queryTypeQueryRow {
+			ret = failedRow(m.db, err)
+		}
+	}
+	// 熔断器只反映主库状态,从库执行的读操作不计入;QueryRow只有超时可以确定,其余结果不计入
+	if m.breaker != nil {
+		if queryCtx.replica {
+			m.breaker.skip()
+		} else if queryCtx.queryType != queryTypeQueryRow || err != nil {
+			m.breaker.record(err)
+		}
+	}
+	m.reply(queryCtx, ret, err)
+}
+
+// failedRow Scan时返回err的Row.sql.Row无法直接构造,借助已结束的ctx使查询在获取连接前失败
+func failedRow(db *sql.DB, err error) *sql.Row {
+	return db.QueryRowContext(failedContext{err: err}, "")
+}
+
+// failedContext 已结束的ctx,Err返回指定错误
+type failedContext struct {
+	err error
+}
+
+var closedDone = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+func (failedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (failedContext) Done() <-chan struct{} {
+	return closedDone
+}
+
+func (c failedContext) Err() error {
+	return c.err
+}
+
+func (failedContext) Value(interface{}) interface{} {
+	return nil
+}
+
+// reply 记录错误并返回结果,异步操作回调在主线程执行
+func (m *dbInstance) reply(queryCtx *queryContext, ret interface{}, err error) {
+	if queryCtx.journalID != 0 && m.journal != nil && (err == nil || !m.isTransientError(err)) {
+		m.journal.done(queryCtx.journalID)
+	}
+
+	if err != nil {
+		logger.Errorf("%v", err)
+		if queryCtx.query != "" {
+			logger.Errorf(queryCtx.query+"; "+strings.Repeat("%v\t", len(queryCtx.args)), queryCtx.args...)
+		}
+	}
+
+	if queryCtx.async {
+		if queryCtx.cb != nil {
+			if queryCtx.queryType == queryTypeTx {
+				m.p.SyncTask(queryCtx.cb, queryCtx.ctx, err)
+			} else {
+				m.p.SyncTask(queryCtx.cb, queryCtx.ctx, ret, err)
+			}
+		}
+	} else {
+		if queryCtx.callbackChan != nil {
+			queryCtx.callbackChan <- []interface{}{ret, err}
+		}
+	}
+}
+
+// opContext 未设置截止时间时附加默认超时
+func (m *dbInstance) opContext(ctx context.Context) (context.Context, *opDeadline) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if m.cfg.timeout <= 0 {
+		return ctx, nil
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, nil
+	}
+	d := &opDeadline{
+		parent:   ctx,
+		done:     make(chan struct{}),
+		released: make(chan struct{}),
+	}
+	d.timer = time.AfterFunc(m.cfg.timeout, d.expire)
+	if parentDone := ctx.Done(); parentDone != nil {
+		go d.watch(parentDone)
+	}
+	return d, d
+}
+
+// opDeadline 默认超时的ctx,到期后Done关闭,Err返回context.DeadlineExceeded.
+// 不派生可取消的ctx,停止计时后没有需要释放的资源,Query/QueryRow的结果集可继续使用而不必在关闭时释放;
+// 父ctx可取消时由协程转发其取消,协程在父ctx结束、超时或release后退出
+type opDeadline struct {
+	parent   context.Context
+	done     chan struct{}
+	released chan struct{}
+	once     sync.Once
+	relOnce  sync.Once
+	timer    *time.Timer
+	timedOut int32
+}
+
+func (d *opDeadline) Deadline() (time.Time, bool) {
+	return d.parent.Deadline()
+}
+
+func (d *opDeadline) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *opDeadline) Err() error {
+	select {
+	case <-d.done:
+	default:
+		return nil
+	}
+	if atomic.LoadInt32(&d.timedOut) != 0 {
+		return context.DeadlineExceeded
+	}
+	return d.parent.Err()
+}
+
+func (d *opDeadline) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+func (d *opDeadline) watch(parentDone <-chan struct{}) {
+	select {
+	case <-parentDone:
+		d.once.Do(func() { close(d.done) })
+	case <-d.done:
+	case <-d.released:
+	}
+}
+
+func (d *opDeadline) expire() {
+	atomic.StoreInt32(&d.timedOut, 1)
+	d.once.Do(func() { close(d.done) })
+}
+
+// stop 停止计时,ctx保持有效,父ctx的取消仍然转发
+func (d *opDeadline) stop() {
+	if d != nil {
+		d.timer.Stop()
+	}
+}
+
+// release 停止计时并结束转发,用于不再使用ctx的操作
+func (d *opDeadline) release() {
+	if d != nil {
+		d.timer.Stop()
+		d.relOnce.Do(func() { close(d.released) })
+	}
+}
+
+// expired 是否已超时
+func (d *opDeadline) expired() bool {
+	return d != nil && atomic.LoadInt32(&d.timedOut) != 0
+}
+
+// transaction 执行事务,死锁等可重试错误时整体重试
+func (m *dbInstance) transaction(ctx context.Context, f func(*sql.Tx) error) (err error) {
+	for i := 0; ; i++ {
+		err = m.execTx(ctx, f)
+		if err == nil || i >= m.cfg.txRetry || !m.cfg.dialect.IsRetryable(err) || ctx.Err() != nil {
+			return
+		}
+		logger.Warningf("transaction retry %d: %v", i+1, err)
+	}
+}
+
+func (m *dbInstance) execTx(ctx context.Context, f func(*sql.Tx) error) (err error) {
+	var tx *sql.Tx
+	tx, err = m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 2048)
+			n := runtime.Stack(buf, false)
+			logger.Errorf("%v\n%s", r, buf[:n])
+			tx.Rollback()
+			err = fmt.Errorf("database: transaction panic: %v", r)
+		}
+	}()
+
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	return tx.Commit()
+}
+
+// addQuery 入队,队列满时阻塞,开启溢出时异步写操作写入磁盘,溢出积压期间所有操作排在积压之后
+func (m *dbInstance) addQuery(queryCtx *queryContext) {
+	if m.spill != nil && m.spill.add(queryCtx) {
+		m.checkHighWater()
+		return
+	}
+	m.queryChan <- queryCtx
+	m.checkHighWater()
+}
+
+// tryAddQuery 非阻塞入队,队列满或有溢出积压时返回false
+func (m *dbInstance) tryAddQuery(queryCtx *queryContext) bool {
+	if m.spill != nil && m.spill.pending() > 0 {
+		return false
+	}
+	select {
+	case m.queryChan <- queryCtx:
+		m.checkHighWater()
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *dbInstance) depth() int {
+	depth := len(m.queryChan)
+	if m.spill != nil {
+		depth += m.spill.pending()
+	}
+	return depth
+}
+
+// checkHighWater 积压超过高水位时告警一次,回落到高水位一半以下后重新告警
+func (m *dbInstance) checkHighWater() {
+	if m.cfg.highWater <= 0 {
+		return
+	}
+	depth := m.depth()
+	high := int(float64(cap(m.queryChan)) * m.cfg.highWater)
+	if depth >= high {
+		if atomic.CompareAndSwapInt32(&m.alerted, 0, 1) {
+			logger.Warningf("database queue %d high water: %d/%d", m.idx, depth, cap(m.queryChan))
+			if m.cfg.highWaterCb != nil {
+				m.p.SyncTask(m.cfg.highWaterCb, m.idx, depth)
+			}
+		}
+	} else if depth <= high/2 {
+		atomic.StoreInt32(&m.alerted, 0)
+	}
+}
+
+// DB 数据库管理对象,每个实例一个协程顺序执行操作,结果回调到主循环
+type DB struct {
+	arrDb           []*dbInstance
+	p               virgo.IProcedure
+	driverName      string
+	db              *sql.DB
+	wg              *sync.WaitGroup
+	cancelAliveCtx  context.Context
+	cancelAliveFunc context.CancelFunc
+	cfg             *dbConfig
+	replicaDSNs     []string
+	replicas        *replicaSet
+	breaker         *circuitBreaker
+	stateCb         func([]interface{})
+	stats           *queryStats
+	journalPath     string
+	journal         *journal
+}
+
+// NewDB 新建,driverName为database/sql注册的驱动名
+func NewDB(p virgo.IProcedure, driverName string, dialect Dialect) *DB {
+	return &DB{
+		p:          p,
+		driverName: driverName,
+		wg:         &sync.WaitGroup{},
+		stats:      newQueryStats(),
+		cfg: &dbConfig{
+			txRetry:        defaultTxRetry,
+			healthInterval: defaultHealthCheckInterval,
+			queueSize:      defaultQueryChannelSize,
+			dialect:        dialect,
+		},
+	}
+}
+
+// SetDefaultTimeout 设置默认查询超时,未指定截止时间的操作使用.
+// Query/QueryRow只约束语句执行,返回的结果集读取不受约束.
+// 执行超时(含调用方ctx到期)统一返回ErrQueryTimeout,QueryRow由Scan返回,Open前调用
+func (m *DB) SetDefaultTimeout(d time.Duration) {
+	m.cfg.timeout = d
+}
+
+// SetTxRetry 设置事务死锁重试次数,Open前调用
+func (m *DB) SetTxRetry(n int) {
+	m.cfg.txRetry = n
+}
+
+// SetBatchInsert 开启INSERT合并,同一语句的连续单行INSERT合并为多行执行,
+// maxRows为单次合并的最大行数,maxDelay为首行入队后最长等待时间,仅MySQL方言生效,Open前调用.
+// 各行的LastInsertId按首行id递增推算,要求auto_increment_increment=1
+func (m *DB) SetBatchInsert(maxRows int, maxDelay time.Duration) {
+	m.cfg.batchRows = maxRows
+	m.cfg.batchDelay = maxDelay
+}
+
+// SetStmtCacheSize 开启预编译语句缓存,每个实例按LRU最多缓存size条,Open前调用
+func (m *DB) SetStmtCacheSize(size int) {
+	m.cfg.stmtCache = size
+}
+
+// SetReplicas 设置只读从库,Query/QueryRow/QueryAll走从库,Exec和事务走主库,
+// 读操作的ctx经WithPrimary标记时走主库,Open前调用
+func (m *DB) SetReplicas(dsns ...string) {
+	m.replicaDSNs = dsns
+}
+
+// SetQueueSize 设置每个实例的队列长度,Open前调用
+func (m *DB) SetQueueSize(size int) {
+	m.cfg.queueSize = size
+}
+
+// SetSpillDir 开启溢出,队列满时AsyncExec写入dir下的溢出文件而不阻塞主线程,
+// 积压按顺序回放,积压期间同一实例的其它操作排在积压之后.溢出不保证持久化,Open前调用
+func (m *DB) SetSpillDir(dir string) {
+	m.cfg.spillDir = dir
+}
+
+// SetHighWaterMark 队列积压达到容量的ratio时告警,主线程回调参数为dbIdx uint32, depth int,Open前调用
+func (m *DB) SetHighWaterMark(ratio float64, cb func([]interface{})) {
+	m.cfg.highWater = ratio
+	m.cfg.highWaterCb = cb
+}
+
+// SetJournal 开启预写日志,异步写操作入队前记录到path,执行成功后标记完成,
+// Open时回放上次未完成的记录,回放为至少一次,写操作应幂等.记录不fsync,断电时可能丢失最近的记录.
+// 参数须为基础类型、[]byte、time.Time或driver.Valuer,否则回调返回错误且不执行.每个DB需使用不同的path,Open前调用
+func (m *DB) SetJournal(path string) {
+	m.journalPath = path
+}
+
+// SetHealthCheck 设置健康检查间隔,Open前调用
+func (m *DB) SetHealthCheck(interval time.Duration) {
+	m.cfg.healthInterval = interval
+}
+
+// SetOpenRetry 启动时数据库不可用则按退避时间重试maxRetry次,退避时间不小于100ms,Open前调用
+func (m *DB) SetOpenRetry(maxRetry int, minBackoff time.Duration, maxBackoff time.Duration) {
+	m.cfg.openRetry = maxRetry
+	m.cfg.openMinBackoff = minBackoff
+	m.cfg.openMaxBackoff = maxBackoff
+}
+
+// SetCircuitBreaker 开启熔断,连续threshold次连接错误或超时后熔断,操作快速失败返回ErrCircuitOpen,
+// 冷却cooldown后放行一个操作试探恢复,QueryRow不作为试探.只统计主库的结果,从库执行的读操作不计入,Open前调用
+func (m *DB) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	m.cfg.breakerThreshold = threshold
+	m.cfg.breakerCooldown = cooldown
+}
+
+// SetStateCallback 熔断状态变化时主线程回调,回调参数为from, to CircuitState,Open前调用
+func (m *DB) SetStateCallback(cb func([]interface{})) {
+	m.stateCb = cb
+}
+
+// SetSlowQueryThreshold 设置慢查询阈值,耗时超过阈值的语句连同参数记录日志,Open前调用
+func (m *DB) SetSlowQueryThreshold(d time.Duration) {
+	m.stats.slow = d
+}
+
+// Dialect SQL方言
+func (m *DB) Dialect() Dialect {
+	return m.cfg.dialect
+}
+
+func (m *DB) procedure() virgo.IProcedure {
+	return m.p
+}
+
+// Stats 按语句聚合的执行统计,按总耗时降序
+func (m *DB) Stats() []*QueryStat {
+	return m.stats.snapshot()
+}
+
+// ResetStats 清空执行统计
+func (m *DB) ResetStats() {
+	m.stats.reset()
+}
+
+// QueueDepth 各实例队列中等待执行的操作数
+func (m *DB) QueueDepth() []int {
+	arr := make([]int, len(m.arrDb))
+	for i, pDb := range m.arrDb {
+		arr[i] = pDb.depth()
+	}
+	return arr
+}
+
+// CircuitState 当前熔断状态
+func (m *DB) CircuitState() CircuitState {
+	if m.breaker == nil {
+		return CircuitClosed
+	}
+	return m.breaker.current()
+}
+
+// Open 连接数据库
+func (m *DB) Open(dsn string, instNum int32) error {
+	var err error
+	m.arrDb = make([]*dbInstance, instNum)
+	var db *sql.DB
+	db, err = sql.Open(m.driverName, dsn)
+	if err != nil {
+		return err
+	}
+	backoff := m.cfg.openMinBackoff
+	if backoff < minOpenBackoff {
+		backoff = minOpenBackoff
+	}
+	for retry := 0; ; retry++ {
+		if err = db.Ping(); err == nil {
+			break
+		}
+		if retry >= m.cfg.openRetry {
+			db.Close()
+			return err
+		}
+		logger.Errorf("connect database error: %v, retry in %v", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > m.cfg.openMaxBackoff && m.cfg.openMaxBackoff >= minOpenBackoff {
+			backoff = m.cfg.openMaxBackoff
+		}
+	}
+	db.SetMaxOpenConns(int(instNum))
+	db.SetMaxIdleConns(int(instNum))
+	m.db = db
+
+	if len(m.replicaDSNs) > 0 {
+		if m.replicas, err = openReplicaSet(m.driverName, m.replicaDSNs, instNum); err != nil {
+			db.Close()
+			return err
+		}
+	}
+
+	if m.cfg.breakerThreshold > 0 {
+		m.breaker = newCircuitBreaker(m.cfg.breakerThreshold, m.cfg.breakerCooldown, m.cfg.isConnError, m.onCircuitChange)
+	}
+
+	var pending []*journalEntry
+	if m.journalPath != "" {
+		if m.journal, pending, err = openJournal(m.journalPath); err != nil {
+			if m.replicas != nil {
+				m.replicas.close()
+			}
+			db.Close()
+			return err
+		}
+	}
+
+	m.cancelAliveCtx, m.cancelAliveFunc = context.WithCancel(context.Background())
+	go m.keepAlive()
+
+	for i := int32(0); i < instNum; i++ {
+		pDbInst := new(dbInstance)
+		pDbInst.p = m.p
+		pDbInst.replicas = m.replicas
+		pDbInst.breaker = m.breaker
+		pDbInst.stats = m.stats
+		pDbInst.idx = uint32(i)
+		pDbInst.journal = m.journal
+		if err = pDbInst.open(db, m.wg, m.cfg); err != nil {
+			m.Close()
+			return err
+		}
+		m.arrDb[i] = pDbInst
+	}
+
+	if len(pending) > 0 {
+		logger.Warningf("replay %d unfinished operations from journal", len(pending))
+		for _, entry := range pending {
+			m.pushOperator(entry.op.dbIdx, &queryContext{
+				execCtx:   context.Background(),
+				query:     entry.op.query,
+				args:      entry.op.args,
+				queryType: queryTypeExec,
+				async:     true,
+				journalID: entry.id,
+			})
+		}
+	}
+
+	return err
+}
+
+// Migrate 以DB的驱动及方言执行未执行的结构迁移,启动时调用
+func (m *DB) Migrate(mg *Migrator) error {
+	mg.SetDriver(m.driverName, m.cfg.dialect)
+	n, err := mg.Up(m.db)
+	if err == nil && n > 0 {
+		logger.Infof("migrate: %d applied", n)
+	}
+	return err
+}
+
+// Close 关闭数据库连接
+func (m *DB) Close() {
+	if m.cancelAliveFunc != nil {
+		m.cancelAliveFunc()
+		m.cancelAliveFunc = nil
+	}
+	if m.arrDb != nil {
+		for _, pDb := range m.arrDb {
+			if pDb != nil {
+				pDb.close()
+			}
+		}
+	}
+	m.wg.Wait()
+	if m.journal != nil {
+		m.journal.close()
+	}
+	if m.replicas != nil {
+		m.replicas.close()
+	}
+	if m.db != nil {
+		m.db.Close()
+	}
+}
+
+// Query 查询多行
+func (m *DB) Query(dbIdx uint32, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	return m.QueryContext(context.Background(), dbIdx, query, args...)
+}
+
+// QueryContext 查询多行,ctx控制超时和取消
+func (m *DB) QueryContext(ctx context.Context, dbIdx uint32, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	callbackChan := m.pushOperator(dbIdx, &queryContext{
+		execCtx:   ctx,
+		query:     query,
+		args:      args,
+		queryType: queryTypeQuery,
+	})
+
+	ret := <-callbackChan
+	close(callbackChan)
+
+	if ret[0] != nil {
+		rows = ret[0].(*sql.Rows)
+	}
+	if ret[1] != nil {
+		err = ret[1].(error)
+	}
+
+	return
+}
+
+// QueryRow 查询一行
+func (m *DB) QueryRow(dbIdx uint32, query string, args ...interface{}) (row *sql.Row) {
+	return m.QueryRowContext(context.Background(), dbIdx, query, args...)
+}
+
+// QueryRowContext 查询一行,ctx控制超时和取消
+func (m *DB) QueryRowContext(ctx context.Context, dbIdx uint32, query string, args ...interface{}) (row *sql.Row) {
+	callbackChan := m.pushOperator(dbIdx, &queryContext{
+		execCtx:   ctx,
+		query:     query,
+		args:      args,
+		queryType: queryTypeQueryRow,
+	})
+
+	ret := <-callbackChan
+	close(callbackChan)
+
+	return ret[0].(*sql.Row)
+}
+
+// Exec 执行
+func (m *DB) Exec(dbIdx uint32, query string, args ...interface{}) (res sql.Result, err error) {
+	return m.ExecContext(context.Background(), dbIdx, query, args...)
+}
+
+// ExecContext 执行,ctx控制超时和取消
+func (m *DB) ExecContext(ctx context.Context, dbIdx uint32, query string, args ...interface{}) (res sql.Result, err error) {
+	callbackChan := m.pushOperator(dbIdx, &queryContext{
+		execCtx:   ctx,
+		query:     query,
+		args:      args,
+		queryType: queryTypeExec,
+	})
+
+	ret := <-callbackChan
+	close(callbackChan)
+
+	if ret[0] != nil {
+		res = ret[0].(sql.Result)
+	}
+	if ret[1] != nil {
+		err = ret[1].(error)
+	}
+
+	return
+}
+
+// AsyncQuery 查询多行,回调
+func (m *DB) AsyncQuery(ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{}) {
+	m.AsyncQueryContext(context.Background(), ctx, cb, dbIdx, query, args...)
+}
+
+// AsyncQueryContext 查询多行,回调,execCtx控制超时和取消
+func (m *DB) AsyncQueryContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{}) {
+	m.pushOperator(dbIdx, &queryContext{
+		execCtx:   execCtx,
+		query:     query,
+		args:      args,
+		queryType: queryTypeQuery,
+		async:     true,
+		ctx:       ctx,
+		cb:        cb,
+	})
+}
+
+// AsyncQueryRow 查询一行,回调
+func (m *DB) AsyncQueryRow(ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{}) {
+	m.AsyncQueryRowContext(context.Background(), ctx, cb, dbIdx, query, args...)
+}
+
+// AsyncQueryRowContext 查询一行,回调,execCtx控制超时和取消
+func (m *DB) AsyncQueryRowContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{}) {
+	m.pushOperator(dbIdx, &queryContext{
+		execCtx:   execCtx,
+		query:     query,
+		args:      args,
+		queryType: queryTypeQueryRow,
+		async:     true,
+		ctx:       ctx,
+		cb:        cb,
+	})
+}
+
+// AsyncExec 执行,回调
+func (m *DB) AsyncExec(ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{}) {
+	m.AsyncExecContext(context.Background(), ctx, cb, dbIdx, query, args...)
+}
+
+// TryAsyncExec 执行,回调,队列满时不阻塞,返回ErrQueueFull
+func (m *DB) TryAsyncExec(ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{}) error {
+	dbCount := uint32(len(m.arrDb))
+	if dbIdx >= dbCount {
+		dbIdx %= dbCount
+	}
+	queryCtx := &queryContext{
+		execCtx:   context.Background(),
+		query:     query,
+		args:      args,
+		queryType: queryTypeExec,
+		async:     true,
+		ctx:       ctx,
+		cb:        cb,
+	}
+	if err := m.journalAppend(dbIdx, queryCtx); err != nil {
+		return err
+	}
+	if !m.arrDb[dbIdx].tryAddQuery(queryCtx) {
+		if queryCtx.journalID != 0 {
+			m.journal.done(queryCtx.journalID)
+		}
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// AsyncExecContext 执行,回调,execCtx控制超时和取消,超时回调收到ErrQueryTimeout
+func (m *DB) AsyncExecContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, query string, args ...interface{}) {
+	m.pushOperator(dbIdx, &queryContext{
+		execCtx:   execCtx,
+		query:     query,
+		args:      args,
+		queryType: queryTypeExec,
+		async:     true,
+		ctx:       ctx,
+		cb:        cb,
+	})
+}
+
+// QueryAll 查询多行,在数据库协程内用scanner读取全部行,scanner为nil时使用ScanMap
+func (m *DB) QueryAll(dbIdx uint32, scanner RowScanner, query string, args ...interface{}) ([]interface{}, error) {
+	return m.QueryAllContext(context.Background(), dbIdx, scanner, query, args...)
+}
+
+// QueryAllContext 查询多行并读取全部行,ctx控制超时和取消
+func (m *DB) QueryAllContext(ctx context.Context, dbIdx uint32, scanner RowScanner, query string, args ...interface{}) (result []interface{}, err error) {
+	callbackChan := m.pushOperator(dbIdx, &queryContext{
+		execCtx:   ctx,
+		query:     query,
+		args:      args,
+		queryType: queryTypeScan,
+		scanner:   scanner,
+	})
+
+	ret := <-callbackChan
+	close(callbackChan)
+
+	if ret[0] != nil {
+		result = ret[0].([]interface{})
+	}
+	if ret[1] != nil {
+		err = ret[1].(error)
+	}
+
+	return
+}
+
+// AsyncQueryAll 查询多行,数据库协程内读取全部行后回调,回调参数为ctx, []interface{}, err
+func (m *DB) AsyncQueryAll(ctx interface{}, cb func([]interface{}), dbIdx uint32, scanner RowScanner, query string, args ...interface{}) {
+	m.AsyncQueryAllContext(context.Background(), ctx, cb, dbIdx, scanner, query, args...)
+}
+
+// AsyncQueryAllContext 查询多行并读取全部行,回调,execCtx控制超时和取消
+func (m *DB) AsyncQueryAllContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, scanner RowScanner, query string, args ...interface{}) {
+	m.pushOperator(dbIdx, &queryContext{
+		execCtx:   execCtx,
+		query:     query,
+		args:      args,
+		queryType: queryTypeScan,
+		async:     true,
+		ctx:       ctx,
+		cb:        cb,
+		scanner:   scanner,
+	})
+}
+
+// Prepare 在所有实例上预编译热点语句,常驻缓存不被淘汰
+func (m *DB) Prepare(query string) error {
+	arrChan := make([]chan []interface{}, 0, len(m.arrDb))
+	for i := range m.arrDb {
+		arrChan = append(arrChan, m.pushOperator(uint32(i), &queryContext{
+			execCtx:   context.Background(),
+			query:     query,
+			queryType: queryTypePrepare,
+		}))
+	}
+
+	var err error
+	for _, callbackChan := range arrChan {
+		ret := <-callbackChan
+		close(callbackChan)
+		if ret[1] != nil {
+			err = ret[1].(error)
+		}
+	}
+	return err
+}
+
+// Transaction 执行事务,f返回错误或panic时回滚,否则提交
+func (m *DB) Transaction(dbIdx uint32, f func(*sql.Tx) error) error {
+	return m.TransactionContext(context.Background(), dbIdx, f)
+}
+
+// TransactionContext 执行事务,ctx控制超时和取消
+func (m *DB) TransactionContext(ctx context.Context, dbIdx uint32, f func(*sql.Tx) error) (err error) {
+	callbackChan := m.pushOperator(dbIdx, &queryContext{
+		execCtx:   ctx,
+		queryType: queryTypeTx,
+		txFunc:    f,
+	})
+
+	ret := <-callbackChan
+	close(callbackChan)
+
+	if ret[1] != nil {
+		err = ret[1].(error)
+	}
+
+	return
+}
+
+// AsyncTransaction 执行事务,回调参数为ctx, err
+func (m *DB) AsyncTransaction(ctx interface{}, cb func([]interface{}), dbIdx uint32, f func(*sql.Tx) error) {
+	m.AsyncTransactionContext(context.Background(), ctx, cb, dbIdx, f)
+}
+
+// AsyncTransactionContext 执行事务,回调,execCtx控制超时和取消
+func (m *DB) AsyncTransactionContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), dbIdx uint32, f func(*sql.Tx) error) {
+	m.pushOperator(dbIdx, &queryContext{
+		execCtx:   execCtx,
+		queryType: queryTypeTx,
+		async:     true,
+		ctx:       ctx,
+		cb:        cb,
+		txFunc:    f,
+	})
+}
+
+func (m *DB) pushOperator(dbIdx uint32, queryCtx *queryContext) chan []interface{} {
+	dbCount := uint32(len(m.arrDb))
+	if dbIdx >= dbCount {
+		dbIdx %= dbCount
+	}
+
+	db := m.arrDb[dbIdx]
+	var callbackChan chan []interface{}
+	if !queryCtx.async {
+		callbackChan = make(chan []interface{}, 1)
+		queryCtx.callbackChan = callbackChan
+	} else if queryCtx.queryType == queryTypeExec && queryCtx.journalID == 0 {
+		if err := m.journalAppend(dbIdx, queryCtx); err != nil {
+			db.reply(queryCtx, nil, err)
+			return nil
+		}
+	}
+
+	db.addQuery(queryCtx)
+
+	return callbackChan
+}
+
+// journalAppend 异步写操作入队前记录预写日志,参数无法记录或写入失败时返回错误,操作不执行
+func (m *DB) journalAppend(dbIdx uint32, queryCtx *queryContext) error {
+	if m.journal == nil {
+		return nil
+	}
+	id, err := m.journal.append(&opRecord{dbIdx: dbIdx, query: queryCtx.query, args: queryCtx.args})
+	if err != nil {
+		return fmt.Errorf("database: write journal: %w", err)
+	}
+	queryCtx.journalID = id
+	return nil
+}
+
+// keepAlive 定时健康检查,熔断期间按冷却时间检查
+func (m *DB) keepAlive() {
+	timer := time.NewTimer(m.healthCheckDelay())
+	defer timer.Stop()
+	bQuit := false
+	for !bQuit {
+		select {
+		case <-timer.C:
+			ctx, cancel := context.WithTimeout(m.cancelAliveCtx, healthCheckTimeout)
+			err := m.db.PingContext(ctx)
+			cancel()
+			if err != nil {
+				logger.Errorf("database health check error: %v", err)
+			}
+			if m.breaker != nil && m.cancelAliveCtx.Err() == nil {
+				if err == nil || m.breaker.allow() {
+					m.breaker.record(err)
+				}
+			}
+			if m.replicas != nil {
+				m.replicas.ping(m.cancelAliveCtx)
+			}
+			timer.Reset(m.healthCheckDelay())
+		case <-m.cancelAliveCtx.Done():
+			bQuit = true
+		}
+	}
+}
+
+func (m *DB) healthCheckDelay() time.Duration {
+	if m.breaker != nil && m.breaker.current() != CircuitClosed && m.cfg.breakerCooldown < m.cfg.healthInterval {
+		return m.cfg.breakerCooldown
+	}
+	return m.cfg.healthInterval
+}
+
+func (m *DB) onCircuitChange(from CircuitState, to CircuitState) {
+	if to == CircuitOpen {
+		logger.Errorf("database circuit %v -> %v", from, to)
+	} else {
+		logger.Infof("database circuit %v -> %v", from, to)
+	}
+	if m.stateCb != nil {
+		m.p.SyncTask(m.stateCb, from, to)
+	}
+}
@@ -0,0 +1,218 @@
+package database_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/database/dbtest"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+func openTestDB(t *testing.T, p *virgotest.Procedure, instNum int32, setup func(*database.DB)) (*database.DB, *dbtest.Recorder) {
+	rec := dbtest.NewRecorder()
+	db := database.NewDB(p, dbtest.DriverName, database.MysqlDialect{})
+	if setup != nil {
+		setup(db)
+	}
+	if err := db.Open(rec.DSN(), instNum); err != nil {
+		t.Fatal(err)
+	}
+	return db, rec
+}
+
+func TestTimeout(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 1, func(db *database.DB) {
+		db.SetDefaultTimeout(time.Millisecond * 30)
+	})
+	defer db.Close()
+
+	rec.Expect("UPDATE slow").WillDelay(time.Second)
+	rec.Expect("SELECT slow").WillDelay(time.Second)
+	rec.Expect("SELECT fast").WillReturnRows([]string{"id"}, []interface{}{1}, []interface{}{2})
+
+	if _, err := db.Exec(0, "UPDATE slow SET a=1"); err != database.ErrQueryTimeout {
+		t.Fatalf("exec error %v", err)
+	}
+
+	// 调用方的截止时间同样归为超时
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err := db.QueryAllContext(ctx, 0, nil, "SELECT slow"); err != database.ErrQueryTimeout {
+		t.Fatalf("query all error %v", err)
+	}
+
+	// QueryRow的超时在回调中返回
+	var rowErr error
+	db.AsyncQueryRow(nil, func(args []interface{}) {
+		rowErr, _ = args[2].(error)
+	}, 0, "SELECT slow")
+	if !p.RunOne(time.Second) {
+		t.Fatal("callback not delivered")
+	}
+	if rowErr != database.ErrQueryTimeout {
+		t.Fatalf("query row error %v", rowErr)
+	}
+	var id int
+	if err := db.QueryRow(0, "SELECT slow").Scan(&id); err != database.ErrQueryTimeout {
+		t.Fatalf("query row scan error %v", err)
+	}
+	rowCtx, rowCancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer rowCancel()
+	if err := db.QueryRowContext(rowCtx, 0, "SELECT slow").Scan(&id); err != database.ErrQueryTimeout {
+		t.Fatalf("query row context scan error %v", err)
+	}
+
+	// 调用方可取消的ctx仍能中止默认超时内的执行
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*5, cancelFunc)
+	if _, err := db.ExecContext(cancelCtx, 0, "UPDATE slow SET a=2"); err != context.Canceled {
+		t.Fatalf("canceled exec error %v", err)
+	}
+
+	// 默认超时不约束结果集的读取
+	rows, err := db.Query(0, "SELECT fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 60)
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if err = rows.Err(); err != nil || n != 2 {
+		t.Fatalf("read %d rows, error %v", n, err)
+	}
+	rows.Close()
+
+	if err = db.QueryRow(0, "SELECT fast").Scan(&id); err != nil || id != 1 {
+		t.Fatalf("query row %d %v", id, err)
+	}
+}
+
+func TestCircuitBreakerQueryRow(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 1, func(db *database.DB) {
+		db.SetDefaultTimeout(time.Millisecond * 20)
+		db.SetCircuitBreaker(2, time.Minute)
+	})
+	defer db.Close()
+
+	// 连续超时后熔断,QueryRow同样快速失败
+	rec.Expect("SELECT slow").WillDelay(time.Second)
+	for i := 0; i < 2; i++ {
+		if _, err := db.QueryAll(0, nil, "SELECT slow"); err != database.ErrQueryTimeout {
+			t.Fatalf("query error %v", err)
+		}
+	}
+	if db.CircuitState() != database.CircuitOpen {
+		t.Fatalf("state %v", db.CircuitState())
+	}
+	var id int
+	if err := db.QueryRow(0, "SELECT fast").Scan(&id); err != database.ErrCircuitOpen {
+		t.Fatalf("query row error %v", err)
+	}
+	var rowErr error
+	db.AsyncQueryRow(nil, func(args []interface{}) {
+		rowErr, _ = args[2].(error)
+	}, 0, "SELECT fast")
+	p.RunOne(time.Second)
+	if rowErr != database.ErrCircuitOpen {
+		t.Fatalf("async query row error %v", rowErr)
+	}
+	if n := len(rec.Statements()); n != 2 {
+		t.Fatalf("statements %d", n)
+	}
+}
+
+func TestStats(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 2, func(db *database.DB) {
+		db.SetSlowQueryThreshold(time.Millisecond * 50)
+	})
+	defer db.Close()
+	rec.Expect("UPDATE slow").WillDelay(time.Millisecond * 100)
+
+	// 实例阻塞在慢语句上,后续操作在队列中等待
+	db.AsyncExec(nil, nil, 0, "UPDATE slow SET a=?", "%d")
+	time.Sleep(time.Millisecond * 20)
+	for i := 0; i < 3; i++ {
+		db.AsyncExec(nil, nil, 0, "UPDATE t SET a=?", i)
+	}
+	if depth := db.QueueDepth(); len(depth) != 2 || depth[0] != 3 || depth[1] != 0 {
+		t.Fatalf("queue depth %v", depth)
+	}
+	if _, err := db.QueryAll(0, nil, "SELECT a FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	if depth := db.QueueDepth(); depth[0] != 0 {
+		t.Fatalf("queue depth after drain %v", depth)
+	}
+
+	stats := db.Stats()
+	if len(stats) != 3 {
+		t.Fatalf("stats %d", len(stats))
+	}
+	if slow := stats[0]; slow.Query != "UPDATE slow SET a=?" || slow.Count != 1 || slow.P99 < time.Millisecond*100 {
+		t.Fatalf("slow stat %+v", slow)
+	}
+	for _, stat := range stats[1:] {
+		if stat.Query == "UPDATE t SET a=?" && stat.Count != 3 {
+			t.Fatalf("stat %+v", stat)
+		}
+	}
+	db.ResetStats()
+	if len(db.Stats()) != 0 {
+		t.Fatal("stats not reset")
+	}
+}
+
+type unsupportedArg struct{}
+
+func TestJournalReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db.journal")
+
+	// 超时的写操作结果未知,保留记录
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 1, func(db *database.DB) {
+		db.SetJournal(path)
+		db.SetDefaultTimeout(time.Millisecond * 20)
+	})
+	rec.Expect("UPDATE slow").WillDelay(time.Second)
+	db.AsyncExec(nil, nil, 0, "UPDATE slow SET a=?", 1)
+	db.AsyncExec(nil, nil, 0, "UPDATE fast SET a=?", 2)
+
+	// 无法记录的参数回调返回错误,不执行
+	var journalErr error
+	db.AsyncExec(nil, func(args []interface{}) {
+		journalErr, _ = args[2].(error)
+	}, 0, "UPDATE bad SET a=?", unsupportedArg{})
+	p.RunOne(time.Second)
+	if journalErr == nil {
+		t.Fatal("expected journal error")
+	}
+	db.Close()
+	if stmts := rec.Statements(); len(stmts) != 2 {
+		t.Fatalf("statements %v", stmts)
+	}
+
+	// 重新打开时回放未完成的记录
+	db, rec = openTestDB(t, p, 1, func(db *database.DB) {
+		db.SetJournal(path)
+	})
+	db.Close()
+	stmts := rec.Statements()
+	if len(stmts) != 1 || stmts[0].Query != "UPDATE slow SET a=?" || stmts[0].Args[0] != int64(1) {
+		t.Fatalf("replayed %v", stmts)
+	}
+}
@@ -0,0 +1,27 @@
+package dbtest
+
+import (
+	"github.com/panlibin/virgo"
+	"github.com/panlibin/virgo/database"
+)
+
+// NewDB 新建记录器并打开使用它的DB,方言为MySQL.需在Open前设置DB时使用DriverName及Recorder.DSN自行打开
+func NewDB(p virgo.IProcedure, instNum int32) (*database.DB, *Recorder, error) {
+	rec := NewRecorder()
+	db := database.NewDB(p, DriverName, database.MysqlDialect{})
+	if err := db.Open(rec.DSN(), instNum); err != nil {
+		return nil, nil, err
+	}
+	return db, rec, nil
+}
+
+// NewMemDB 新建使用内存引擎的DB,方言为MySQL,语句先匹配记录器的预设,未匹配时由引擎执行
+func NewMemDB(p virgo.IProcedure, instNum int32) (*database.DB, *Recorder, error) {
+	rec := NewRecorder()
+	rec.SetEngine(NewEngine())
+	db := database.NewDB(p, DriverName, database.MysqlDialect{})
+	if err := db.Open(rec.DSN(), instNum); err != nil {
+		return nil, nil, err
+	}
+	return db, rec, nil
+}
@@ -0,0 +1,170 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+type testUser struct {
+	ID    int64  `db:"id,pk,auto"`
+	Name  string `db:"name"`
+	Level int    `db:"level"`
+}
+
+func (testUser) TableName() string {
+	return "user"
+}
+
+func TestQueryAndExec(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec, err := NewDB(p, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rec.Expect("SELECT name FROM user WHERE id=?").WithArgs(1).
+		WillReturnRows([]string{"name"}, []interface{}{"alice"})
+	rec.Expect("UPDATE user").WillReturnResult(0, 1)
+
+	result, err := db.QueryAll(0, database.ScanMap, "SELECT name FROM user WHERE id=?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || result[0].(map[string]interface{})["name"] != "alice" {
+		t.Fatalf("unexpected result %v", result)
+	}
+
+	var affected int64
+	db.AsyncExec("ctx", func(args []interface{}) {
+		if args[0] != "ctx" || args[2] != nil {
+			t.Errorf("unexpected callback %v", args)
+			return
+		}
+		affected, _ = args[1].(sql.Result).RowsAffected()
+	}, 1, "UPDATE user SET level=? WHERE id=?", 2, 1)
+	if !p.RunOne(time.Second) {
+		t.Fatal("callback not delivered")
+	}
+	if affected != 1 {
+		t.Fatalf("rows affected %d", affected)
+	}
+
+	stmts := rec.Statements()
+	if len(stmts) != 2 || stmts[1].Args[0] != int64(2) {
+		t.Fatalf("unexpected statements %v", stmts)
+	}
+	if err = rec.Unmatched(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactionAndStrict(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec, err := NewDB(p, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rec.SetStrict(true)
+	rec.Expect(StmtBegin)
+	rec.Expect(StmtRollback)
+	errFail := errors.New("fail")
+	rec.Expect("INSERT INTO log").WillReturnError(errFail)
+
+	err = db.Transaction(0, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO log (msg) VALUES (?)", "x")
+		return err
+	})
+	if !errors.Is(err, errFail) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err = db.Exec(0, "DELETE FROM log"); !errors.Is(err, ErrUnexpected) {
+		t.Fatalf("strict mode error %v", err)
+	}
+}
+
+func TestMapper(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec, err := NewDB(p, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mp := database.NewMapper(db)
+	rec.Expect("INSERT INTO `user` (`name`,`level`)").WithArgs("bob", AnyArg).WillReturnResult(42, 1)
+	rec.Expect("FROM `user` WHERE `id`=?").WithArgs(42).
+		WillReturnRows([]string{"id", "name", "level"}, []interface{}{42, "bob", 3})
+
+	u := &testUser{Name: "bob", Level: 3}
+	if _, err = mp.Insert(0, u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 42 {
+		t.Fatalf("auto id not set: %d", u.ID)
+	}
+	got := &testUser{ID: 42}
+	if err = mp.Get(0, got); err != nil {
+		t.Fatal(err)
+	}
+	if *got != *u {
+		t.Fatalf("got %+v, want %+v", got, u)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	p := virgotest.NewProcedure()
+	rec := NewRecorder()
+	db := database.NewDB(p, DriverName, database.MysqlDialect{})
+	errDenied := errors.New("denied")
+	var ops []string
+	db.Use(func(next database.Handler) database.Handler {
+		return func(ctx context.Context, op *database.Operation) (interface{}, error) {
+			ops = append(ops, op.Type.String())
+			if strings.HasPrefix(op.Query, "DROP") {
+				return nil, errDenied
+			}
+			return next(ctx, op)
+		}
+	})
+	if err := db.Open(rec.DSN(), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var idb database.IDatabase = db
+	if _, err := idb.Exec(0, "DROP TABLE user"); !errors.Is(err, errDenied) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := idb.Transaction(0, func(*sql.Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// QueryRow被中间件拦截时错误在Scan时返回
+	var n int
+	if err := idb.QueryRow(0, "DROP TABLE user").Scan(&n); !errors.Is(err, errDenied) {
+		t.Fatalf("query row error %v", err)
+	}
+	var rowErr error
+	idb.AsyncQueryRow(nil, func(args []interface{}) {
+		rowErr = args[1].(*sql.Row).Scan(&n)
+	}, 0, "DROP TABLE user")
+	if !p.RunOne(time.Second) || !errors.Is(rowErr, errDenied) {
+		t.Fatalf("async query row error %v", rowErr)
+	}
+	if len(ops) != 4 || ops[0] != "exec" || ops[1] != "transaction" || ops[2] != "queryRow" {
+		t.Fatalf("unexpected ops %v", ops)
+	}
+	if stmts := rec.Statements(); len(stmts) != 2 || stmts[0].Query != StmtBegin {
+		t.Fatalf("unexpected statements %v", stmts)
+	}
+}
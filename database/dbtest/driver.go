@@ -0,0 +1,272 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DriverName 注册到database/sql的驱动名
+const DriverName = "dbtest"
+
+var (
+	recorders   sync.Map // dsn -> *Recorder
+	recorderSeq uint32
+)
+
+func init() {
+	sql.Register(DriverName, &mockDriver{})
+}
+
+// NewRecorder 新建记录器,通过DriverName及DSN()打开.
+// 记录器在最后一个打开它的sql.DB关闭时注销,之后不能再用同一DSN打开
+func NewRecorder() *Recorder {
+	r := &Recorder{
+		dsn: "recorder_" + strconv.Itoa(int(atomic.AddUint32(&recorderSeq, 1))),
+	}
+	recorders.Store(r.dsn, r)
+	return r
+}
+
+type mockDriver struct{}
+
+func (d *mockDriver) Open(dsn string) (driver.Conn, error) {
+	v, ok := recorders.Load(dsn)
+	if !ok {
+		return nil, errors.New("dbtest: unknown recorder " + dsn)
+	}
+	return &mockConn{rec: v.(*Recorder)}, nil
+}
+
+// OpenConnector sql.Open通过连接器打开,sql.DB关闭时关闭连接器以注销记录器
+func (d *mockDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	v, ok := recorders.Load(dsn)
+	if !ok {
+		return nil, errors.New("dbtest: unknown recorder " + dsn)
+	}
+	rec := v.(*Recorder)
+	atomic.AddInt32(&rec.refs, 1)
+	return &mockConnector{drv: d, rec: rec}, nil
+}
+
+type mockConnector struct {
+	drv  *mockDriver
+	rec  *Recorder
+	once sync.Once
+}
+
+func (c *mockConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &mockConn{rec: c.rec}, nil
+}
+
+func (c *mockConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+// Close 最后一个连接器关闭时注销记录器
+func (c *mockConnector) Close() error {
+	c.once.Do(func() {
+		if atomic.AddInt32(&c.rec.refs, -1) == 0 {
+			recorders.Delete(c.rec.dsn)
+		}
+	})
+	return nil
+}
+
+type mockConn struct {
+	rec *Recorder
+}
+
+func (c *mockConn) Prepare(query string) (driver.Stmt, error) {
+	c.rec.prepare(query)
+	return &mockStmt{conn: c, query: query}, nil
+}
+
+func (c *mockConn) Close() error {
+	return nil
+}
+
+func (c *mockConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *mockConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	_, engine, err := c.rec.handle(StmtBegin, nil)
+	if err != nil {
+		return nil, err
+	}
+	tx := &mockTx{conn: c}
+	if engine == nil {
+		engine = c.rec.Engine()
+	}
+	if engine != nil {
+		tx.engine = engine
+		tx.snapshot = engine.snapshot()
+	}
+	return tx, nil
+}
+
+func (c *mockConn) Ping(ctx context.Context) error {
+	return c.rec.ping()
+}
+
+func (c *mockConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	e, engine, err := c.rec.handle(query, namedValues(args))
+	if err == nil {
+		err = e.wait(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if engine != nil {
+		return engine.exec(query, namedValues(args))
+	}
+	if e == nil {
+		return &mockResult{}, nil
+	}
+	return &mockResult{lastInsertID: e.lastInsertID, rowsAffected: e.rowsAffected}, nil
+}
+
+func (c *mockConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	e, engine, err := c.rec.handle(query, namedValues(args))
+	if err == nil {
+		err = e.wait(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if engine != nil {
+		return engine.query(query, namedValues(args))
+	}
+	if e == nil {
+		return &mockRows{}, nil
+	}
+	rows := make([][]driver.Value, len(e.rows))
+	for i, row := range e.rows {
+		rows[i] = make([]driver.Value, len(row))
+		for j, v := range row {
+			if rows[i][j], err = driver.DefaultParameterConverter.ConvertValue(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &mockRows{columns: e.columns, rows: rows}, nil
+}
+
+type mockStmt struct {
+	conn   *mockConn
+	query  string
+	closed bool
+}
+
+func (s *mockStmt) Close() error {
+	if !s.closed {
+		s.closed = true
+		s.conn.rec.closeStmt()
+	}
+	return nil
+}
+
+// NumInput 不校验参数个数
+func (s *mockStmt) NumInput() int {
+	return -1
+}
+
+func (s *mockStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valuesToNamed(args))
+}
+
+func (s *mockStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valuesToNamed(args))
+}
+
+func (s *mockStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *mockStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+// mockTx 设置了引擎时在BEGIN保存表的快照,ROLLBACK时恢复
+type mockTx struct {
+	conn     *mockConn
+	engine   *Engine
+	snapshot map[string]*memTable
+}
+
+func (tx *mockTx) Commit() error {
+	_, _, err := tx.conn.rec.handle(StmtCommit, nil)
+	return err
+}
+
+func (tx *mockTx) Rollback() error {
+	_, _, err := tx.conn.rec.handle(StmtRollback, nil)
+	if err == nil && tx.engine != nil {
+		tx.engine.restore(tx.snapshot)
+	}
+	return err
+}
+
+type mockResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r *mockResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r *mockResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+type mockRows struct {
+	columns []string
+	rows    [][]driver.Value
+	pos     int
+}
+
+func (r *mockRows) Columns() []string {
+	return r.columns
+}
+
+func (r *mockRows) Close() error {
+	return nil
+}
+
+func (r *mockRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
+
+func namedValues(args []driver.NamedValue) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+func valuesToNamed(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
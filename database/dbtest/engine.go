@@ -0,0 +1,1158 @@
+package dbtest
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDuplicateKey 插入的主键已存在
+var ErrDuplicateKey = errors.New("dbtest: duplicate entry for primary key")
+
+// Engine 内存SQL引擎,支持单表的常用语句,用于验证写入后读取的结果:
+// CREATE TABLE(记录列的DEFAULT值) / DROP TABLE / TRUNCATE,
+// INSERT [IGNORE] ... VALUES ... [ON DUPLICATE KEY UPDATE | ON CONFLICT ... DO UPDATE SET | DO NOTHING], REPLACE,
+// SELECT 列|*|COUNT(*) FROM ... [WHERE] [ORDER BY] [LIMIT [OFFSET]], UPDATE ... SET ... [WHERE], DELETE.
+// 条件支持 AND/OR/NOT、比较、IN、BETWEEN、LIKE、IS NULL 及四则运算,
+// 标识符可用`或"引用,占位符为?或$n.只校验主键唯一,不支持JOIN、GROUP BY及子查询.
+// 事务不隔离,BEGIN时保存所有表的快照,ROLLBACK时恢复,期间其他连接的修改一并撤销
+type Engine struct {
+	mtx    sync.Mutex
+	tables map[string]*memTable
+}
+
+// NewEngine 新建
+func NewEngine() *Engine {
+	return &Engine{tables: make(map[string]*memTable)}
+}
+
+type memTable struct {
+	name     string
+	columns  []string
+	colIdx   map[string]int
+	defaults []sqlExpr
+	pk       []int
+	autoCol  int
+	autoNext int64
+	rows     [][]interface{}
+}
+
+func (t *memTable) column(name string) (int, error) {
+	idx, ok := t.colIdx[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("dbtest: unknown column %s in table %s", name, t.name)
+	}
+	return idx, nil
+}
+
+// conflict 与row主键相同的行序号,没有主键或没有冲突时返回-1
+func (t *memTable) conflict(row []interface{}, skip int) int {
+	if len(t.pk) == 0 {
+		return -1
+	}
+	for i, other := range t.rows {
+		if i == skip {
+			continue
+		}
+		same := true
+		for _, idx := range t.pk {
+			if c, ok := compareValues(row[idx], other[idx]); !ok || c != 0 {
+				same = false
+				break
+			}
+		}
+		if same {
+			return i
+		}
+	}
+	return -1
+}
+
+// snapshot 复制所有表,用于事务回滚
+func (e *Engine) snapshot() map[string]*memTable {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	tables := make(map[string]*memTable, len(e.tables))
+	for name, t := range e.tables {
+		cp := *t
+		cp.rows = make([][]interface{}, len(t.rows))
+		for i, row := range t.rows {
+			cp.rows[i] = make([]interface{}, len(row))
+			for j, v := range row {
+				cp.rows[i][j] = copyValue(v)
+			}
+		}
+		tables[name] = &cp
+	}
+	return tables
+}
+
+// restore 恢复到快照
+func (e *Engine) restore(tables map[string]*memTable) {
+	e.mtx.Lock()
+	e.tables = tables
+	e.mtx.Unlock()
+}
+
+// Tables 已创建的表名
+func (e *Engine) Tables() []string {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	arr := make([]string, 0, len(e.tables))
+	for _, t := range e.tables {
+		arr = append(arr, t.name)
+	}
+	sort.Strings(arr)
+	return arr
+}
+
+func (e *Engine) table(name string) (*memTable, error) {
+	t, ok := e.tables[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("dbtest: table %s doesn't exist", name)
+	}
+	return t, nil
+}
+
+// exec 执行语句
+func (e *Engine) exec(query string, args []interface{}) (driver.Result, error) {
+	p, err := newSQLParser(query, args)
+	if err != nil {
+		return nil, err
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	switch {
+	case p.keyword("CREATE"):
+		return e.create(p)
+	case p.keyword("DROP"):
+		return e.drop(p)
+	case p.keyword("TRUNCATE"):
+		p.keyword("TABLE")
+		t, err := e.tableArg(p)
+		if err != nil {
+			return nil, err
+		}
+		n := len(t.rows)
+		t.rows = nil
+		return &mockResult{rowsAffected: int64(n)}, nil
+	case p.keyword("INSERT"):
+		return e.insert(p, false)
+	case p.keyword("REPLACE"):
+		return e.insert(p, true)
+	case p.keyword("UPDATE"):
+		return e.update(p)
+	case p.keyword("DELETE"):
+		return e.delete(p)
+	case p.peekKeyword("SELECT"):
+		_, rows, err := e.selectRows(p)
+		if err != nil {
+			return nil, err
+		}
+		return &mockResult{rowsAffected: int64(len(rows))}, nil
+	}
+	return nil, p.unsupported()
+}
+
+// query 执行查询
+func (e *Engine) query(query string, args []interface{}) (driver.Rows, error) {
+	p, err := newSQLParser(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if !p.peekKeyword("SELECT") {
+		return nil, p.unsupported()
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	columns, rows, err := e.selectRows(p)
+	if err != nil {
+		return nil, err
+	}
+	return &mockRows{columns: columns, rows: rows}, nil
+}
+
+func (e *Engine) tableArg(p *sqlParser) (*memTable, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	return e.table(name)
+}
+
+func (e *Engine) create(p *sqlParser) (driver.Result, error) {
+	if !p.keyword("TABLE") {
+		return nil, p.unsupported()
+	}
+	ifNotExists := p.keyword("IF")
+	if ifNotExists && !(p.keyword("NOT") && p.keyword("EXISTS")) {
+		return nil, p.unsupported()
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if _, exist := e.tables[strings.ToLower(name)]; exist {
+		if ifNotExists {
+			return &mockResult{}, nil
+		}
+		return nil, fmt.Errorf("dbtest: table %s already exists", name)
+	}
+	if err = p.expect("("); err != nil {
+		return nil, err
+	}
+
+	t := &memTable{name: name, colIdx: make(map[string]int), autoCol: -1, autoNext: 1}
+	var pkNames []string
+	for {
+		switch {
+		case p.keyword("PRIMARY"):
+			if !p.keyword("KEY") {
+				return nil, p.unsupported()
+			}
+			if pkNames, err = p.identList(); err != nil {
+				return nil, err
+			}
+			p.skipDefinition()
+		case p.peekKeyword("UNIQUE") || p.peekKeyword("KEY") || p.peekKeyword("INDEX") ||
+			p.peekKeyword("CONSTRAINT") || p.peekKeyword("FOREIGN") || p.peekKeyword("CHECK"):
+			p.skipDefinition()
+		default:
+			col, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			idx := len(t.columns)
+			t.columns = append(t.columns, col)
+			t.colIdx[strings.ToLower(col)] = idx
+			t.defaults = append(t.defaults, nil)
+			for !p.peek(",") && !p.peek(")") && !p.done() {
+				switch {
+				case p.keyword("DEFAULT"):
+					if t.defaults[idx], err = p.unary(); err != nil {
+						return nil, err
+					}
+				case p.keyword("PRIMARY"):
+					p.keyword("KEY")
+					pkNames = []string{col}
+				case p.keyword("AUTO_INCREMENT") || p.keyword("AUTOINCREMENT"):
+					t.autoCol = idx
+				case p.peekKeyword("SERIAL") || p.peekKeyword("BIGSERIAL"):
+					p.next()
+					t.autoCol = idx
+				case p.peek("("):
+					p.skipParens()
+				default:
+					p.next()
+				}
+			}
+		}
+		if p.accept(",") {
+			continue
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		break
+	}
+	for _, pkName := range pkNames {
+		idx, err := t.column(pkName)
+		if err != nil {
+			return nil, err
+		}
+		t.pk = append(t.pk, idx)
+	}
+	// 单列整数主键未声明自增时同SQLite的rowid,未赋值时自动分配
+	if t.autoCol < 0 && len(t.pk) == 1 {
+		t.autoCol = t.pk[0]
+	}
+	e.tables[strings.ToLower(name)] = t
+	return &mockResult{}, nil
+}
+
+func (e *Engine) drop(p *sqlParser) (driver.Result, error) {
+	if !p.keyword("TABLE") {
+		return nil, p.unsupported()
+	}
+	ifExists := p.keyword("IF")
+	if ifExists && !p.keyword("EXISTS") {
+		return nil, p.unsupported()
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if _, exist := e.tables[strings.ToLower(name)]; !exist && !ifExists {
+		return nil, fmt.Errorf("dbtest: table %s doesn't exist", name)
+	}
+	delete(e.tables, strings.ToLower(name))
+	return &mockResult{}, nil
+}
+
+type assignment struct {
+	col  int
+	expr sqlExpr
+}
+
+func (e *Engine) assignments(p *sqlParser, t *memTable) ([]assignment, error) {
+	var arr []assignment
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if p.accept(".") {
+			if name, err = p.ident(); err != nil {
+				return nil, err
+			}
+		}
+		col, err := t.column(name)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("="); err != nil {
+			return nil, err
+		}
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, assignment{col: col, expr: expr})
+		if !p.accept(",") {
+			return arr, nil
+		}
+	}
+}
+
+func (e *Engine) insert(p *sqlParser, replace bool) (driver.Result, error) {
+	ignore := p.keyword("IGNORE")
+	if p.keyword("OR") {
+		switch {
+		case p.keyword("IGNORE"):
+			ignore = true
+		case p.keyword("REPLACE"):
+			replace = true
+		default:
+			return nil, p.unsupported()
+		}
+	}
+	if !p.keyword("INTO") {
+		return nil, p.unsupported()
+	}
+	t, err := e.tableArg(p)
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]int, len(t.columns))
+	for i := range cols {
+		cols[i] = i
+	}
+	if p.peek("(") {
+		names, err := p.identList()
+		if err != nil {
+			return nil, err
+		}
+		cols = cols[:0]
+		for _, name := range names {
+			idx, err := t.column(name)
+			if err != nil {
+				return nil, err
+			}
+			cols = append(cols, idx)
+		}
+	}
+	if !p.keyword("VALUES") && !p.keyword("VALUE") {
+		return nil, p.unsupported()
+	}
+	var tuples [][]interface{}
+	for {
+		if err = p.expect("("); err != nil {
+			return nil, err
+		}
+		tuple := make([]interface{}, 0, len(cols))
+		for {
+			expr, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			v, err := expr.eval(&evalContext{})
+			if err != nil {
+				return nil, err
+			}
+			tuple = append(tuple, v)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		if len(tuple) != len(cols) {
+			return nil, fmt.Errorf("dbtest: column count %d doesn't match value count %d", len(cols), len(tuple))
+		}
+		tuples = append(tuples, tuple)
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	var updates []assignment
+	doNothing := false
+	switch {
+	case p.keyword("ON"):
+		if p.keyword("DUPLICATE") {
+			if !p.keyword("KEY") || !p.keyword("UPDATE") {
+				return nil, p.unsupported()
+			}
+		} else if p.keyword("CONFLICT") {
+			if p.peek("(") {
+				if _, err = p.identList(); err != nil {
+					return nil, err
+				}
+			}
+			if !p.keyword("DO") {
+				return nil, p.unsupported()
+			}
+			if p.keyword("NOTHING") {
+				doNothing = true
+				break
+			}
+			if !p.keyword("UPDATE") || !p.keyword("SET") {
+				return nil, p.unsupported()
+			}
+		} else {
+			return nil, p.unsupported()
+		}
+		if updates, err = e.assignments(p, t); err != nil {
+			return nil, err
+		}
+	}
+	if err = p.end(); err != nil {
+		return nil, err
+	}
+
+	res := &mockResult{}
+	for _, tuple := range tuples {
+		row := make([]interface{}, len(t.columns))
+		for i, def := range t.defaults {
+			if def != nil {
+				if row[i], err = def.eval(&evalContext{}); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for i, col := range cols {
+			row[col] = tuple[i]
+		}
+		autoAssigned := false
+		if t.autoCol >= 0 {
+			if id, ok := toInt64(row[t.autoCol]); ok && id != 0 {
+				if id >= t.autoNext {
+					t.autoNext = id + 1
+				}
+			} else if row[t.autoCol] == nil || ok {
+				row[t.autoCol] = t.autoNext
+				autoAssigned = true
+			}
+		}
+
+		if idx := t.conflict(row, -1); idx >= 0 {
+			switch {
+			case replace:
+				t.rows = append(t.rows[:idx], t.rows[idx+1:]...)
+				res.rowsAffected++
+			case len(updates) > 0:
+				ctx := &evalContext{table: t, row: t.rows[idx], incoming: row}
+				updated := append([]interface{}(nil), t.rows[idx]...)
+				for _, a := range updates {
+					if updated[a.col], err = a.expr.eval(ctx); err != nil {
+						return nil, err
+					}
+				}
+				t.rows[idx] = updated
+				res.rowsAffected += 2
+				if autoAssigned {
+					t.autoNext--
+				}
+				continue
+			case ignore || doNothing:
+				if autoAssigned {
+					t.autoNext--
+				}
+				continue
+			default:
+				if autoAssigned {
+					t.autoNext--
+				}
+				return nil, fmt.Errorf("%w: table %s", ErrDuplicateKey, t.name)
+			}
+		}
+		if autoAssigned {
+			t.autoNext++
+			if res.lastInsertID == 0 {
+				// 同MySQL,多行插入返回第一行的自增id
+				res.lastInsertID = row[t.autoCol].(int64)
+			}
+		}
+		t.rows = append(t.rows, row)
+		res.rowsAffected++
+	}
+	return res, nil
+}
+
+func (e *Engine) update(p *sqlParser) (driver.Result, error) {
+	t, err := e.tableArg(p)
+	if err != nil {
+		return nil, err
+	}
+	if !p.keyword("SET") {
+		return nil, p.unsupported()
+	}
+	updates, err := e.assignments(p, t)
+	if err != nil {
+		return nil, err
+	}
+	where, err := p.where()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.end(); err != nil {
+		return nil, err
+	}
+
+	res := &mockResult{}
+	for i, row := range t.rows {
+		ctx := &evalContext{table: t, row: row}
+		if ok, err := where.match(ctx); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		updated := append([]interface{}(nil), row...)
+		for _, a := range updates {
+			if updated[a.col], err = a.expr.eval(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if t.conflict(updated, i) >= 0 {
+			return nil, fmt.Errorf("%w: table %s", ErrDuplicateKey, t.name)
+		}
+		t.rows[i] = updated
+		res.rowsAffected++
+	}
+	return res, nil
+}
+
+func (e *Engine) delete(p *sqlParser) (driver.Result, error) {
+	if !p.keyword("FROM") {
+		return nil, p.unsupported()
+	}
+	t, err := e.tableArg(p)
+	if err != nil {
+		return nil, err
+	}
+	where, err := p.where()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.end(); err != nil {
+		return nil, err
+	}
+
+	res := &mockResult{}
+	rows := t.rows[:0]
+	for _, row := range t.rows {
+		ok, err := where.match(&evalContext{table: t, row: row})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res.rowsAffected++
+		} else {
+			rows = append(rows, row)
+		}
+	}
+	t.rows = rows
+	return res, nil
+}
+
+type selectItem struct {
+	name  string
+	expr  sqlExpr
+	count bool
+	all   bool
+}
+
+type orderItem struct {
+	expr sqlExpr
+	desc bool
+}
+
+func (e *Engine) selectRows(p *sqlParser) ([]string, [][]driver.Value, error) {
+	p.keyword("SELECT")
+	p.keyword("DISTINCT")
+	var items []selectItem
+	for {
+		start := p.pos
+		var item selectItem
+		switch {
+		case p.accept("*"):
+			item.all = true
+		case p.peekKeyword("COUNT") && p.peekAt(1, "("):
+			p.next()
+			p.next()
+			if !p.accept("*") {
+				if _, err := p.expr(); err != nil {
+					return nil, nil, err
+				}
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, nil, err
+			}
+			item.count = true
+		default:
+			expr, err := p.expr()
+			if err != nil {
+				return nil, nil, err
+			}
+			item.expr = expr
+			if col, ok := expr.(*columnExpr); ok {
+				item.name = col.name
+			}
+		}
+		if item.name == "" {
+			item.name = p.text(start, p.pos)
+		}
+		if p.keyword("AS") || (p.peekIdent() && !p.peekKeyword("FROM")) {
+			alias, err := p.ident()
+			if err != nil {
+				return nil, nil, err
+			}
+			item.name = alias
+		}
+		items = append(items, item)
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	var t *memTable
+	if p.keyword("FROM") {
+		var err error
+		if t, err = e.tableArg(p); err != nil {
+			return nil, nil, err
+		}
+		if p.keyword("AS") || (p.peekIdent() && !p.peekKeyword("WHERE") && !p.peekKeyword("ORDER") &&
+			!p.peekKeyword("LIMIT") && !p.peekKeyword("FOR")) {
+			p.next()
+		}
+	}
+	where, err := p.where()
+	if err != nil {
+		return nil, nil, err
+	}
+	var orders []orderItem
+	if p.keyword("ORDER") {
+		if !p.keyword("BY") {
+			return nil, nil, p.unsupported()
+		}
+		for {
+			expr, err := p.expr()
+			if err != nil {
+				return nil, nil, err
+			}
+			order := orderItem{expr: expr}
+			if p.keyword("DESC") {
+				order.desc = true
+			} else {
+				p.keyword("ASC")
+			}
+			orders = append(orders, order)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	limit, offset := int64(-1), int64(0)
+	if p.keyword("LIMIT") {
+		if limit, err = p.intValue(); err != nil {
+			return nil, nil, err
+		}
+		if p.accept(",") {
+			offset = limit
+			if limit, err = p.intValue(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if p.keyword("OFFSET") {
+		if offset, err = p.intValue(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if p.keyword("FOR") {
+		p.keyword("UPDATE")
+	}
+	if err = p.end(); err != nil {
+		return nil, nil, err
+	}
+
+	var source [][]interface{}
+	if t != nil {
+		for _, row := range t.rows {
+			ok, err := where.match(&evalContext{table: t, row: row})
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				source = append(source, row)
+			}
+		}
+	} else {
+		source = [][]interface{}{nil}
+	}
+	if len(orders) > 0 {
+		var sortErr error
+		sort.SliceStable(source, func(i, j int) bool {
+			for _, order := range orders {
+				a, err := order.expr.eval(&evalContext{table: t, row: source[i]})
+				if err != nil {
+					sortErr = err
+					return false
+				}
+				b, err := order.expr.eval(&evalContext{table: t, row: source[j]})
+				if err != nil {
+					sortErr = err
+					return false
+				}
+				c := orderValues(a, b)
+				if c == 0 {
+					continue
+				}
+				return (c < 0) != order.desc
+			}
+			return false
+		})
+		if sortErr != nil {
+			return nil, nil, sortErr
+		}
+	}
+
+	var columns []string
+	for _, item := range items {
+		if item.all {
+			if t == nil {
+				return nil, nil, errors.New("dbtest: SELECT * requires a table")
+			}
+			columns = append(columns, t.columns...)
+		} else {
+			columns = append(columns, item.name)
+		}
+	}
+
+	// 聚合只支持COUNT,结果为一行
+	for _, item := range items {
+		if item.count {
+			if len(items) != 1 {
+				return nil, nil, errors.New("dbtest: COUNT(*) can't be mixed with other columns")
+			}
+			return columns, [][]driver.Value{{int64(len(source))}}, nil
+		}
+	}
+
+	if offset > int64(len(source)) {
+		offset = int64(len(source))
+	}
+	source = source[offset:]
+	if limit >= 0 && limit < int64(len(source)) {
+		source = source[:limit]
+	}
+	rows := make([][]driver.Value, 0, len(source))
+	for _, row := range source {
+		out := make([]driver.Value, 0, len(columns))
+		ctx := &evalContext{table: t, row: row}
+		for _, item := range items {
+			if item.all {
+				for _, v := range row {
+					out = append(out, copyValue(v))
+				}
+				continue
+			}
+			v, err := item.expr.eval(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+			out = append(out, copyValue(v))
+		}
+		rows = append(rows, out)
+	}
+	return columns, rows, nil
+}
+
+func copyValue(v interface{}) driver.Value {
+	if b, ok := v.([]byte); ok {
+		return append([]byte(nil), b...)
+	}
+	return v
+}
+
+// evalContext 求值环境,incoming为冲突时待插入的行,供VALUES(col)及EXCLUDED.col引用
+type evalContext struct {
+	table    *memTable
+	row      []interface{}
+	incoming []interface{}
+}
+
+type sqlExpr interface {
+	eval(ctx *evalContext) (interface{}, error)
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+func (e *literalExpr) eval(*evalContext) (interface{}, error) {
+	return e.value, nil
+}
+
+type columnExpr struct {
+	name     string
+	incoming bool
+}
+
+func (e *columnExpr) eval(ctx *evalContext) (interface{}, error) {
+	if ctx.table == nil {
+		return nil, fmt.Errorf("dbtest: unknown column %s", e.name)
+	}
+	idx, err := ctx.table.column(e.name)
+	if err != nil {
+		return nil, err
+	}
+	if e.incoming {
+		if ctx.incoming == nil {
+			return nil, fmt.Errorf("dbtest: VALUES(%s) outside upsert", e.name)
+		}
+		return ctx.incoming[idx], nil
+	}
+	return ctx.row[idx], nil
+}
+
+type unaryExpr struct {
+	op   string
+	expr sqlExpr
+}
+
+func (e *unaryExpr) eval(ctx *evalContext) (interface{}, error) {
+	v, err := e.expr.eval(ctx)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	if e.op == "NOT" {
+		return !truthy(v), nil
+	}
+	if n, ok := toInt64(v); ok {
+		return -n, nil
+	}
+	if f, ok := toFloat64(v); ok {
+		return -f, nil
+	}
+	return nil, fmt.Errorf("dbtest: can't negate %v", v)
+}
+
+type binaryExpr struct {
+	op          string
+	left, right sqlExpr
+}
+
+func (e *binaryExpr) eval(ctx *evalContext) (interface{}, error) {
+	a, err := e.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "AND":
+		if !truthy(a) {
+			return false, nil
+		}
+		b, err := e.right.eval(ctx)
+		return truthy(b), err
+	case "OR":
+		if truthy(a) {
+			return true, nil
+		}
+		b, err := e.right.eval(ctx)
+		return truthy(b), err
+	}
+
+	b, err := e.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		c, ok := compareValues(a, b)
+		if !ok {
+			return false, nil
+		}
+		switch e.op {
+		case "=":
+			return c == 0, nil
+		case "!=", "<>":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case "LIKE":
+		if a == nil || b == nil {
+			return false, nil
+		}
+		return likeMatch(valueString(a), valueString(b)), nil
+	}
+	return arithmetic(e.op, a, b)
+}
+
+type inExpr struct {
+	expr sqlExpr
+	list []sqlExpr
+	not  bool
+}
+
+func (e *inExpr) eval(ctx *evalContext) (interface{}, error) {
+	v, err := e.expr.eval(ctx)
+	if err != nil || v == nil {
+		return false, err
+	}
+	for _, item := range e.list {
+		other, err := item.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := compareValues(v, other); ok && c == 0 {
+			return !e.not, nil
+		}
+	}
+	return e.not, nil
+}
+
+type isNullExpr struct {
+	expr sqlExpr
+	not  bool
+}
+
+func (e *isNullExpr) eval(ctx *evalContext) (interface{}, error) {
+	v, err := e.expr.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (v == nil) != e.not, nil
+}
+
+// whereClause 条件为空时匹配所有行
+type whereClause struct {
+	cond sqlExpr
+}
+
+func (w *whereClause) match(ctx *evalContext) (bool, error) {
+	if w.cond == nil {
+		return true, nil
+	}
+	v, err := w.cond.eval(ctx)
+	return truthy(v), err
+}
+
+func truthy(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	}
+	if f, ok := toFloat64(v); ok {
+		return f != 0
+	}
+	return false
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return x, true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+		return n, err == nil
+	case []byte:
+		n, err := strconv.ParseInt(strings.TrimSpace(string(x)), 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int64, bool:
+		n, _ := toInt64(x)
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(x)), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func valueString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
+
+// compareValues 比较两个值,任一为NULL或类型无法比较时ok为false.
+// 数值与可解析为数值的字符串按数值比较
+func compareValues(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			switch {
+			case ta.Before(tb):
+				return -1, true
+			case ta.After(tb):
+				return 1, true
+			}
+			return 0, true
+		}
+		a = valueString(ta)
+	}
+	if tb, ok := b.(time.Time); ok {
+		b = valueString(tb)
+	}
+	_, aStr := a.(string)
+	_, aBytes := a.([]byte)
+	_, bStr := b.(string)
+	_, bBytes := b.([]byte)
+	if (aStr || aBytes) && (bStr || bBytes) {
+		return bytes.Compare([]byte(valueString(a)), []byte(valueString(b))), true
+	}
+	if ia, ok := toInt64(a); ok {
+		if ib, ok := toInt64(b); ok {
+			switch {
+			case ia < ib:
+				return -1, true
+			case ia > ib:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	fa, okA := toFloat64(a)
+	fb, okB := toFloat64(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	switch {
+	case fa < fb:
+		return -1, true
+	case fa > fb:
+		return 1, true
+	}
+	return 0, true
+}
+
+// orderValues 排序比较,NULL排在最前
+func orderValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := compareValues(a, b)
+	return c
+}
+
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	if a == nil || b == nil {
+		return nil, nil
+	}
+	if ia, ok := a.(int64); ok {
+		if ib, ok := b.(int64); ok {
+			switch op {
+			case "+":
+				return ia + ib, nil
+			case "-":
+				return ia - ib, nil
+			case "*":
+				return ia * ib, nil
+			}
+		}
+	}
+	fa, okA := toFloat64(a)
+	fb, okB := toFloat64(b)
+	if !okA || !okB {
+		return nil, fmt.Errorf("dbtest: invalid operands %v %s %v", a, op, b)
+	}
+	switch op {
+	case "+":
+		return fa + fb, nil
+	case "-":
+		return fa - fb, nil
+	case "*":
+		return fa * fb, nil
+	case "/":
+		if fb == 0 {
+			return nil, nil
+		}
+		return fa / fb, nil
+	}
+	return nil, fmt.Errorf("dbtest: unsupported operator %s", op)
+}
+
+// likeMatch %匹配任意个字符,_匹配单个字符,不区分大小写
+func likeMatch(s, pattern string) bool {
+	s, pattern = strings.ToLower(s), strings.ToLower(pattern)
+	if pattern == "" {
+		return s == ""
+	}
+	switch pattern[0] {
+	case '%':
+		for i := 0; i <= len(s); i++ {
+			if likeMatch(s[i:], pattern[1:]) {
+				return true
+			}
+		}
+		return false
+	case '_':
+		return s != "" && likeMatch(s[1:], pattern[1:])
+	}
+	return s != "" && s[0] == pattern[0] && likeMatch(s[1:], pattern[1:])
+}
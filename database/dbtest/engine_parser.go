@@ -0,0 +1,617 @@
+package dbtest
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tokIdent = iota
+	tokQuoted
+	tokString
+	tokNumber
+	tokParam
+	tokSymbol
+)
+
+type sqlToken struct {
+	kind   int
+	text   string
+	value  interface{}
+	double bool
+	start  int
+	end    int
+}
+
+// sqlParser 内存引擎的语句解析,占位符在分词时替换为参数值
+type sqlParser struct {
+	query string
+	toks  []sqlToken
+	pos   int
+}
+
+func newSQLParser(query string, args []interface{}) (*sqlParser, error) {
+	p := &sqlParser{query: query}
+	argIdx := 0
+	for i := 0; i < len(query); {
+		c := query[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && strings.HasPrefix(query[i:], "--"), c == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+		case c == '\'':
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(query) {
+					return nil, fmt.Errorf("dbtest: unterminated string in %s", query)
+				}
+				if query[i] == '\\' && i+1 < len(query) {
+					sb.WriteByte(unescape(query[i+1]))
+					i += 2
+					continue
+				}
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						sb.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(query[i])
+				i++
+			}
+			p.toks = append(p.toks, sqlToken{kind: tokString, value: sb.String(), start: start, end: i})
+		case c == '`' || c == '"':
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(query) {
+					return nil, fmt.Errorf("dbtest: unterminated identifier in %s", query)
+				}
+				if query[i] == c {
+					if i+1 < len(query) && query[i+1] == c {
+						sb.WriteByte(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(query[i])
+				i++
+			}
+			p.toks = append(p.toks, sqlToken{kind: tokQuoted, text: sb.String(), double: c == '"', start: start, end: i})
+		case c == '?' || (c == '$' && i+1 < len(query) && isDigit(query[i+1])):
+			idx := argIdx
+			i++
+			if c == '$' {
+				for i < len(query) && isDigit(query[i]) {
+					i++
+				}
+				n, _ := strconv.Atoi(query[start+1 : i])
+				idx = n - 1
+			} else {
+				argIdx++
+			}
+			if idx < 0 || idx >= len(args) {
+				return nil, fmt.Errorf("dbtest: missing argument %d for %s", idx+1, query)
+			}
+			p.toks = append(p.toks, sqlToken{kind: tokParam, value: args[idx], start: start, end: i})
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			for i < len(query) && (isDigit(query[i]) || query[i] == '.') {
+				i++
+			}
+			text := query[start:i]
+			var value interface{}
+			if strings.Contains(text, ".") {
+				f, err := strconv.ParseFloat(text, 64)
+				if err != nil {
+					return nil, fmt.Errorf("dbtest: invalid number %s", text)
+				}
+				value = f
+			} else {
+				// 超出int64的整数同MySQL按小数处理
+				if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+					value = n
+				} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+					value = f
+				} else {
+					return nil, fmt.Errorf("dbtest: invalid number %s", text)
+				}
+			}
+			p.toks = append(p.toks, sqlToken{kind: tokNumber, text: text, value: value, start: start, end: i})
+		case isIdentChar(c):
+			for i < len(query) && (isIdentChar(query[i]) || isDigit(query[i])) {
+				i++
+			}
+			p.toks = append(p.toks, sqlToken{kind: tokIdent, text: query[start:i], start: start, end: i})
+		default:
+			i++
+			if i < len(query) {
+				switch query[start : i+1] {
+				case "<=", ">=", "<>", "!=":
+					i++
+				}
+			}
+			p.toks = append(p.toks, sqlToken{kind: tokSymbol, text: query[start:i], start: start, end: i})
+		}
+	}
+	return p, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	case '0':
+		return 0
+	}
+	return c
+}
+
+func (p *sqlParser) done() bool {
+	return p.pos >= len(p.toks)
+}
+
+func (p *sqlParser) next() *sqlToken {
+	if p.done() {
+		return nil
+	}
+	p.pos++
+	return &p.toks[p.pos-1]
+}
+
+func (p *sqlParser) tokenAt(offset int) *sqlToken {
+	if p.pos+offset >= len(p.toks) {
+		return nil
+	}
+	return &p.toks[p.pos+offset]
+}
+
+func (p *sqlParser) peekAt(offset int, symbol string) bool {
+	tok := p.tokenAt(offset)
+	return tok != nil && tok.kind == tokSymbol && tok.text == symbol
+}
+
+func (p *sqlParser) peek(symbol string) bool {
+	return p.peekAt(0, symbol)
+}
+
+func (p *sqlParser) accept(symbol string) bool {
+	if p.peek(symbol) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expect(symbol string) error {
+	if !p.accept(symbol) {
+		return p.unsupported()
+	}
+	return nil
+}
+
+func (p *sqlParser) keywordAt(offset int, kw string) bool {
+	tok := p.tokenAt(offset)
+	return tok != nil && tok.kind == tokIdent && strings.EqualFold(tok.text, kw)
+}
+
+func (p *sqlParser) peekKeyword(kw string) bool {
+	return p.keywordAt(0, kw)
+}
+
+// keyword 下一个词为kw时跳过并返回true
+func (p *sqlParser) keyword(kw string) bool {
+	if p.peekKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) peekIdent() bool {
+	tok := p.tokenAt(0)
+	return tok != nil && (tok.kind == tokIdent || tok.kind == tokQuoted)
+}
+
+func (p *sqlParser) ident() (string, error) {
+	if !p.peekIdent() {
+		return "", p.unsupported()
+	}
+	return p.next().text, nil
+}
+
+// identList 括号内的标识符列表,忽略索引列的长度及排序
+func (p *sqlParser) identList() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var arr []string
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, name)
+		if p.peek("(") {
+			p.skipParens()
+		}
+		if !p.keyword("ASC") {
+			p.keyword("DESC")
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	return arr, p.expect(")")
+}
+
+func (p *sqlParser) skipParens() {
+	depth := 0
+	for !p.done() {
+		tok := p.next()
+		if tok.kind != tokSymbol {
+			continue
+		}
+		if tok.text == "(" {
+			depth++
+		} else if tok.text == ")" {
+			if depth--; depth <= 0 {
+				return
+			}
+		}
+	}
+}
+
+// skipDefinition 跳过建表语句中的一项定义,停在同层的逗号或右括号前
+func (p *sqlParser) skipDefinition() {
+	for !p.done() && !p.peek(",") && !p.peek(")") {
+		if p.peek("(") {
+			p.skipParens()
+		} else {
+			p.next()
+		}
+	}
+}
+
+// end 语句结束,允许末尾的分号
+func (p *sqlParser) end() error {
+	p.accept(";")
+	if !p.done() {
+		return p.unsupported()
+	}
+	return nil
+}
+
+func (p *sqlParser) unsupported() error {
+	near := "end of statement"
+	if tok := p.tokenAt(0); tok != nil {
+		near = p.query[tok.start:]
+		if len(near) > 32 {
+			near = near[:32]
+		}
+	}
+	return fmt.Errorf("dbtest: unsupported statement near %q: %s", near, p.query)
+}
+
+// text 第start到end个词对应的原文
+func (p *sqlParser) text(start, end int) string {
+	if start >= end {
+		return ""
+	}
+	return p.query[p.toks[start].start:p.toks[end-1].end]
+}
+
+// intValue 整数或占位符,超出int64时取最大值,用于LIMIT 18446744073709551615
+func (p *sqlParser) intValue() (int64, error) {
+	neg := p.accept("-")
+	tok := p.next()
+	if tok == nil || (tok.kind != tokNumber && tok.kind != tokParam) {
+		return 0, p.unsupported()
+	}
+	n, ok := toInt64(tok.value)
+	if f, isFloat := tok.value.(float64); isFloat && f == math.Trunc(f) {
+		n, ok = math.MaxInt64, true
+		if f < math.MaxInt64 {
+			n = int64(f)
+		}
+	}
+	if !ok {
+		return 0, fmt.Errorf("dbtest: invalid integer %v", tok.value)
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+func (p *sqlParser) where() (*whereClause, error) {
+	if !p.keyword("WHERE") {
+		return &whereClause{}, nil
+	}
+	cond, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &whereClause{cond: cond}, nil
+}
+
+func (p *sqlParser) expr() (sqlExpr, error) {
+	left, err := p.andExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.andExpr()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *sqlParser) andExpr() (sqlExpr, error) {
+	left, err := p.notExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *sqlParser) notExpr() (sqlExpr, error) {
+	if p.keyword("NOT") {
+		expr, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "NOT", expr: expr}, nil
+	}
+	return p.comparison()
+}
+
+func (p *sqlParser) comparison() (sqlExpr, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"=", "!=", "<>", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.additive()
+			if err != nil {
+				return nil, err
+			}
+			return &binaryExpr{op: op, left: left, right: right}, nil
+		}
+	}
+	if p.keyword("IS") {
+		not := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, p.unsupported()
+		}
+		return &isNullExpr{expr: left, not: not}, nil
+	}
+
+	not := false
+	if p.peekKeyword("NOT") && (p.keywordAt(1, "IN") || p.keywordAt(1, "LIKE") || p.keywordAt(1, "BETWEEN")) {
+		p.next()
+		not = true
+	}
+	var expr sqlExpr
+	switch {
+	case p.keyword("IN"):
+		if err = p.expect("("); err != nil {
+			return nil, err
+		}
+		in := &inExpr{expr: left, not: not}
+		for {
+			item, err := p.additive()
+			if err != nil {
+				return nil, err
+			}
+			in.list = append(in.list, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+		return in, p.expect(")")
+	case p.keyword("LIKE"):
+		right, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		expr = &binaryExpr{op: "LIKE", left: left, right: right}
+	case p.keyword("BETWEEN"):
+		low, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, p.unsupported()
+		}
+		high, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		expr = &binaryExpr{
+			op:    "AND",
+			left:  &binaryExpr{op: ">=", left: left, right: low},
+			right: &binaryExpr{op: "<=", left: left, right: high},
+		}
+	default:
+		return left, nil
+	}
+	if not {
+		expr = &unaryExpr{op: "NOT", expr: expr}
+	}
+	return expr, nil
+}
+
+func (p *sqlParser) additive() (sqlExpr, error) {
+	left, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("+") || p.peek("-") {
+		op := p.next().text
+		right, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *sqlParser) multiplicative() (sqlExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("*") || p.peek("/") {
+		op := p.next().text
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *sqlParser) unary() (sqlExpr, error) {
+	if p.accept("-") {
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "-", expr: expr}, nil
+	}
+	p.accept("+")
+	return p.primary()
+}
+
+func (p *sqlParser) primary() (sqlExpr, error) {
+	tok := p.next()
+	if tok == nil {
+		return nil, p.unsupported()
+	}
+	switch tok.kind {
+	case tokNumber, tokString, tokParam:
+		return &literalExpr{value: tok.value}, nil
+	case tokSymbol:
+		if tok.text != "(" {
+			p.pos--
+			return nil, p.unsupported()
+		}
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	case tokIdent:
+		switch strings.ToUpper(tok.text) {
+		case "NULL":
+			return &literalExpr{}, nil
+		case "TRUE":
+			return &literalExpr{value: true}, nil
+		case "FALSE":
+			return &literalExpr{value: false}, nil
+		case "CURRENT_TIMESTAMP":
+			if p.accept("(") {
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+			}
+			return &nowExpr{}, nil
+		}
+		if p.accept("(") {
+			return p.function(tok.text)
+		}
+	}
+
+	name := tok.text
+	if p.accept(".") {
+		qualifier := name
+		var err error
+		if name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		return &columnExpr{name: name, incoming: strings.EqualFold(qualifier, "EXCLUDED")}, nil
+	}
+	if tok.double {
+		return &quotedExpr{columnExpr{name: name}}, nil
+	}
+	return &columnExpr{name: name}, nil
+}
+
+func (p *sqlParser) function(name string) (sqlExpr, error) {
+	switch strings.ToUpper(name) {
+	case "VALUES":
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		return &columnExpr{name: col, incoming: true}, p.expect(")")
+	case "NOW":
+		return &nowExpr{}, p.expect(")")
+	}
+	return nil, fmt.Errorf("dbtest: unsupported function %s in %s", name, p.query)
+}
+
+type nowExpr struct{}
+
+func (nowExpr) eval(*evalContext) (interface{}, error) {
+	return time.Now().UTC(), nil
+}
+
+// quotedExpr 双引号在PostgreSQL/SQLite中为标识符,在MySQL中为字符串,不是已知列时按字符串处理
+type quotedExpr struct {
+	columnExpr
+}
+
+func (e *quotedExpr) eval(ctx *evalContext) (interface{}, error) {
+	if ctx.table != nil {
+		if _, err := ctx.table.column(e.name); err == nil {
+			return e.columnExpr.eval(ctx)
+		}
+	}
+	return e.name, nil
+}
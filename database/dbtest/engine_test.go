@@ -0,0 +1,223 @@
+package dbtest
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+const createUser = "CREATE TABLE IF NOT EXISTS `user` (" +
+	"`id` BIGINT NOT NULL AUTO_INCREMENT, `name` VARCHAR(32) NOT NULL DEFAULT '', `level` INT NOT NULL DEFAULT 0, " +
+	"PRIMARY KEY (`id`), KEY `idx_level` (`level`)) ENGINE=InnoDB"
+
+func openMemDB(t *testing.T) (*database.DB, *Recorder) {
+	db, rec, err := NewMemDB(virgotest.NewProcedure(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(0, createUser); err != nil {
+		t.Fatal(err)
+	}
+	return db, rec
+}
+
+func TestEngine(t *testing.T) {
+	db, rec := openMemDB(t)
+	defer db.Close()
+
+	res, err := db.Exec(0, "INSERT INTO `user` (`name`,`level`) VALUES (?,?),(?,?)", "alice", 3, "bob", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := res.LastInsertId(); id != 1 {
+		t.Fatalf("last insert id %d", id)
+	}
+	if _, err = db.Exec(0, "INSERT INTO user (id,name) VALUES (10,'carol')"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = db.Exec(0, "INSERT INTO user (name) VALUES ('dave')"); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := res.LastInsertId(); id != 11 {
+		t.Fatalf("auto increment after explicit id: %d", id)
+	}
+	if _, err = db.Exec(0, "INSERT INTO user (id,name) VALUES (1,'eve')"); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("duplicate key error %v", err)
+	}
+
+	// ON DUPLICATE KEY UPDATE 更新已存在的行
+	if res, err = db.Exec(0, "INSERT INTO user (id,name,level) VALUES (?,?,?) ON DUPLICATE KEY UPDATE level=level+VALUES(level)", 2, "bob", 4); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Fatalf("upsert rows affected %d", n)
+	}
+
+	var level int
+	if err = db.QueryRow(0, "SELECT level FROM user WHERE name=?", "bob").Scan(&level); err != nil || level != 5 {
+		t.Fatalf("level %d, err %v", level, err)
+	}
+	if res, err = db.Exec(0, "UPDATE user SET level=level*2 WHERE id IN (?,?) AND NOT name LIKE 'c%'", 1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Fatalf("update rows affected %d", n)
+	}
+	if _, err = db.Exec(0, "DELETE FROM user WHERE level BETWEEN 0 AND 0 AND id>10"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.QueryAll(0, database.ScanMap, "SELECT id, name AS n FROM user WHERE level>=? ORDER BY level DESC, id LIMIT 2", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		map[string]interface{}{"id": int64(1), "n": "alice"},
+		map[string]interface{}{"id": int64(2), "n": "bob"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result %v", result)
+	}
+	var id int64
+	if err = db.QueryRow(0, "SELECT id FROM user ORDER BY id LIMIT 18446744073709551615 OFFSET 2").Scan(&id); err != nil || id != 10 {
+		t.Fatalf("offset without limit: %d, err %v", id, err)
+	}
+	var count int
+	if err = db.QueryRow(0, "SELECT COUNT(*) FROM user").Scan(&count); err != nil || count != 3 {
+		t.Fatalf("count %d, err %v", count, err)
+	}
+
+	// 预设优先于引擎,用于注入错误
+	errFail := errors.New("fail")
+	rec.Expect("DELETE FROM user").WillReturnError(errFail)
+	if _, err = db.Exec(0, "DELETE FROM user"); err != errFail {
+		t.Fatalf("expectation error %v", err)
+	}
+	if _, err = db.Exec(0, "SELECT * FROM missing"); err == nil {
+		t.Fatal("missing table")
+	}
+}
+
+func TestEngineTransaction(t *testing.T) {
+	db, _ := openMemDB(t)
+	defer db.Close()
+
+	if err := db.Transaction(0, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO user (name) VALUES ('alice')")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 回滚撤销事务内的修改
+	errAbort := errors.New("abort")
+	if err := db.Transaction(0, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO user (name) VALUES ('bob')"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE user SET level=9"); err != nil {
+			return err
+		}
+		return errAbort
+	}); err != errAbort {
+		t.Fatalf("transaction error %v", err)
+	}
+	result, err := db.QueryAll(0, database.ScanMap, "SELECT name, level FROM user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{map[string]interface{}{"name": "alice", "level": int64(0)}}; !reflect.DeepEqual(result, want) {
+		t.Fatalf("result after rollback %v", result)
+	}
+}
+
+func TestEngineMapper(t *testing.T) {
+	db, _ := openMemDB(t)
+	defer db.Close()
+
+	mp := database.NewMapper(db)
+	u := &testUser{Name: "alice", Level: 1}
+	if _, err := mp.Insert(0, u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 1 {
+		t.Fatalf("auto id %d", u.ID)
+	}
+	u.Level = 2
+	if _, err := mp.Update(0, u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mp.Upsert(0, &testUser{ID: 5, Name: "bob", Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &testUser{ID: 1}
+	if err := mp.Get(0, got); err != nil || *got != *u {
+		t.Fatalf("got %+v, err %v", got, err)
+	}
+	var users []testUser
+	if err := mp.Select(0, &users, "`level`>? ORDER BY `id`", 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[1] != (testUser{ID: 5, Name: "bob", Level: 3}) {
+		t.Fatalf("users %+v", users)
+	}
+	if _, err := mp.Delete(0, u); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Get(0, &testUser{ID: 1}); err != sql.ErrNoRows {
+		t.Fatalf("deleted row error %v", err)
+	}
+}
+
+func TestEngineWriteBehind(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec, err := NewMemDB(p, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(0, createUser); err != nil {
+		t.Fatal(err)
+	}
+
+	w := database.NewWriteBehind(p, db, 0, 10)
+	u1 := &testUser{ID: 1, Name: "alice"}
+	u2 := &testUser{ID: 2, Name: "bob"}
+	w.Register(1, 0, u1)
+	w.Register(2, 0, u2)
+	u1.Level = 3
+	w.MarkDirty(1, "level")
+	u2.Level = 4
+	w.MarkDirty(2, "name", "level")
+	if err = w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	var users []testUser
+	if err = database.NewMapper(db).Select(0, &users, "1=1 ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	// 只写入脏列,未写入的列为默认值
+	if len(users) != 2 || users[0] != (testUser{ID: 1, Level: 3}) || users[1] != *u2 {
+		t.Fatalf("users %+v, statements %v", users, rec.Statements())
+	}
+}
+
+func TestRecorderRelease(t *testing.T) {
+	db, rec, err := NewMemDB(virgotest.NewProcedure(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := recorders.Load(rec.DSN()); !ok {
+		t.Fatal("recorder not registered")
+	}
+	db.Close()
+	if _, ok := recorders.Load(rec.DSN()); ok {
+		t.Fatal("recorder not released after close")
+	}
+}
@@ -0,0 +1,279 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnexpected 严格模式下未匹配任何预设的语句
+var ErrUnexpected = errors.New("dbtest: unexpected statement")
+
+// AnyArg 匹配任意参数
+var AnyArg = anyArg{}
+
+type anyArg struct{}
+
+// 事务语句,与普通语句一样记录和匹配
+const (
+	StmtBegin    = "BEGIN"
+	StmtCommit   = "COMMIT"
+	StmtRollback = "ROLLBACK"
+)
+
+// Statement 执行过的语句
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+// Expectation 预设的语句及返回结果
+type Expectation struct {
+	query        string
+	args         []interface{}
+	columns      []string
+	rows         [][]interface{}
+	lastInsertID int64
+	rowsAffected int64
+	err          error
+	delay        time.Duration
+	times        int
+	matched      int
+}
+
+// WithArgs 限定参数,未设置时匹配任意参数
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = args
+	return e
+}
+
+// WillReturnRows 查询返回的结果集
+func (e *Expectation) WillReturnRows(columns []string, rows ...[]interface{}) *Expectation {
+	e.columns = columns
+	e.rows = rows
+	return e
+}
+
+// WillReturnResult 执行返回的结果
+func (e *Expectation) WillReturnResult(lastInsertID int64, rowsAffected int64) *Expectation {
+	e.lastInsertID = lastInsertID
+	e.rowsAffected = rowsAffected
+	return e
+}
+
+// WillReturnError 返回错误
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// WillDelay 延迟d后返回,期间ctx取消或到期时返回ctx的错误,用于模拟慢查询
+func (e *Expectation) WillDelay(d time.Duration) *Expectation {
+	e.delay = d
+	return e
+}
+
+// Times 最多匹配n次,默认不限
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// wait 按预设延迟,未匹配时不延迟
+func (e *Expectation) wait(ctx context.Context) error {
+	if e == nil || e.delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(e.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Expectation) match(query string, args []interface{}) bool {
+	if e.times > 0 && e.matched >= e.times {
+		return false
+	}
+	if !strings.Contains(query, e.query) {
+		return false
+	}
+	if e.args == nil {
+		return true
+	}
+	if len(e.args) != len(args) {
+		return false
+	}
+	for i, want := range e.args {
+		if _, ok := want.(anyArg); ok {
+			continue
+		}
+		if v, err := driver.DefaultParameterConverter.ConvertValue(want); err == nil {
+			want = v
+		}
+		if !reflect.DeepEqual(want, args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Recorder 记录经由驱动执行的语句,按预设返回结果集或执行结果.
+// 语句按顺序匹配第一个满足条件的预设,未匹配时交给内存引擎执行,
+// 未设置引擎时查询返回空结果集,执行返回零值结果
+type Recorder struct {
+	mtx        sync.Mutex
+	dsn        string
+	refs       int32
+	strict     bool
+	engine     *Engine
+	expects    []*Expectation
+	statements []Statement
+	prepared   []string
+	openStmts  int
+	pingErr    error
+}
+
+// Expect 预设语句,query为语句片段,空白归一化后包含即匹配
+func (r *Recorder) Expect(query string) *Expectation {
+	e := &Expectation{query: normalize(query)}
+	r.mtx.Lock()
+	r.expects = append(r.expects, e)
+	r.mtx.Unlock()
+	return e
+}
+
+// SetStrict 严格模式,未匹配的语句返回ErrUnexpected
+func (r *Recorder) SetStrict(strict bool) {
+	r.mtx.Lock()
+	r.strict = strict
+	r.mtx.Unlock()
+}
+
+// SetPingError 之后的Ping返回err,为nil时恢复正常
+func (r *Recorder) SetPingError(err error) {
+	r.mtx.Lock()
+	r.pingErr = err
+	r.mtx.Unlock()
+}
+
+func (r *Recorder) ping() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.pingErr
+}
+
+// SetEngine 设置内存引擎执行未匹配预设的语句,此时严格模式不生效.预设仍优先,可用于注入错误
+func (r *Recorder) SetEngine(e *Engine) {
+	r.mtx.Lock()
+	r.engine = e
+	r.mtx.Unlock()
+}
+
+// Engine 设置的内存引擎
+func (r *Recorder) Engine() *Engine {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.engine
+}
+
+// DSN 打开该记录器的数据源名,配合DriverName使用
+func (r *Recorder) DSN() string {
+	return r.dsn
+}
+
+// Statements 已执行的语句
+func (r *Recorder) Statements() []Statement {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	arr := make([]Statement, len(r.statements))
+	copy(arr, r.statements)
+	return arr
+}
+
+// Prepared 已预编译的语句,同一语句在不同连接上预编译时记录多次
+func (r *Recorder) Prepared() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	arr := make([]string, len(r.prepared))
+	copy(arr, r.prepared)
+	return arr
+}
+
+// OpenStmts 尚未关闭的预编译语句数
+func (r *Recorder) OpenStmts() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.openStmts
+}
+
+// Unmatched 从未匹配过的预设,全部匹配时返回nil
+func (r *Recorder) Unmatched() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var missing []string
+	for _, e := range r.expects {
+		if e.matched == 0 {
+			missing = append(missing, e.query)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("dbtest: expectations not matched: %q", missing)
+}
+
+// Reset 清空预设及记录,不影响未关闭的预编译语句数
+func (r *Recorder) Reset() {
+	r.mtx.Lock()
+	r.expects = nil
+	r.statements = nil
+	r.prepared = nil
+	r.mtx.Unlock()
+}
+
+func (r *Recorder) prepare(query string) {
+	r.mtx.Lock()
+	r.prepared = append(r.prepared, normalize(query))
+	r.openStmts++
+	r.mtx.Unlock()
+}
+
+func (r *Recorder) closeStmt() {
+	r.mtx.Lock()
+	r.openStmts--
+	r.mtx.Unlock()
+}
+
+// handle 记录语句并查找预设,返回nil表示未匹配,未匹配且设置了引擎时返回引擎
+func (r *Recorder) handle(query string, args []interface{}) (*Expectation, *Engine, error) {
+	query = normalize(query)
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.statements = append(r.statements, Statement{Query: query, Args: args})
+	for _, e := range r.expects {
+		if e.match(query, args) {
+			e.matched++
+			return e, nil, e.err
+		}
+	}
+	if r.engine != nil {
+		return nil, r.engine, nil
+	}
+	if r.strict {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnexpected, query)
+	}
+	return nil, nil, nil
+}
+
+func normalize(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
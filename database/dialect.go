@@ -0,0 +1,154 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Dialect SQL方言,处理占位符、标识符引用、upsert语法及驱动错误分类
+type Dialect interface {
+	// Placeholder 第idx个参数的占位符,idx从1开始
+	Placeholder(idx int) string
+	// QuoteIdent 引用表名或列名
+	QuoteIdent(name string) string
+	// Upsert 多行插入,keys冲突时更新其余列,没有其余列时忽略冲突.表名和列名未引用
+	Upsert(table string, columns []string, keys []string, rows int) string
+	// IsRetryable 事务可整体重试的错误,如死锁
+	IsRetryable(err error) bool
+	// IsConnError 驱动特有的连接失效错误
+	IsConnError(err error) bool
+}
+
+// PostgresDialect PostgreSQL方言
+type PostgresDialect struct{}
+
+// Placeholder $1, $2 ...
+func (PostgresDialect) Placeholder(idx int) string {
+	return "$" + strconv.Itoa(idx)
+}
+
+// QuoteIdent 双引号引用
+func (PostgresDialect) QuoteIdent(name string) string {
+	return quoteDouble(name)
+}
+
+// Upsert INSERT ... ON CONFLICT
+func (d PostgresDialect) Upsert(table string, columns []string, keys []string, rows int) string {
+	return insertSQL(d, "INSERT", table, columns, rows) + onConflict(d, columns, keys)
+}
+
+// IsRetryable 序列化失败及死锁
+func (PostgresDialect) IsRetryable(err error) bool {
+	var stateErr interface{ SQLState() string }
+	if !errors.As(err, &stateErr) {
+		return false
+	}
+	code := stateErr.SQLState()
+	return code == "40001" || code == "40P01"
+}
+
+// IsConnError 连接失效由driver.ErrBadConn统一处理
+func (PostgresDialect) IsConnError(err error) bool {
+	return false
+}
+
+// SqliteDialect SQLite方言,多用于本地测试
+type SqliteDialect struct{}
+
+// Placeholder ?
+func (SqliteDialect) Placeholder(idx int) string {
+	return "?"
+}
+
+// QuoteIdent 双引号引用
+func (SqliteDialect) QuoteIdent(name string) string {
+	return quoteDouble(name)
+}
+
+// Upsert INSERT ... ON CONFLICT
+func (d SqliteDialect) Upsert(table string, columns []string, keys []string, rows int) string {
+	return insertSQL(d, "INSERT", table, columns, rows) + onConflict(d, columns, keys)
+}
+
+// IsRetryable 数据库被锁
+func (SqliteDialect) IsRetryable(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "database is locked")
+}
+
+// IsConnError 无网络连接
+func (SqliteDialect) IsConnError(err error) bool {
+	return false
+}
+
+func quoteDouble(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// insertSQL 拼接 verb INTO table (columns) VALUES (...),(...),占位符按方言连续编号
+func insertSQL(d Dialect, verb string, table string, columns []string, rows int) string {
+	var sb strings.Builder
+	sb.WriteString(verb)
+	sb.WriteString(" INTO ")
+	sb.WriteString(d.QuoteIdent(table))
+	sb.WriteString(" (")
+	for i, col := range columns {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(d.QuoteIdent(col))
+	}
+	sb.WriteString(") VALUES ")
+	n := 0
+	for r := 0; r < rows; r++ {
+		if r > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('(')
+		for i := range columns {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			n++
+			sb.WriteString(d.Placeholder(n))
+		}
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
+
+// onConflict PostgreSQL及SQLite的冲突处理子句
+func onConflict(d Dialect, columns []string, keys []string) string {
+	updates := updateColumns(columns, keys)
+	if len(keys) == 0 || len(updates) == 0 {
+		return " ON CONFLICT DO NOTHING"
+	}
+	quotedKeys := make([]string, len(keys))
+	for i, key := range keys {
+		quotedKeys[i] = d.QuoteIdent(key)
+	}
+	sets := make([]string, len(updates))
+	for i, col := range updates {
+		name := d.QuoteIdent(col)
+		sets[i] = name + "=EXCLUDED." + name
+	}
+	return " ON CONFLICT (" + strings.Join(quotedKeys, ",") + ") DO UPDATE SET " + strings.Join(sets, ",")
+}
+
+// updateColumns 冲突时需更新的列,即columns中不属于keys的列
+func updateColumns(columns []string, keys []string) []string {
+	updates := make([]string, 0, len(columns))
+	for _, col := range columns {
+		isKey := false
+		for _, key := range keys {
+			if col == key {
+				isKey = true
+				break
+			}
+		}
+		if !isKey {
+			updates = append(updates, col)
+		}
+	}
+	return updates
+}
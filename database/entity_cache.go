@@ -0,0 +1,175 @@
+package database
+
+import (
+	"container/list"
+	"database/sql"
+	"time"
+)
+
+// EntityLoader 按实体键生成查询,结果集的第一行为实体
+type EntityLoader func(key interface{}) (dbIdx uint32, query string, args []interface{})
+
+type cacheEntry struct {
+	key      interface{}
+	entity   interface{}
+	expireAt time.Time
+}
+
+type cacheWaiter struct {
+	ctx interface{}
+	cb  func([]interface{})
+}
+
+// cacheLoad 进行中的查询,期间的读取合并到同一次查询
+type cacheLoad struct {
+	key     interface{}
+	waiters []cacheWaiter
+	stale   bool
+}
+
+// EntityCache 按实体键的读缓存,命中时直接回调,未命中时合并并发读取为一次查询.
+// 超过ttl或容量时淘汰,写操作后调用Invalidate或Set保持一致.所有方法均在主线程调用
+type EntityCache struct {
+	db       IDatabase
+	loader   EntityLoader
+	scanner  RowScanner
+	capacity int
+	ttl      time.Duration
+	items    map[interface{}]*list.Element
+	lru      *list.List
+	loading  map[interface{}]*cacheLoad
+}
+
+// NewEntityCache 新建,capacity为最大实体数,ttl为实体有效期,均为0时不限
+func NewEntityCache(db IDatabase, capacity int, ttl time.Duration, scanner RowScanner, loader EntityLoader) *EntityCache {
+	return &EntityCache{
+		db:       db,
+		loader:   loader,
+		scanner:  scanner,
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[interface{}]*list.Element),
+		lru:      list.New(),
+		loading:  make(map[interface{}]*cacheLoad),
+	}
+}
+
+// Get 读取实体,回调参数为ctx, entity, err. 命中时在调用栈内直接回调,不存在时err为sql.ErrNoRows
+func (c *EntityCache) Get(ctx interface{}, cb func([]interface{}), key interface{}) {
+	if entity, ok := c.Peek(key); ok {
+		if cb != nil {
+			cb([]interface{}{ctx, entity, nil})
+		}
+		return
+	}
+
+	if load, ok := c.loading[key]; ok {
+		load.waiters = append(load.waiters, cacheWaiter{ctx: ctx, cb: cb})
+		return
+	}
+	load := &cacheLoad{key: key, waiters: []cacheWaiter{{ctx: ctx, cb: cb}}}
+	c.loading[key] = load
+	dbIdx, query, args := c.loader(key)
+	c.db.AsyncQueryAll(load, c.onLoaded, dbIdx, c.scanner, query, args...)
+}
+
+// Peek 仅读取缓存,不触发查询
+func (c *EntityCache) Peek(key interface{}) (interface{}, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.expireAt.IsZero() && !time.Now().Before(entry.expireAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.entity, true
+}
+
+// Set 写入实体,进行中的查询结果不再缓存
+func (c *EntityCache) Set(key interface{}, entity interface{}) {
+	c.detach(key)
+	c.store(key, entity)
+}
+
+// Invalidate 移除实体,进行中的查询结果不再缓存,之后的读取重新查询
+func (c *EntityCache) Invalidate(key interface{}) {
+	c.detach(key)
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// Clear 移除所有实体
+func (c *EntityCache) Clear() {
+	for _, load := range c.loading {
+		load.stale = true
+	}
+	c.loading = make(map[interface{}]*cacheLoad)
+	c.items = make(map[interface{}]*list.Element)
+	c.lru.Init()
+}
+
+// Len 缓存的实体数,包括已过期未淘汰的
+func (c *EntityCache) Len() int {
+	return c.lru.Len()
+}
+
+// detach 进行中的查询仍回调已等待的读取,但结果可能早于写操作,不再缓存
+func (c *EntityCache) detach(key interface{}) {
+	if load, ok := c.loading[key]; ok {
+		load.stale = true
+		delete(c.loading, key)
+	}
+}
+
+func (c *EntityCache) onLoaded(args []interface{}) {
+	load := args[0].(*cacheLoad)
+	result, _ := args[1].([]interface{})
+	err, _ := args[2].(error)
+	if c.loading[load.key] == load {
+		delete(c.loading, load.key)
+	}
+
+	var entity interface{}
+	if err == nil {
+		if len(result) == 0 {
+			err = sql.ErrNoRows
+		} else {
+			entity = result[0]
+			if !load.stale {
+				c.store(load.key, entity)
+			}
+		}
+	}
+	for _, w := range load.waiters {
+		if w.cb != nil {
+			w.cb([]interface{}{w.ctx, entity, err})
+		}
+	}
+}
+
+func (c *EntityCache) store(key interface{}, entity interface{}) {
+	var expireAt time.Time
+	if c.ttl > 0 {
+		expireAt = time.Now().Add(c.ttl)
+	}
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.entity = entity
+		entry.expireAt = expireAt
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&cacheEntry{key: key, entity: entity, expireAt: expireAt})
+	for c.capacity > 0 && c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *EntityCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.items, elem.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,123 @@
+package database_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+func scanName(rows *sql.Rows) (interface{}, error) {
+	var name string
+	err := rows.Scan(&name)
+	return name, err
+}
+
+func loadName(key interface{}) (uint32, string, []interface{}) {
+	return 0, "SELECT name FROM user WHERE id=?", []interface{}{key}
+}
+
+func TestEntityCacheLoad(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 1, nil)
+	defer db.Close()
+	rec.Expect("SELECT name FROM user WHERE id=?").WithArgs(1).WillReturnRows([]string{"name"}, []interface{}{"alice"})
+	rec.Expect("SELECT name FROM user WHERE id=?").WithArgs(2).WillReturnRows([]string{"name"}, []interface{}{"bob"})
+	rec.Expect("SELECT name FROM user WHERE id=?").WithArgs(3).WillReturnRows([]string{"name"}, []interface{}{"carol"})
+
+	c := database.NewEntityCache(db, 0, 0, database.RowScanner(scanName), loadName)
+	var got []interface{}
+	cb := func(args []interface{}) {
+		got = append(got, args[0], args[1], args[2])
+	}
+
+	// 并发读取合并为一次查询
+	c.Get("a", cb, 1)
+	c.Get("b", cb, 1)
+	c.Get("c", cb, 1)
+	if !p.RunOne(time.Second) || p.RunPending() != 0 {
+		t.Fatal("expected a single load callback")
+	}
+	if len(rec.Statements()) != 1 {
+		t.Fatalf("statements %v", rec.Statements())
+	}
+	want := []interface{}{"a", "alice", nil, "b", "alice", nil, "c", "alice", nil}
+	if len(got) != len(want) {
+		t.Fatalf("callbacks %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("callbacks %v", got)
+		}
+	}
+	got = nil
+	c.Get("d", cb, 1)
+	if len(got) != 3 || got[1] != "alice" || len(rec.Statements()) != 1 {
+		t.Fatalf("cache hit %v", got)
+	}
+
+	// 查询期间Invalidate,结果回调给等待者但不缓存
+	got = nil
+	c.Get("e", cb, 2)
+	c.Invalidate(2)
+	if !p.RunOne(time.Second) {
+		t.Fatal("load not delivered")
+	}
+	if len(got) != 3 || got[1] != "bob" {
+		t.Fatalf("callbacks %v", got)
+	}
+	if _, ok := c.Peek(2); ok {
+		t.Fatal("stale load cached after Invalidate")
+	}
+
+	// 查询期间Set,保留Set的值
+	got = nil
+	c.Get("f", cb, 3)
+	c.Set(3, "dave")
+	if !p.RunOne(time.Second) {
+		t.Fatal("load not delivered")
+	}
+	if len(got) != 3 || got[1] != "carol" {
+		t.Fatalf("callbacks %v", got)
+	}
+	if v, ok := c.Peek(3); !ok || v != "dave" {
+		t.Fatalf("Set overwritten by stale load: %v", v)
+	}
+
+	// 不存在时返回sql.ErrNoRows
+	got = nil
+	c.Get("g", cb, 4)
+	if !p.RunOne(time.Second) || len(got) != 3 || got[2] != sql.ErrNoRows {
+		t.Fatalf("missing entity %v", got)
+	}
+	if _, ok := c.Peek(4); ok {
+		t.Fatal("missing entity cached")
+	}
+}
+
+func TestEntityCacheEvict(t *testing.T) {
+	c := database.NewEntityCache(nil, 2, time.Millisecond*50, database.RowScanner(scanName), loadName)
+
+	// 超过容量时淘汰最久未访问的
+	c.Set(1, "alice")
+	c.Set(2, "bob")
+	c.Peek(1)
+	c.Set(3, "carol")
+	if _, ok := c.Peek(2); ok {
+		t.Fatal("least recently used entity not evicted")
+	}
+	if _, ok := c.Peek(1); !ok || c.Len() != 2 {
+		t.Fatalf("recently used entity evicted, len %d", c.Len())
+	}
+
+	// 过期后Peek不再命中并淘汰
+	time.Sleep(time.Millisecond * 60)
+	if _, ok := c.Peek(1); ok {
+		t.Fatal("expired entity returned")
+	}
+	if c.Len() != 1 {
+		t.Fatalf("expired entity not removed, len %d", c.Len())
+	}
+}
@@ -0,0 +1,4 @@
+package database
+
+// SplitStatements 供外部测试使用
+var SplitStatements = splitStatements
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	logger "github.com/panlibin/vglog"
+)
+
+const (
+	journalRecordAppend byte = 'A'
+	journalRecordDone   byte = 'D'
+)
+
+const journalCompactSize = 1 << 20
+
+type journalEntry struct {
+	id uint64
+	op *opRecord
+}
+
+// journal 异步写操作的预写日志,入队前记录,执行完成后标记完成,下次Open时回放未完成的记录.
+// 直接写入文件不经缓冲但不fsync,可应对进程崩溃,操作系统崩溃或断电时可能丢失最近的记录.
+// 执行成功但完成标记未写入时会再次回放,即至少执行一次,写操作应幂等
+// 读取时末尾不完整或损坏的记录视为崩溃时未写完,丢弃并停止读取
+type journal struct {
+	mtx     sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	nextID  uint64
+	pending map[uint64]struct{}
+}
+
+// openJournal 打开预写日志,返回未完成的记录,并将文件压缩为仅包含这些记录
+func openJournal(path string) (*journal, []*journalEntry, error) {
+	entries, maxID, err := readJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j := &journal{
+		path:    path,
+		nextID:  maxID + 1,
+		pending: make(map[uint64]struct{}, len(entries)),
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		buf, err := encodeOp(entry.op)
+		if err == nil {
+			err = j.write(file, journalRecordAppend, entry.id, buf)
+		}
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		j.pending[entry.id] = struct{}{}
+	}
+	if err = file.Sync(); err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if j.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, nil, err
+	}
+	return j, entries, nil
+}
+
+func readJournal(path string) ([]*journalEntry, uint64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var maxID uint64
+	entries := make([]*journalEntry, 0, 16)
+	index := make(map[uint64]int)
+	r := bufio.NewReader(file)
+	for {
+		var head [13]byte
+		if _, err = io.ReadFull(r, head[:9]); err != nil {
+			break
+		}
+		id := binary.LittleEndian.Uint64(head[1:9])
+		if id > maxID {
+			maxID = id
+		}
+		if head[0] == journalRecordDone {
+			if i, ok := index[id]; ok {
+				entries[i] = nil
+			}
+			continue
+		}
+		if head[0] != journalRecordAppend {
+			logger.Errorf("journal %s corrupted, stop reading", path)
+			err = errOpCorrupted
+			break
+		}
+		if _, err = io.ReadFull(r, head[9:13]); err != nil {
+			break
+		}
+		buf := make([]byte, binary.LittleEndian.Uint32(head[9:13]))
+		if _, err = io.ReadFull(r, buf); err != nil {
+			break
+		}
+		var op *opRecord
+		if op, err = decodeOp(buf); err != nil {
+			logger.Errorf("journal %s corrupted, stop reading", path)
+			break
+		}
+		index[id] = len(entries)
+		entries = append(entries, &journalEntry{id: id, op: op})
+	}
+	if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, errOpCorrupted) {
+		return nil, 0, err
+	}
+
+	arr := make([]*journalEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry != nil {
+			arr = append(arr, entry)
+		}
+	}
+	return arr, maxID, nil
+}
+
+// append 记录写操作,返回记录id
+func (j *journal) append(op *opRecord) (uint64, error) {
+	buf, err := encodeOp(op)
+	if err != nil {
+		return 0, err
+	}
+
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	id := j.nextID
+	if err = j.write(j.file, journalRecordAppend, id, buf); err != nil {
+		return 0, err
+	}
+	j.nextID++
+	j.pending[id] = struct{}{}
+	return id, nil
+}
+
+// done 标记完成,没有未完成记录且文件较大时截断
+func (j *journal) done(id uint64) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	if _, ok := j.pending[id]; !ok {
+		return
+	}
+	delete(j.pending, id)
+	if len(j.pending) == 0 && j.size >= journalCompactSize {
+		if err := j.file.Truncate(0); err == nil {
+			j.size = 0
+			return
+		}
+	}
+	if err := j.write(j.file, journalRecordDone, id, nil); err != nil {
+		logger.Errorf("write journal error: %v", err)
+	}
+}
+
+func (j *journal) write(w io.Writer, recordType byte, id uint64, payload []byte) error {
+	buf := make([]byte, 9, 13+len(payload))
+	buf[0] = recordType
+	binary.LittleEndian.PutUint64(buf[1:], id)
+	if recordType == journalRecordAppend {
+		buf = appendBytes(buf, payload)
+	}
+	n, err := w.Write(buf)
+	j.size += int64(n)
+	return err
+}
+
+func (j *journal) close() {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+}
+
+// isTransientError 执行结果未知的错误,记录保留以便回放
+func (m *dbInstance) isTransientError(err error) bool {
+	return m.cfg.isConnError(err) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrQueryTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db.journal")
+
+	j, pending, err := openJournal(path)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("open empty journal %v %v", pending, err)
+	}
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ops := []*opRecord{
+		{dbIdx: 1, query: "INSERT INTO t (a,b,c) VALUES (?,?,?)", args: []interface{}{int64(1), "x", created}},
+		{dbIdx: 2, query: "UPDATE t SET a=? WHERE b=?", args: []interface{}{[]byte{1, 2}, nil}},
+		{dbIdx: 3, query: "DELETE FROM t WHERE a=?", args: []interface{}{1.5}},
+	}
+	ids := make([]uint64, len(ops))
+	for i, op := range ops {
+		if ids[i], err = j.append(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.done(ids[1])
+	j.close()
+
+	// 重新打开只返回未完成的记录,id继续递增
+	j, pending, err = openJournal(path)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("reopen %d entries %v", len(pending), err)
+	}
+	if pending[0].id != ids[0] || !reflect.DeepEqual(pending[0].op, ops[0]) || !reflect.DeepEqual(pending[1].op, ops[2]) {
+		t.Fatalf("pending %+v %+v", pending[0].op, pending[1].op)
+	}
+	id, _ := j.append(ops[0])
+	if id <= ids[2] {
+		t.Fatalf("id %d not increasing", id)
+	}
+	j.close()
+
+	// 末尾不完整的记录丢弃
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-3)
+	j, pending, err = openJournal(path)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("torn tail %d entries %v", len(pending), err)
+	}
+	j.close()
+
+	// 损坏的记录停止读取
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{'X', 1, 2, 3, 4, 5, 6, 7, 8})
+	f.Close()
+	j, pending, err = openJournal(path)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("corrupted %d entries %v", len(pending), err)
+	}
+	j.close()
+}
+
+type unsupportedArg struct{}
+
+func TestEncodeOpArgs(t *testing.T) {
+	op := &opRecord{query: "UPDATE t SET a=?,b=?,c=?", args: []interface{}{
+		sql.NullString{String: "x", Valid: true}, sql.NullInt64{}, (*sql.NullInt64)(nil),
+	}}
+	buf, err := encodeOp(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeOp(buf)
+	if err != nil || !reflect.DeepEqual(decoded.args, []interface{}{"x", nil, nil}) {
+		t.Fatalf("decoded %v %v", decoded, err)
+	}
+
+	if _, err = encodeOp(&opRecord{args: []interface{}{unsupportedArg{}}}); err == nil {
+		t.Fatal("expected error on unsupported argument")
+	}
+	if _, err = decodeOp(buf[:len(buf)-1]); err != errOpCorrupted {
+		t.Fatalf("truncated record error %v", err)
+	}
+}
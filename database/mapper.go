@@ -0,0 +1,560 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrInvalidEntity 实体必须是结构体指针
+var ErrInvalidEntity = errors.New("database: entity must be a pointer to struct")
+
+// ErrNoPrimaryKey 实体没有主键
+var ErrNoPrimaryKey = errors.New("database: entity has no primary key")
+
+// ErrNoColumnToUpdate 实体只有主键列
+var ErrNoColumnToUpdate = errors.New("database: entity has no column to update")
+
+// ITable 自定义表名
+type ITable interface {
+	TableName() string
+}
+
+type columnMeta struct {
+	name  string
+	index []int
+	pk    bool
+	auto  bool
+}
+
+// tableMeta 由结构体tag解析的表信息
+// tag格式: `db:"列名,pk,auto"`, `db:"-"`忽略字段, 未设置列名时使用字段名的蛇形命名
+type tableMeta struct {
+	typ       reflect.Type
+	table     string
+	columns   []*columnMeta
+	pkColumns []*columnMeta
+	autoCol   *columnMeta
+	colByName map[string]*columnMeta
+	sqls      sync.Map // Dialect -> *tableSQL
+}
+
+// tableSQL 按方言预生成的语句
+type tableSQL struct {
+	selectSQL     string
+	getSQL        string
+	insertSQL     string
+	upsertSQL     string
+	upsertAutoSQL string
+	updateSQL     string
+	deleteSQL     string
+}
+
+var tableMetaCache sync.Map
+
+func getTableMeta(typ reflect.Type) (*tableMeta, error) {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, ErrInvalidEntity
+	}
+	if v, ok := tableMetaCache.Load(typ); ok {
+		return v.(*tableMeta), nil
+	}
+
+	meta := &tableMeta{
+		typ:       typ,
+		colByName: make(map[string]*columnMeta),
+	}
+	if t, ok := reflect.New(typ).Interface().(ITable); ok {
+		meta.table = t.TableName()
+	} else {
+		meta.table = snakeCase(typ.Name())
+	}
+	meta.parseFields(typ, nil)
+
+	v, _ := tableMetaCache.LoadOrStore(typ, meta)
+	return v.(*tableMeta), nil
+}
+
+func (t *tableMeta) parseFields(typ reflect.Type, parent []int) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		index := append(append(make([]int, 0, len(parent)+1), parent...), i)
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			t.parseFields(field.Type, index)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		col := &columnMeta{index: index}
+		parts := strings.Split(tag, ",")
+		col.name = strings.TrimSpace(parts[0])
+		if col.name == "" {
+			col.name = snakeCase(field.Name)
+		}
+		for _, opt := range parts[1:] {
+			switch strings.TrimSpace(opt) {
+			case "pk":
+				col.pk = true
+			case "auto":
+				col.auto = true
+			}
+		}
+
+		t.columns = append(t.columns, col)
+		t.colByName[col.name] = col
+		if col.pk {
+			t.pkColumns = append(t.pkColumns, col)
+		}
+		if col.auto {
+			t.autoCol = col
+		}
+	}
+}
+
+// sql 方言对应的语句,首次使用时生成
+func (t *tableMeta) sql(d Dialect) *tableSQL {
+	if v, ok := t.sqls.Load(d); ok {
+		return v.(*tableSQL)
+	}
+	v, _ := t.sqls.LoadOrStore(d, t.buildSQL(d))
+	return v.(*tableSQL)
+}
+
+func (t *tableMeta) buildSQL(d Dialect) *tableSQL {
+	names := make([]string, 0, len(t.columns))
+	allNames := make([]string, 0, len(t.columns))
+	insertNames := make([]string, 0, len(t.columns))
+	for _, col := range t.columns {
+		names = append(names, d.QuoteIdent(col.name))
+		allNames = append(allNames, col.name)
+		if !col.auto {
+			insertNames = append(insertNames, col.name)
+		}
+	}
+	pkNames := make([]string, 0, len(t.pkColumns))
+	for _, col := range t.pkColumns {
+		pkNames = append(pkNames, col.name)
+	}
+
+	table := d.QuoteIdent(t.table)
+	s := &tableSQL{
+		selectSQL: "SELECT " + strings.Join(names, ",") + " FROM " + table,
+		insertSQL: insertSQL(d, "INSERT", t.table, insertNames, 1),
+		upsertSQL: d.Upsert(t.table, insertNames, pkNames, 1),
+	}
+	if t.autoCol != nil {
+		s.upsertAutoSQL = d.Upsert(t.table, allNames, pkNames, 1)
+	}
+	if len(pkNames) > 0 {
+		s.getSQL = s.selectSQL + " WHERE " + wherePk(d, pkNames, 1)
+		s.deleteSQL = "DELETE FROM " + table + " WHERE " + wherePk(d, pkNames, 1)
+		updates := updateColumns(allNames, pkNames)
+		if len(updates) > 0 {
+			sets := make([]string, len(updates))
+			for i, name := range updates {
+				sets[i] = d.QuoteIdent(name) + "=" + d.Placeholder(i+1)
+			}
+			s.updateSQL = "UPDATE " + table + " SET " + strings.Join(sets, ",") + " WHERE " + wherePk(d, pkNames, len(updates)+1)
+		}
+	}
+	return s
+}
+
+// wherePk 主键条件,占位符从start开始编号
+func wherePk(d Dialect, pkNames []string, start int) string {
+	conds := make([]string, len(pkNames))
+	for i, name := range pkNames {
+		conds[i] = d.QuoteIdent(name) + "=" + d.Placeholder(start+i)
+	}
+	return strings.Join(conds, " AND ")
+}
+
+func (t *tableMeta) insertArgs(v reflect.Value) []interface{} {
+	args := make([]interface{}, 0, len(t.columns))
+	for _, col := range t.columns {
+		if !col.auto {
+			args = append(args, v.FieldByIndex(col.index).Interface())
+		}
+	}
+	return args
+}
+
+// upsert 自增主键非零时带上该列,主键冲突时更新;为零时由数据库生成
+func (t *tableMeta) upsert(s *tableSQL, v reflect.Value) (string, []interface{}) {
+	if t.autoCol == nil || v.FieldByIndex(t.autoCol.index).IsZero() {
+		return s.upsertSQL, t.insertArgs(v)
+	}
+	args := make([]interface{}, 0, len(t.columns))
+	for _, col := range t.columns {
+		args = append(args, v.FieldByIndex(col.index).Interface())
+	}
+	return s.upsertAutoSQL, args
+}
+
+// updateArgs 非主键列在前,主键列在后
+func (t *tableMeta) updateArgs(v reflect.Value) []interface{} {
+	args := make([]interface{}, 0, len(t.columns))
+	for _, col := range t.columns {
+		if !col.pk {
+			args = append(args, v.FieldByIndex(col.index).Interface())
+		}
+	}
+	return append(args, t.pkArgs(v)...)
+}
+
+func (t *tableMeta) pkArgs(v reflect.Value) []interface{} {
+	args := make([]interface{}, 0, len(t.pkColumns))
+	for _, col := range t.pkColumns {
+		args = append(args, v.FieldByIndex(col.index).Interface())
+	}
+	return args
+}
+
+// setAutoID 回填自增主键
+func (t *tableMeta) setAutoID(v reflect.Value, res sql.Result) {
+	if t.autoCol == nil || res == nil {
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil || id == 0 {
+		return
+	}
+	field := v.FieldByIndex(t.autoCol.index)
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.SetInt(id)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		field.SetUint(uint64(id))
+	}
+}
+
+// scanner 按列名扫描到新建的结构体,返回结构体指针
+func (t *tableMeta) scanner() RowScanner {
+	return func(rows *sql.Rows) (interface{}, error) {
+		columns, err := rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		obj := reflect.New(t.typ)
+		v := obj.Elem()
+		pointers := make([]interface{}, len(columns))
+		for i, name := range columns {
+			if col, ok := t.colByName[name]; ok {
+				pointers[i] = v.FieldByIndex(col.index).Addr().Interface()
+			} else {
+				pointers[i] = new(interface{})
+			}
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		return obj.Interface(), nil
+	}
+}
+
+// StructScanner 按结构体tag扫描一行,返回与prototype同类型的结构体指针
+func StructScanner(prototype interface{}) RowScanner {
+	meta, err := getTableMeta(reflect.TypeOf(prototype))
+	if err != nil {
+		return func(*sql.Rows) (interface{}, error) {
+			return nil, err
+		}
+	}
+	return meta.scanner()
+}
+
+// Mapper 结构体与表的映射
+type Mapper struct {
+	db IDatabase
+}
+
+// NewMapper 新建
+func NewMapper(db IDatabase) *Mapper {
+	return &Mapper{
+		db: db,
+	}
+}
+
+// Get 按主键查询,结果写入obj,不存在时返回sql.ErrNoRows
+func (mp *Mapper) Get(dbIdx uint32, obj interface{}) error {
+	meta, v, err := entityMeta(obj)
+	if err != nil {
+		return err
+	}
+	query := mp.sql(meta).getSQL
+	if query == "" {
+		return ErrNoPrimaryKey
+	}
+	result, err := mp.db.QueryAll(dbIdx, meta.scanner(), query, meta.pkArgs(v)...)
+	if err != nil {
+		return err
+	}
+	if len(result) == 0 {
+		return sql.ErrNoRows
+	}
+	v.Set(reflect.ValueOf(result[0]).Elem())
+	return nil
+}
+
+// Select 条件查询,dest为结构体切片指针(*[]T或*[]*T),where为空时查询全表
+func (mp *Mapper) Select(dbIdx uint32, dest interface{}, where string, args ...interface{}) error {
+	sliceVal := reflect.ValueOf(dest)
+	if sliceVal.Kind() != reflect.Ptr || sliceVal.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("database: dest must be a pointer to slice, got %T", dest)
+	}
+	sliceVal = sliceVal.Elem()
+	elemType := sliceVal.Type().Elem()
+	meta, err := getTableMeta(elemType)
+	if err != nil {
+		return err
+	}
+
+	result, err := mp.db.QueryAll(dbIdx, meta.scanner(), mp.sql(meta).whereSQL(where), args...)
+	if err != nil {
+		return err
+	}
+	for _, item := range result {
+		if elemType.Kind() == reflect.Ptr {
+			sliceVal.Set(reflect.Append(sliceVal, reflect.ValueOf(item)))
+		} else {
+			sliceVal.Set(reflect.Append(sliceVal, reflect.ValueOf(item).Elem()))
+		}
+	}
+	return nil
+}
+
+// Insert 插入,存在自增列时回填
+func (mp *Mapper) Insert(dbIdx uint32, obj interface{}) (sql.Result, error) {
+	meta, v, err := entityMeta(obj)
+	if err != nil {
+		return nil, err
+	}
+	res, err := mp.db.Exec(dbIdx, mp.sql(meta).insertSQL, meta.insertArgs(v)...)
+	if err == nil {
+		meta.setAutoID(v, res)
+	}
+	return res, err
+}
+
+// Upsert 插入,主键冲突时更新.自增主键为零时插入新行并回填
+func (mp *Mapper) Upsert(dbIdx uint32, obj interface{}) (sql.Result, error) {
+	meta, v, err := entityMeta(obj)
+	if err != nil {
+		return nil, err
+	}
+	query, args := meta.upsert(mp.sql(meta), v)
+	res, err := mp.db.Exec(dbIdx, query, args...)
+	if err == nil {
+		meta.setAutoID(v, res)
+	}
+	return res, err
+}
+
+// Update 按主键更新所有非主键列
+func (mp *Mapper) Update(dbIdx uint32, obj interface{}) (sql.Result, error) {
+	meta, v, err := entityMeta(obj)
+	if err != nil {
+		return nil, err
+	}
+	query, err := mp.updateSQL(meta)
+	if err != nil {
+		return nil, err
+	}
+	return mp.db.Exec(dbIdx, query, meta.updateArgs(v)...)
+}
+
+// Delete 按主键删除
+func (mp *Mapper) Delete(dbIdx uint32, obj interface{}) (sql.Result, error) {
+	meta, v, err := entityMeta(obj)
+	if err != nil {
+		return nil, err
+	}
+	query := mp.sql(meta).deleteSQL
+	if query == "" {
+		return nil, ErrNoPrimaryKey
+	}
+	return mp.db.Exec(dbIdx, query, meta.pkArgs(v)...)
+}
+
+// AsyncGet 按主键查询,回调参数为ctx, 新建的结构体指针, err. 不存在时err为sql.ErrNoRows
+func (mp *Mapper) AsyncGet(ctx interface{}, cb func([]interface{}), dbIdx uint32, obj interface{}) {
+	meta, v, err := entityMeta(obj)
+	var query string
+	if err == nil {
+		if query = mp.sql(meta).getSQL; query == "" {
+			err = ErrNoPrimaryKey
+		}
+	}
+	if err != nil {
+		mp.fail(ctx, cb, err)
+		return
+	}
+	mp.db.AsyncQueryAll(ctx, func(args []interface{}) {
+		if cb == nil {
+			return
+		}
+		result, _ := args[1].([]interface{})
+		err, _ := args[2].(error)
+		if err == nil && len(result) == 0 {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			cb([]interface{}{args[0], nil, err})
+			return
+		}
+		cb([]interface{}{args[0], result[0], nil})
+	}, dbIdx, meta.scanner(), query, meta.pkArgs(v)...)
+}
+
+// AsyncSelect 条件查询,prototype指定结构体类型,回调参数为ctx, []interface{}(元素为结构体指针), err
+func (mp *Mapper) AsyncSelect(ctx interface{}, cb func([]interface{}), dbIdx uint32, prototype interface{}, where string, args ...interface{}) {
+	meta, err := getTableMeta(reflect.TypeOf(prototype))
+	if err != nil {
+		mp.fail(ctx, cb, err)
+		return
+	}
+	mp.db.AsyncQueryAll(ctx, cb, dbIdx, meta.scanner(), mp.sql(meta).whereSQL(where), args...)
+}
+
+// AsyncInsert 插入,回调参数为ctx, sql.Result, err. 自增列在回调前于主线程回填
+func (mp *Mapper) AsyncInsert(ctx interface{}, cb func([]interface{}), dbIdx uint32, obj interface{}) {
+	meta, v, err := entityMeta(obj)
+	if err != nil {
+		mp.fail(ctx, cb, err)
+		return
+	}
+	mp.db.AsyncExec(ctx, func(args []interface{}) {
+		if args[2] == nil {
+			res, _ := args[1].(sql.Result)
+			meta.setAutoID(v, res)
+		}
+		if cb != nil {
+			cb(args)
+		}
+	}, dbIdx, mp.sql(meta).insertSQL, meta.insertArgs(v)...)
+}
+
+// AsyncUpsert 插入,主键冲突时更新,回调参数为ctx, sql.Result, err. 自增主键为零时插入新行并在回调前回填
+func (mp *Mapper) AsyncUpsert(ctx interface{}, cb func([]interface{}), dbIdx uint32, obj interface{}) {
+	meta, v, err := entityMeta(obj)
+	if err != nil {
+		mp.fail(ctx, cb, err)
+		return
+	}
+	query, args := meta.upsert(mp.sql(meta), v)
+	mp.db.AsyncExec(ctx, func(args []interface{}) {
+		if args[2] == nil {
+			res, _ := args[1].(sql.Result)
+			meta.setAutoID(v, res)
+		}
+		if cb != nil {
+			cb(args)
+		}
+	}, dbIdx, query, args...)
+}
+
+// AsyncUpdate 按主键更新所有非主键列,回调参数为ctx, sql.Result, err
+func (mp *Mapper) AsyncUpdate(ctx interface{}, cb func([]interface{}), dbIdx uint32, obj interface{}) {
+	meta, v, err := entityMeta(obj)
+	var query string
+	if err == nil {
+		query, err = mp.updateSQL(meta)
+	}
+	if err != nil {
+		mp.fail(ctx, cb, err)
+		return
+	}
+	mp.db.AsyncExec(ctx, cb, dbIdx, query, meta.updateArgs(v)...)
+}
+
+// AsyncDelete 按主键删除,回调参数为ctx, sql.Result, err
+func (mp *Mapper) AsyncDelete(ctx interface{}, cb func([]interface{}), dbIdx uint32, obj interface{}) {
+	meta, v, err := entityMeta(obj)
+	var query string
+	if err == nil {
+		if query = mp.sql(meta).deleteSQL; query == "" {
+			err = ErrNoPrimaryKey
+		}
+	}
+	if err != nil {
+		mp.fail(ctx, cb, err)
+		return
+	}
+	mp.db.AsyncExec(ctx, cb, dbIdx, query, meta.pkArgs(v)...)
+}
+
+func (mp *Mapper) sql(meta *tableMeta) *tableSQL {
+	return meta.sql(mp.db.Dialect())
+}
+
+func (mp *Mapper) updateSQL(meta *tableMeta) (string, error) {
+	if len(meta.pkColumns) == 0 {
+		return "", ErrNoPrimaryKey
+	}
+	query := mp.sql(meta).updateSQL
+	if query == "" {
+		return "", ErrNoColumnToUpdate
+	}
+	return query, nil
+}
+
+// fail 参数错误时仍经由主线程回调,保持回调时序,无法取得主线程时直接回调
+func (mp *Mapper) fail(ctx interface{}, cb func([]interface{}), err error) {
+	if cb == nil {
+		return
+	}
+	if h, ok := mp.db.(procedureHolder); ok {
+		h.procedure().SyncTask(cb, ctx, nil, err)
+		return
+	}
+	cb([]interface{}{ctx, nil, err})
+}
+
+func (s *tableSQL) whereSQL(where string) string {
+	if where == "" {
+		return s.selectSQL
+	}
+	return s.selectSQL + " WHERE " + where
+}
+
+func entityMeta(obj interface{}) (*tableMeta, reflect.Value, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, reflect.Value{}, ErrInvalidEntity
+	}
+	meta, err := getTableMeta(v.Type())
+	if err != nil {
+		return nil, reflect.Value{}, err
+	}
+	return meta, v.Elem(), nil
+}
+
+// snakeCase 驼峰转蛇形, UserID -> user_id
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(unicode.ToLower(r))
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
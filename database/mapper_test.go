@@ -0,0 +1,121 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+type mapperUser struct {
+	ID    int64  `db:"id,pk,auto"`
+	Name  string `db:"name"`
+	Level int    `db:"level"`
+	Temp  string `db:"-"`
+}
+
+func (mapperUser) TableName() string {
+	return "user"
+}
+
+type mapperItem struct {
+	OwnerID int64 `db:",pk"`
+	ItemID  int32 `db:",pk"`
+	Count   int
+}
+
+func TestMapperSQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		obj     interface{}
+		want    tableSQL
+	}{
+		{"mysql auto", MysqlDialect{}, mapperUser{}, tableSQL{
+			selectSQL:     "SELECT `id`,`name`,`level` FROM `user`",
+			getSQL:        "SELECT `id`,`name`,`level` FROM `user` WHERE `id`=?",
+			insertSQL:     "INSERT INTO `user` (`name`,`level`) VALUES (?,?)",
+			upsertSQL:     "INSERT INTO `user` (`name`,`level`) VALUES (?,?) ON DUPLICATE KEY UPDATE `name`=VALUES(`name`),`level`=VALUES(`level`)",
+			upsertAutoSQL: "INSERT INTO `user` (`id`,`name`,`level`) VALUES (?,?,?) ON DUPLICATE KEY UPDATE `name`=VALUES(`name`),`level`=VALUES(`level`)",
+			updateSQL:     "UPDATE `user` SET `name`=?,`level`=? WHERE `id`=?",
+			deleteSQL:     "DELETE FROM `user` WHERE `id`=?",
+		}},
+		{"postgres auto", PostgresDialect{}, mapperUser{}, tableSQL{
+			selectSQL:     `SELECT "id","name","level" FROM "user"`,
+			getSQL:        `SELECT "id","name","level" FROM "user" WHERE "id"=$1`,
+			insertSQL:     `INSERT INTO "user" ("name","level") VALUES ($1,$2)`,
+			upsertSQL:     `INSERT INTO "user" ("name","level") VALUES ($1,$2) ON CONFLICT ("id") DO UPDATE SET "name"=EXCLUDED."name","level"=EXCLUDED."level"`,
+			upsertAutoSQL: `INSERT INTO "user" ("id","name","level") VALUES ($1,$2,$3) ON CONFLICT ("id") DO UPDATE SET "name"=EXCLUDED."name","level"=EXCLUDED."level"`,
+			updateSQL:     `UPDATE "user" SET "name"=$1,"level"=$2 WHERE "id"=$3`,
+			deleteSQL:     `DELETE FROM "user" WHERE "id"=$1`,
+		}},
+		{"mysql composite key", MysqlDialect{}, mapperItem{}, tableSQL{
+			selectSQL: "SELECT `owner_id`,`item_id`,`count` FROM `mapper_item`",
+			getSQL:    "SELECT `owner_id`,`item_id`,`count` FROM `mapper_item` WHERE `owner_id`=? AND `item_id`=?",
+			insertSQL: "INSERT INTO `mapper_item` (`owner_id`,`item_id`,`count`) VALUES (?,?,?)",
+			upsertSQL: "INSERT INTO `mapper_item` (`owner_id`,`item_id`,`count`) VALUES (?,?,?) ON DUPLICATE KEY UPDATE `count`=VALUES(`count`)",
+			updateSQL: "UPDATE `mapper_item` SET `count`=? WHERE `owner_id`=? AND `item_id`=?",
+			deleteSQL: "DELETE FROM `mapper_item` WHERE `owner_id`=? AND `item_id`=?",
+		}},
+		{"sqlite composite key", SqliteDialect{}, mapperItem{}, tableSQL{
+			selectSQL: `SELECT "owner_id","item_id","count" FROM "mapper_item"`,
+			getSQL:    `SELECT "owner_id","item_id","count" FROM "mapper_item" WHERE "owner_id"=? AND "item_id"=?`,
+			insertSQL: `INSERT INTO "mapper_item" ("owner_id","item_id","count") VALUES (?,?,?)`,
+			upsertSQL: `INSERT INTO "mapper_item" ("owner_id","item_id","count") VALUES (?,?,?) ON CONFLICT ("owner_id","item_id") DO UPDATE SET "count"=EXCLUDED."count"`,
+			updateSQL: `UPDATE "mapper_item" SET "count"=? WHERE "owner_id"=? AND "item_id"=?`,
+			deleteSQL: `DELETE FROM "mapper_item" WHERE "owner_id"=? AND "item_id"=?`,
+		}},
+	}
+	for _, tt := range tests {
+		meta, err := getTableMeta(reflect.TypeOf(tt.obj))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := meta.buildSQL(tt.dialect); *got != tt.want {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestMapperUpsertArgs(t *testing.T) {
+	meta, _ := getTableMeta(reflect.TypeOf(mapperUser{}))
+	s := meta.buildSQL(MysqlDialect{})
+
+	tests := []struct {
+		user      mapperUser
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{mapperUser{Name: "bob", Level: 1}, s.upsertSQL, []interface{}{"bob", 1}},
+		{mapperUser{ID: 7, Name: "bob", Level: 2}, s.upsertAutoSQL, []interface{}{int64(7), "bob", 2}},
+	}
+	for _, tt := range tests {
+		query, args := meta.upsert(s, reflect.ValueOf(&tt.user).Elem())
+		if query != tt.wantQuery || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("upsert %+v: %s %v", tt.user, query, args)
+		}
+	}
+
+	args := meta.updateArgs(reflect.ValueOf(&mapperUser{ID: 7, Name: "bob", Level: 2}).Elem())
+	if !reflect.DeepEqual(args, []interface{}{"bob", 2, int64(7)}) {
+		t.Errorf("update args %v", args)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ID", "id"},
+		{"UserID", "user_id"},
+		{"Name", "name"},
+		{"createdAt", "created_at"},
+		{"HTTPServer", "http_server"},
+		{"Level2Exp", "level2_exp"},
+		{"already_snake", "already_snake"},
+	}
+	for _, tt := range tests {
+		if got := snakeCase(tt.in); got != tt.want {
+			t.Errorf("snakeCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// OpType 操作类型
+type OpType int32
+
+// 操作类型
+const (
+	OpQuery       = OpType(queryTypeQuery)
+	OpQueryRow    = OpType(queryTypeQueryRow)
+	OpExec        = OpType(queryTypeExec)
+	OpTransaction = OpType(queryTypeTx)
+	OpQueryAll    = OpType(queryTypeScan)
+	OpPrepare     = OpType(queryTypePrepare)
+)
+
+func (t OpType) String() string {
+	switch t {
+	case OpQuery:
+		return "query"
+	case OpQueryRow:
+		return "queryRow"
+	case OpExec:
+		return "exec"
+	case OpTransaction:
+		return "transaction"
+	case OpQueryAll:
+		return "queryAll"
+	case OpPrepare:
+		return "prepare"
+	default:
+		return "unknown"
+	}
+}
+
+// Operation 一次数据库操作,事务操作没有Query和Args
+type Operation struct {
+	Type  OpType
+	DbIdx uint32
+	Query string
+	Args  []interface{}
+	Async bool
+
+	queryCtx *queryContext
+}
+
+// Handler 执行操作,返回值按操作类型分别为 *sql.Rows, *sql.Row, sql.Result, []interface{} 或 nil
+type Handler func(ctx context.Context, op *Operation) (interface{}, error)
+
+// Middleware 包装操作的执行,在数据库协程内调用,可修改Query和Args或直接返回错误.
+// QueryRow操作直接返回的错误在Scan时返回
+type Middleware func(next Handler) Handler
+
+// Use 添加中间件,先添加的在外层,Open前调用
+func (m *DB) Use(mw ...Middleware) {
+	m.cfg.middlewares = append(m.cfg.middlewares, mw...)
+}
+
+// handler 中间件包装后的执行函数
+func (m *dbInstance) handler() Handler {
+	h := Handler(m.do)
+	for i := len(m.cfg.middlewares) - 1; i >= 0; i-- {
+		h = m.cfg.middlewares[i](h)
+	}
+	return h
+}
+
+// do 实际执行操作
+func (m *dbInstance) do(ctx context.Context, op *Operation) (ret interface{}, err error) {
+	switch op.Type {
+	case OpQuery:
+		if err = ctx.Err(); err == nil {
+			ret, err = m.doQuery(ctx, m.readerFor(ctx, op), op.Query, op.Args)
+		}
+	case OpQueryRow:
+		ret = m.doQueryRow(ctx, m.readerFor(ctx, op), op.Query, op.Args)
+	case OpExec:
+		if err = ctx.Err(); err == nil {
+			ret, err = m.doExec(ctx, m.db, op.Query, op.Args)
+		}
+	case OpQueryAll:
+		if err = ctx.Err(); err == nil {
+			var rows *sql.Rows
+			if rows, err = m.doQuery(ctx, m.readerFor(ctx, op), op.Query, op.Args); err == nil {
+				ret, err = scanAll(rows, op.queryCtx.scanner)
+			}
+		}
+	case OpTransaction:
+		if err = ctx.Err(); err == nil {
+			err = m.transaction(ctx, op.queryCtx.txFunc)
+		}
+	case OpPrepare:
+		if m.stmts == nil {
+			m.stmts = newStmtCache(0)
+		}
+		err = m.stmts.pin(ctx, m.db, op.Query)
+	}
+	return
+}
@@ -0,0 +1,548 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+)
+
+const defaultMigrationTable = "schema_migrations"
+const migrationLockTimeout = 60
+const migrationTimeLayout = "2006-01-02 15:04:05"
+
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ErrMigrationChecksum 已执行的迁移脚本被修改
+var ErrMigrationChecksum = errors.New("database: applied migration checksum mismatch")
+
+var errMigrationLockTimeout = errors.New("database: acquire migration lock timeout")
+
+// Migration 迁移脚本
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus 迁移状态
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type appliedMigration struct {
+	version   int64
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Migrator 数据库结构迁移,脚本文件名格式: 版本号_名称.up.sql / 版本号_名称.down.sql.
+// 默认使用mysql驱动及MySQL方言,经DB.Migrate执行时使用DB的驱动及方言
+type Migrator struct {
+	migrations []*Migration
+	table      string
+	driverName string
+	dialect    Dialect
+}
+
+// NewMigrator 从目录读取迁移脚本
+func NewMigrator(dir string) (*Migrator, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mapMigration := make(map[int64]*Migration)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		match := migrationFileRegexp.FindStringSubmatch(f.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, exist := mapMigration[version]
+		if !exist {
+			mig = &Migration{Version: version, Name: match[2]}
+			mapMigration[version] = mig
+		} else if mig.Name != match[2] {
+			return nil, fmt.Errorf("database: duplicate migration version %d", version)
+		}
+		if match[3] == "up" {
+			mig.Up = string(buf)
+		} else {
+			mig.Down = string(buf)
+		}
+	}
+
+	mg := &Migrator{
+		migrations: make([]*Migration, 0, len(mapMigration)),
+		table:      defaultMigrationTable,
+		driverName: "mysql",
+		dialect:    MysqlDialect{},
+	}
+	for _, mig := range mapMigration {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("database: migration %d has no up script", mig.Version)
+		}
+		sum := sha256.Sum256([]byte(mig.Up))
+		mig.Checksum = hex.EncodeToString(sum[:])
+		mg.migrations = append(mg.migrations, mig)
+	}
+	sort.Slice(mg.migrations, func(i, j int) bool {
+		return mg.migrations[i].Version < mg.migrations[j].Version
+	})
+
+	return mg, nil
+}
+
+// SetTable 设置记录已执行版本的表名
+func (mg *Migrator) SetTable(table string) {
+	mg.table = table
+}
+
+// SetDriver 设置UpAll/Command打开DSN使用的驱动名及方言,方言同时决定语句占位符和迁移锁的实现
+func (mg *Migrator) SetDriver(driverName string, dialect Dialect) {
+	mg.driverName = driverName
+	mg.dialect = dialect
+}
+
+// Migrations 全部迁移脚本
+func (mg *Migrator) Migrations() []*Migration {
+	return mg.migrations
+}
+
+// Up 执行所有未执行的迁移,返回执行数量
+func (mg *Migrator) Up(db *sql.DB) (n int, err error) {
+	err = mg.withLock(db, func(conn *sql.Conn) error {
+		applied, err := mg.verify(conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range mg.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			logger.Infof("migrate up %d_%s", mig.Version, mig.Name)
+			if err = execScript(conn, mig.Up); err != nil {
+				return fmt.Errorf("database: migration %d_%s: %v", mig.Version, mig.Name, err)
+			}
+			_, err = conn.ExecContext(context.Background(), "INSERT INTO "+mg.dialect.QuoteIdent(mg.table)+" (version,name,checksum,applied_at) VALUES ("+
+				mg.placeholders(4)+")", mig.Version, mig.Name, mig.Checksum, time.Now().UTC().Format(migrationTimeLayout))
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+// Down 按版本倒序回滚steps个已执行的迁移,返回回滚数量
+func (mg *Migrator) Down(db *sql.DB, steps int) (n int, err error) {
+	err = mg.withLock(db, func(conn *sql.Conn) error {
+		applied, err := mg.verify(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(mg.migrations) - 1; i >= 0 && n < steps; i-- {
+			mig := mg.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("database: migration %d_%s has no down script", mig.Version, mig.Name)
+			}
+			logger.Infof("migrate down %d_%s", mig.Version, mig.Name)
+			if err = execScript(conn, mig.Down); err != nil {
+				return fmt.Errorf("database: migration %d_%s: %v", mig.Version, mig.Name, err)
+			}
+			if _, err = conn.ExecContext(context.Background(), "DELETE FROM "+mg.dialect.QuoteIdent(mg.table)+" WHERE version="+mg.dialect.Placeholder(1), mig.Version); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+// Status 各迁移的执行状态
+func (mg *Migrator) Status(db *sql.DB) ([]*MigrationStatus, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := mg.loadApplied(conn)
+	if err != nil {
+		return nil, err
+	}
+	arrStatus := make([]*MigrationStatus, 0, len(mg.migrations))
+	for _, mig := range mg.migrations {
+		status := &MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if a, ok := applied[mig.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = a.appliedAt
+		}
+		arrStatus = append(arrStatus, status)
+	}
+	return arrStatus, nil
+}
+
+// UpAll 对每个DSN执行未执行的迁移,任一DSN失败即停止
+func (mg *Migrator) UpAll(dsns []string) error {
+	for _, dsn := range dsns {
+		if err := mg.withDSN(dsn, func(db *sql.DB) error {
+			n, err := mg.Up(db)
+			if err == nil {
+				logger.Infof("migrate %s: %d applied", maskDSN(dsn), n)
+			}
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Command 命令行入口, args: up | down [n] | status
+func (mg *Migrator) Command(dsns []string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("database: migrate command required: up | down [n] | status")
+	}
+	switch args[0] {
+	case "up":
+		return mg.UpAll(dsns)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return err
+			}
+		}
+		for _, dsn := range dsns {
+			if err := mg.withDSN(dsn, func(db *sql.DB) error {
+				n, err := mg.Down(db, steps)
+				if err == nil {
+					logger.Infof("migrate %s: %d rolled back", maskDSN(dsn), n)
+				}
+				return err
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "status":
+		for _, dsn := range dsns {
+			if err := mg.withDSN(dsn, func(db *sql.DB) error {
+				arrStatus, err := mg.Status(db)
+				if err != nil {
+					return err
+				}
+				for _, status := range arrStatus {
+					if status.Applied {
+						logger.Infof("%s %d_%s applied at %s", maskDSN(dsn), status.Version, status.Name, status.AppliedAt.Format(time.RFC3339))
+					} else {
+						logger.Infof("%s %d_%s pending", maskDSN(dsn), status.Version, status.Name)
+					}
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("database: unknown migrate command %s", args[0])
+	}
+}
+
+func (mg *Migrator) withDSN(dsn string, f func(*sql.DB) error) error {
+	db, err := sql.Open(mg.driverName, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return f(db)
+}
+
+// withLock 在同一连接上持有迁移锁,防止多个进程同时迁移
+func (mg *Migrator) withLock(db *sql.DB, f func(*sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := mg.lock(conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	appliedAtType := "TIMESTAMP"
+	if _, ok := mg.dialect.(MysqlDialect); ok {
+		appliedAtType = "DATETIME"
+	}
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+mg.dialect.QuoteIdent(mg.table)+
+		" (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, checksum CHAR(64) NOT NULL, applied_at "+appliedAtType+" NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+// lock 按方言获取迁移锁: MySQL命名锁, PostgreSQL会话级advisory锁, 其它数据库使用锁表.
+// 锁表的记录在进程异常退出时残留,需手动删除
+func (mg *Migrator) lock(conn *sql.Conn) (func(), error) {
+	lockName := "virgo_migrate_" + mg.table
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*migrationLockTimeout)
+	defer cancel()
+
+	switch mg.dialect.(type) {
+	case MysqlDialect:
+		var locked sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, migrationLockTimeout).Scan(&locked); err != nil {
+			return nil, err
+		}
+		if !locked.Valid || locked.Int64 != 1 {
+			return nil, errMigrationLockTimeout
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+		}, nil
+	case PostgresDialect:
+		h := fnv.New64a()
+		h.Write([]byte(lockName))
+		key := int64(h.Sum64())
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			if ctx.Err() != nil {
+				return nil, errMigrationLockTimeout
+			}
+			return nil, err
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		}, nil
+	}
+
+	lockTable := mg.dialect.QuoteIdent(mg.table + "_lock")
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+lockTable+" (id INTEGER NOT NULL PRIMARY KEY)"); err != nil {
+		return nil, err
+	}
+	insertLock := "INSERT INTO " + lockTable + " (id) VALUES (1)"
+	for {
+		_, err := conn.ExecContext(ctx, insertLock)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil, errMigrationLockTimeout
+		}
+		logger.Warningf("migration lock %s busy: %v", mg.table+"_lock", err)
+		select {
+		case <-ctx.Done():
+			return nil, errMigrationLockTimeout
+		case <-time.After(time.Second):
+		}
+	}
+	return func() {
+		conn.ExecContext(context.Background(), "DELETE FROM "+lockTable+" WHERE id=1")
+	}, nil
+}
+
+func (mg *Migrator) placeholders(n int) string {
+	arr := make([]string, n)
+	for i := range arr {
+		arr[i] = mg.dialect.Placeholder(i + 1)
+	}
+	return strings.Join(arr, ",")
+}
+
+// verify 校验已执行迁移的checksum,不一致时拒绝执行
+func (mg *Migrator) verify(conn *sql.Conn) (map[int64]*appliedMigration, error) {
+	applied, err := mg.loadApplied(conn)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int64]struct{}, len(mg.migrations))
+	for _, mig := range mg.migrations {
+		known[mig.Version] = struct{}{}
+		if a, ok := applied[mig.Version]; ok && a.checksum != mig.Checksum {
+			return nil, fmt.Errorf("%w: %d_%s", ErrMigrationChecksum, mig.Version, mig.Name)
+		}
+	}
+	for version, a := range applied {
+		if _, ok := known[version]; !ok {
+			logger.Warningf("applied migration %d_%s not found in scripts", version, a.name)
+		}
+	}
+	return applied, nil
+}
+
+func (mg *Migrator) loadApplied(conn *sql.Conn) (map[int64]*appliedMigration, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version,name,checksum,applied_at FROM "+mg.dialect.QuoteIdent(mg.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]*appliedMigration)
+	for rows.Next() {
+		a := new(appliedMigration)
+		var appliedAt sql.RawBytes
+		if err = rows.Scan(&a.version, &a.name, &a.checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		// 驱动返回时间类型时按RFC3339格式转为字节
+		if a.appliedAt, err = time.Parse(migrationTimeLayout, string(appliedAt)); err != nil {
+			a.appliedAt, _ = time.Parse(time.RFC3339Nano, string(appliedAt))
+		}
+		applied[a.version] = a
+	}
+	return applied, rows.Err()
+}
+
+func execScript(conn *sql.Conn, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if _, err := conn.ExecContext(context.Background(), stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitStatements 按分号拆分脚本,忽略引号、注释及PostgreSQL美元引用($$...$$)内的分号.
+// 支持MySQL客户端的 DELIMITER 指令,用于存储过程和触发器
+func splitStatements(script string) []string {
+	arr := make([]string, 0, 4)
+	var sb strings.Builder
+	var quote byte
+	delimiter := ";"
+	flush := func() {
+		if stmt := strings.TrimSpace(sb.String()); stmt != "" {
+			arr = append(arr, stmt)
+		}
+		sb.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		if quote != 0 {
+			sb.WriteByte(c)
+			if c == '\\' && quote != '`' && i+1 < len(script) {
+				i++
+				sb.WriteByte(script[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if (i == 0 || script[i-1] == '\n') && strings.TrimSpace(sb.String()) == "" {
+			line := script[i:]
+			if end := strings.IndexByte(line, '\n'); end >= 0 {
+				line = line[:end]
+			}
+			if fields := strings.Fields(line); len(fields) == 2 && strings.EqualFold(fields[0], "DELIMITER") {
+				delimiter = fields[1]
+				i += len(line)
+				continue
+			}
+		}
+		switch {
+		case strings.HasPrefix(script[i:], delimiter):
+			flush()
+			i += len(delimiter) - 1
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			sb.WriteByte(c)
+		case c == '$' && dollarTag(script[i:]) != "":
+			tag := dollarTag(script[i:])
+			end := strings.Index(script[i+len(tag):], tag)
+			if end < 0 {
+				sb.WriteString(script[i:])
+				i = len(script)
+			} else {
+				end += i + 2*len(tag)
+				sb.WriteString(script[i:end])
+				i = end - 1
+			}
+		case c == '#' || (c == '-' && strings.HasPrefix(script[i:], "-- ")):
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+			sb.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+			sb.WriteByte(' ')
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	flush()
+	return arr
+}
+
+// dollarTag s以PostgreSQL美元引用标记($$或$tag$)开头时返回该标记
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+// maskDSN 隐藏DSN中的密码
+func maskDSN(dsn string) string {
+	at := strings.LastIndex(dsn, "@")
+	if at < 0 {
+		return dsn
+	}
+	colon := strings.Index(dsn[:at], ":")
+	if colon < 0 {
+		return dsn
+	}
+	return dsn[:colon+1] + "***" + dsn[at:]
+}
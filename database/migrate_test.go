@@ -0,0 +1,122 @@
+package database_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/database/dbtest"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"basic", "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT)", []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"}},
+		{"quotes", "INSERT INTO a VALUES ('x;y', \"it\\\"s;\");UPDATE `a;b` SET c=1;", []string{"INSERT INTO a VALUES ('x;y', \"it\\\"s;\")", "UPDATE `a;b` SET c=1"}},
+		{"comments", "-- drop; old\nSELECT 1; # note;\n/* a; b */SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"delimiter", "DELIMITER $$\nCREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END$$\nDELIMITER ;\nSELECT 3;",
+			[]string{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "SELECT 3"}},
+		{"dollar quote", "CREATE FUNCTION f() RETURNS int AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql;\nSELECT $1;",
+			[]string{"CREATE FUNCTION f() RETURNS int AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql", "SELECT $1"}},
+		{"empty", " ;\n; -- only comment\n", []string{}},
+	}
+	for _, tt := range tests {
+		if got := database.SplitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q", tt.name, got)
+		}
+	}
+}
+
+func newTestMigrator(t *testing.T) (*database.Migrator, func()) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "1_init.up.sql"), []byte("CREATE TABLE user (id INT);"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "2_item.up.sql"), []byte("CREATE TABLE item (id INT);"), 0644)
+	mg, err := database.NewMigrator(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return mg, func() { os.RemoveAll(dir) }
+}
+
+func hasStatement(rec *dbtest.Recorder, fragment string) bool {
+	for _, stmt := range rec.Statements() {
+		if strings.Contains(stmt.Query, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMigrate(t *testing.T) {
+	mg, cleanup := newTestMigrator(t)
+	defer cleanup()
+	columns := []string{"version", "name", "checksum", "applied_at"}
+
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 1, nil)
+	defer db.Close()
+
+	// 已执行的脚本被修改时拒绝执行
+	rec.Expect("GET_LOCK").WillReturnRows([]string{"locked"}, []interface{}{int64(1)})
+	rec.Expect("FROM `schema_migrations`").WillReturnRows(columns, []interface{}{int64(1), "init", "changed", "2020-01-02 03:04:05"})
+	if err := db.Migrate(mg); !errors.Is(err, database.ErrMigrationChecksum) {
+		t.Fatalf("migrate error %v", err)
+	}
+	if hasStatement(rec, "CREATE TABLE item") || !hasStatement(rec, "RELEASE_LOCK") {
+		t.Fatalf("statements %v", rec.Statements())
+	}
+
+	// 只执行未执行的迁移
+	rec.Reset()
+	rec.Expect("GET_LOCK").WillReturnRows([]string{"locked"}, []interface{}{int64(1)})
+	rec.Expect("FROM `schema_migrations`").WillReturnRows(columns, []interface{}{int64(1), "init", mg.Migrations()[0].Checksum, "2020-01-02 03:04:05"})
+	if err := db.Migrate(mg); err != nil {
+		t.Fatal(err)
+	}
+	if hasStatement(rec, "CREATE TABLE user") || !hasStatement(rec, "CREATE TABLE item") ||
+		!hasStatement(rec, "INSERT INTO `schema_migrations` (version,name,checksum,applied_at) VALUES (?,?,?,?)") {
+		t.Fatalf("statements %v", rec.Statements())
+	}
+
+	// 使用DB的方言:PostgreSQL的advisory锁及占位符
+	rec = dbtest.NewRecorder()
+	pgDB := database.NewDB(p, dbtest.DriverName, database.PostgresDialect{})
+	if err := pgDB.Open(rec.DSN(), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer pgDB.Close()
+	if err := pgDB.Migrate(mg); err != nil {
+		t.Fatal(err)
+	}
+	if !hasStatement(rec, "pg_advisory_lock") || !hasStatement(rec, "pg_advisory_unlock") ||
+		!hasStatement(rec, `INSERT INTO "schema_migrations" (version,name,checksum,applied_at) VALUES ($1,$2,$3,$4)`) {
+		t.Fatalf("statements %v", rec.Statements())
+	}
+
+	// 其它方言使用锁表
+	rec = dbtest.NewRecorder()
+	liteDB := database.NewDB(p, dbtest.DriverName, database.SqliteDialect{})
+	if err := liteDB.Open(rec.DSN(), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer liteDB.Close()
+	if err := liteDB.Migrate(mg); err != nil {
+		t.Fatal(err)
+	}
+	if !hasStatement(rec, `INSERT INTO "schema_migrations_lock" (id) VALUES (1)`) || !hasStatement(rec, `DELETE FROM "schema_migrations_lock"`) {
+		t.Fatalf("statements %v", rec.Statements())
+	}
+}
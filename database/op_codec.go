@@ -0,0 +1,210 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// 参数类型标记
+const (
+	argTagNil byte = iota
+	argTagBool
+	argTagInt
+	argTagUint
+	argTagFloat
+	argTagString
+	argTagBytes
+	argTagTime
+)
+
+var errOpCorrupted = errors.New("database: corrupted operation record")
+
+// opRecord 可落盘的写操作,用于溢出队列和预写日志
+type opRecord struct {
+	dbIdx uint32
+	query string
+	args  []interface{}
+}
+
+// encodeOp 编码写操作,参数仅支持基础类型、[]byte、time.Time及driver.Valuer
+func encodeOp(op *opRecord) ([]byte, error) {
+	buf := make([]byte, 0, 64+len(op.query))
+	buf = appendUint32(buf, op.dbIdx)
+	buf = appendBytes(buf, []byte(op.query))
+	buf = appendUint32(buf, uint32(len(op.args)))
+	var err error
+	for _, arg := range op.args {
+		if buf, err = appendArg(buf, arg); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendArg(buf []byte, arg interface{}) ([]byte, error) {
+	switch v := arg.(type) {
+	case nil:
+		return append(buf, argTagNil), nil
+	case bool:
+		if v {
+			return append(buf, argTagBool, 1), nil
+		}
+		return append(buf, argTagBool, 0), nil
+	case int:
+		return appendUint64(append(buf, argTagInt), uint64(v)), nil
+	case int8:
+		return appendUint64(append(buf, argTagInt), uint64(v)), nil
+	case int16:
+		return appendUint64(append(buf, argTagInt), uint64(v)), nil
+	case int32:
+		return appendUint64(append(buf, argTagInt), uint64(v)), nil
+	case int64:
+		return appendUint64(append(buf, argTagInt), uint64(v)), nil
+	case uint:
+		return appendUint64(append(buf, argTagUint), uint64(v)), nil
+	case uint8:
+		return appendUint64(append(buf, argTagUint), uint64(v)), nil
+	case uint16:
+		return appendUint64(append(buf, argTagUint), uint64(v)), nil
+	case uint32:
+		return appendUint64(append(buf, argTagUint), uint64(v)), nil
+	case uint64:
+		return appendUint64(append(buf, argTagUint), v), nil
+	case float32:
+		return appendUint64(append(buf, argTagFloat), math.Float64bits(float64(v))), nil
+	case float64:
+		return appendUint64(append(buf, argTagFloat), math.Float64bits(v)), nil
+	case string:
+		return appendBytes(append(buf, argTagString), []byte(v)), nil
+	case []byte:
+		return appendBytes(append(buf, argTagBytes), v), nil
+	case time.Time:
+		tb, err := v.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(append(buf, argTagTime), tb), nil
+	case driver.Valuer:
+		// 按执行时的方式取值,结果为driver.Value的基础类型
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return append(buf, argTagNil), nil
+		}
+		val, err := v.Value()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := val.(driver.Valuer); ok {
+			return nil, fmt.Errorf("database: unsupported argument type %T", arg)
+		}
+		return appendArg(buf, val)
+	}
+	return nil, fmt.Errorf("database: unsupported argument type %T", arg)
+}
+
+func decodeOp(buf []byte) (*opRecord, error) {
+	r := &opReader{buf: buf}
+	op := &opRecord{
+		dbIdx: r.uint32(),
+		query: string(r.bytes()),
+	}
+	argNum := r.uint32()
+	if r.err != nil || int(argNum) > len(r.buf) {
+		return nil, errOpCorrupted
+	}
+	op.args = make([]interface{}, 0, argNum)
+	for i := uint32(0); i < argNum && r.err == nil; i++ {
+		switch r.byte() {
+		case argTagNil:
+			op.args = append(op.args, nil)
+		case argTagBool:
+			op.args = append(op.args, r.byte() == 1)
+		case argTagInt:
+			op.args = append(op.args, int64(r.uint64()))
+		case argTagUint:
+			op.args = append(op.args, r.uint64())
+		case argTagFloat:
+			op.args = append(op.args, math.Float64frombits(r.uint64()))
+		case argTagString:
+			op.args = append(op.args, string(r.bytes()))
+		case argTagBytes:
+			b := r.bytes()
+			op.args = append(op.args, append(make([]byte, 0, len(b)), b...))
+		case argTagTime:
+			var t time.Time
+			if err := t.UnmarshalBinary(r.bytes()); err != nil {
+				return nil, errOpCorrupted
+			}
+			op.args = append(op.args, t)
+		default:
+			return nil, errOpCorrupted
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return op, nil
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendBytes(buf []byte, v []byte) []byte {
+	return append(appendUint32(buf, uint32(len(v))), v...)
+}
+
+type opReader struct {
+	buf []byte
+	err error
+}
+
+func (r *opReader) take(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.buf) {
+		r.err = errOpCorrupted
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *opReader) byte() byte {
+	b := r.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *opReader) uint32() uint32 {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *opReader) uint64() uint64 {
+	b := r.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *opReader) bytes() []byte {
+	return r.take(int(r.uint32()))
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+)
+
+const queryStatSamples = 1024
+const maxQueryStats = 4096
+const otherQueryKey = "<other>"
+const txQueryKey = "<transaction>"
+
+// QueryStat 单条语句的统计
+type QueryStat struct {
+	Query  string
+	Count  int64
+	Errors int64
+	Total  time.Duration
+	Max    time.Duration
+	P50    time.Duration
+	P99    time.Duration
+}
+
+type queryStatEntry struct {
+	count   int64
+	errors  int64
+	total   time.Duration
+	max     time.Duration
+	samples []time.Duration
+	next    int
+}
+
+// queryStats 按语句聚合耗时,分位数由最近的queryStatSamples次采样计算
+type queryStats struct {
+	mtx     sync.Mutex
+	entries map[string]*queryStatEntry
+	slow    time.Duration
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{
+		entries: make(map[string]*queryStatEntry),
+	}
+}
+
+// record 记录一次执行,n为本次执行包含的操作数
+func (qs *queryStats) record(query string, args []interface{}, n int, elapsed time.Duration, err error) {
+	if qs.slow > 0 && elapsed >= qs.slow {
+		logger.Warningf("slow query %v: %s; %v", elapsed, query, args)
+	}
+	if query == "" {
+		query = txQueryKey
+	}
+
+	qs.mtx.Lock()
+	defer qs.mtx.Unlock()
+	entry, exist := qs.entries[query]
+	if !exist {
+		if len(qs.entries) >= maxQueryStats {
+			query = otherQueryKey
+			entry = qs.entries[query]
+		}
+		if entry == nil {
+			entry = &queryStatEntry{samples: make([]time.Duration, 0, 16)}
+			qs.entries[query] = entry
+		}
+	}
+
+	entry.count += int64(n)
+	if err != nil {
+		entry.errors += int64(n)
+	}
+	entry.total += elapsed
+	if elapsed > entry.max {
+		entry.max = elapsed
+	}
+	if len(entry.samples) < queryStatSamples {
+		entry.samples = append(entry.samples, elapsed)
+	} else {
+		entry.samples[entry.next] = elapsed
+		entry.next = (entry.next + 1) % queryStatSamples
+	}
+}
+
+func (qs *queryStats) snapshot() []*QueryStat {
+	qs.mtx.Lock()
+	arr := make([]*QueryStat, 0, len(qs.entries))
+	for query, entry := range qs.entries {
+		samples := make([]time.Duration, len(entry.samples))
+		copy(samples, entry.samples)
+		arr = append(arr, &QueryStat{
+			Query:  query,
+			Count:  entry.count,
+			Errors: entry.errors,
+			Total:  entry.total,
+			Max:    entry.max,
+			P50:    percentile(samples, 50),
+			P99:    percentile(samples, 99),
+		})
+	}
+	qs.mtx.Unlock()
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Total > arr[j].Total
+	})
+	return arr
+}
+
+func (qs *queryStats) reset() {
+	qs.mtx.Lock()
+	qs.entries = make(map[string]*queryStatEntry)
+	qs.mtx.Unlock()
+}
+
+func percentile(samples []time.Duration, p int) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	idx := (len(samples)*p+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return samples[idx]
+}
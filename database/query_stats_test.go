@@ -0,0 +1,69 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQueryStats(t *testing.T) {
+	qs := newQueryStats()
+	for i := 1; i <= 100; i++ {
+		var err error
+		if i%10 == 0 {
+			err = errors.New("fail")
+		}
+		qs.record("SELECT a", nil, 1, time.Duration(i)*time.Millisecond, err)
+	}
+	qs.record("INSERT b", nil, 5, time.Second, nil)
+	qs.record("", nil, 1, time.Millisecond, nil)
+
+	stats := qs.snapshot()
+	if len(stats) != 3 {
+		t.Fatalf("stats %d", len(stats))
+	}
+	// 按总耗时降序
+	a := stats[0]
+	if a.Query != "SELECT a" || a.Count != 100 || a.Errors != 10 || a.Total != 5050*time.Millisecond || a.Max != 100*time.Millisecond {
+		t.Fatalf("stat %+v", a)
+	}
+	if a.P50 != 50*time.Millisecond || a.P99 != 99*time.Millisecond {
+		t.Fatalf("p50 %v, p99 %v", a.P50, a.P99)
+	}
+	if b := stats[1]; b.Query != "INSERT b" || b.Count != 5 || b.P50 != time.Second || b.P99 != time.Second {
+		t.Fatalf("batch stat %+v", b)
+	}
+	if stats[2].Query != txQueryKey {
+		t.Fatalf("transaction key %s", stats[2].Query)
+	}
+
+	// 分位数只取最近的采样
+	for i := 0; i < queryStatSamples; i++ {
+		qs.record("SELECT a", nil, 1, time.Microsecond, nil)
+	}
+	if a = qs.snapshot()[0]; a.P99 != time.Microsecond || a.Max != 100*time.Millisecond {
+		t.Fatalf("recent samples %+v", a)
+	}
+
+	qs.reset()
+	if len(qs.snapshot()) != 0 {
+		t.Fatal("stats not reset")
+	}
+}
+
+func TestQueryStatsOverflow(t *testing.T) {
+	qs := newQueryStats()
+	for i := 0; i < maxQueryStats+10; i++ {
+		qs.record("SELECT "+strconv.Itoa(i), nil, 1, time.Millisecond, nil)
+	}
+	stats := qs.snapshot()
+	if len(stats) != maxQueryStats+1 {
+		t.Fatalf("stats %d", len(stats))
+	}
+	for _, stat := range stats {
+		if stat.Query == otherQueryKey && stat.Count != 10 {
+			t.Fatalf("other count %d", stat.Count)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+)
+
+const (
+	subscribeMinBackoff = time.Second
+	subscribeMaxBackoff = time.Second * 30
+)
+
+// Subscription 订阅,使用独立连接,断线后自动重连并重新订阅.
+// 消息回调在主线程执行,参数为channel string, payload []byte, pattern string(非模式订阅时为"")
+type Subscription struct {
+	m      *Redis
+	cb     func([]interface{})
+	cmd    []interface{}
+	mtx    sync.Mutex
+	conn   net.Conn
+	closed bool
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// Subscribe 订阅频道
+func (m *Redis) Subscribe(cb func([]interface{}), channels ...string) (*Subscription, error) {
+	return m.subscribe(cb, "SUBSCRIBE", channels)
+}
+
+// PSubscribe 按模式订阅频道
+func (m *Redis) PSubscribe(cb func([]interface{}), patterns ...string) (*Subscription, error) {
+	return m.subscribe(cb, "PSUBSCRIBE", patterns)
+}
+
+// Publish 发布消息,返回收到消息的订阅者数量
+func (m *Redis) Publish(idx uint32, channel string, message interface{}) (int64, error) {
+	return Int64(m.Do(idx, "PUBLISH", channel, message))
+}
+
+func (m *Redis) subscribe(cb func([]interface{}), cmd string, names []string) (*Subscription, error) {
+	s := &Subscription{
+		m:    m,
+		cb:   cb,
+		cmd:  make([]interface{}, 0, len(names)+1),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	s.cmd = append(s.cmd, cmd)
+	for _, name := range names {
+		s.cmd = append(s.cmd, name)
+	}
+
+	r, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mtx.Lock()
+	if m.closed {
+		m.mtx.Unlock()
+		s.conn.Close()
+		return nil, ErrClosed
+	}
+	m.subs[s] = struct{}{}
+	m.mtx.Unlock()
+
+	go s.run(r)
+	return s, nil
+}
+
+// Close 取消订阅并关闭连接
+func (s *Subscription) Close() {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.quit)
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mtx.Unlock()
+	<-s.done
+
+	s.m.mtx.Lock()
+	delete(s.m.subs, s)
+	s.m.mtx.Unlock()
+}
+
+// connect 建立连接并订阅,等待所有订阅确认
+func (s *Subscription) connect() (*bufio.Reader, error) {
+	conn, r, w, err := dial(s.m.addr, s.m.cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(s.m.cfg.dialTimeout))
+	if err = writeCommand(w, s.cmd); err == nil {
+		err = w.Flush()
+	}
+	for i := 1; i < len(s.cmd) && err == nil; i++ {
+		var reply interface{}
+		if reply, err = readReply(r); err == nil {
+			if redisErr, ok := reply.(Error); ok {
+				err = redisErr
+			}
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		conn.Close()
+		return nil, ErrClosed
+	}
+	s.conn = conn
+	return r, nil
+}
+
+func (s *Subscription) run(r *bufio.Reader) {
+	defer close(s.done)
+	backoff := subscribeMinBackoff
+	for {
+		err := s.receive(r)
+		if s.isClosed() {
+			return
+		}
+		logger.Errorf("redis subscription %s error: %v", s.m.addr, err)
+		for {
+			select {
+			case <-time.After(backoff):
+			case <-s.quit:
+				return
+			}
+			if r, err = s.connect(); err == nil {
+				backoff = subscribeMinBackoff
+				break
+			}
+			if err == ErrClosed {
+				return
+			}
+			if backoff *= 2; backoff > subscribeMaxBackoff {
+				backoff = subscribeMaxBackoff
+			}
+		}
+	}
+}
+
+// receive 读取推送的消息直到连接出错
+func (s *Subscription) receive(r *bufio.Reader) error {
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return err
+		}
+		arr, ok := reply.([]interface{})
+		if !ok || len(arr) < 3 {
+			continue
+		}
+		kind, _ := String(arr[0], nil)
+		switch {
+		case kind == "message":
+			channel, _ := String(arr[1], nil)
+			payload, _ := arr[2].([]byte)
+			s.m.p.SyncTask(s.cb, channel, payload, "")
+		case kind == "pmessage" && len(arr) >= 4:
+			pattern, _ := String(arr[1], nil)
+			channel, _ := String(arr[2], nil)
+			payload, _ := arr[3].([]byte)
+			s.m.p.SyncTask(s.cb, channel, payload, pattern)
+		}
+	}
+}
+
+func (s *Subscription) isClosed() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.closed
+}
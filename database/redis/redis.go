@@ -0,0 +1,397 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+	"github.com/panlibin/virgo"
+)
+
+const defaultQueueSize = 1024
+const defaultDialTimeout = time.Second * 5
+const defaultTimeout = time.Second * 5
+
+// ErrClosed 客户端已关闭
+var ErrClosed = errors.New("redis: client closed")
+
+// ErrPubSubCommand 订阅命令需使用Subscribe/PSubscribe
+var ErrPubSubCommand = errors.New("redis: use Subscribe for pub/sub commands")
+
+type redisConfig struct {
+	password    string
+	db          int
+	dialTimeout time.Duration
+	timeout     time.Duration
+	queueSize   int
+}
+
+type request struct {
+	cmds         [][]interface{}
+	pipeline     bool
+	callbackChan chan []interface{}
+	async        bool
+	cb           func([]interface{})
+	ctx          interface{}
+}
+
+// redisInstance 一个连接及其执行协程,同一实例的命令按顺序执行
+type redisInstance struct {
+	p       virgo.IProcedure
+	cfg     *redisConfig
+	addr    string
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	reqChan chan *request
+	wg      *sync.WaitGroup
+}
+
+func (m *redisInstance) open(addr string, wg *sync.WaitGroup, cfg *redisConfig) error {
+	m.addr = addr
+	m.cfg = cfg
+	m.wg = wg
+	if err := m.connect(); err != nil {
+		return err
+	}
+	m.reqChan = make(chan *request, cfg.queueSize)
+	m.wg.Add(1)
+	go m.run()
+	return nil
+}
+
+func (m *redisInstance) close() {
+	if m.reqChan != nil {
+		m.reqChan <- nil
+	}
+}
+
+func (m *redisInstance) run() {
+	defer m.wg.Done()
+	for req := range m.reqChan {
+		if req == nil {
+			break
+		}
+		m.execute(req)
+	}
+	m.disconnect()
+}
+
+func (m *redisInstance) execute(req *request) {
+	var replies []interface{}
+	var err error
+	for _, cmd := range req.cmds {
+		if name, _ := cmd[0].(string); isPubSubCommand(name) {
+			err = ErrPubSubCommand
+			break
+		}
+	}
+	if err == nil {
+		replies, err = m.roundTrip(req.cmds)
+	}
+	var ret interface{}
+	if err == nil {
+		if req.pipeline {
+			ret = replies
+		} else {
+			ret = replies[0]
+			if redisErr, ok := ret.(Error); ok {
+				err = redisErr
+			}
+		}
+	}
+
+	if req.async {
+		if req.cb != nil {
+			m.p.SyncTask(req.cb, req.ctx, ret, err)
+		}
+	} else {
+		req.callbackChan <- []interface{}{ret, err}
+	}
+}
+
+// roundTrip 发送全部命令后依次读取回复,连接错误时断开,下次执行时重连
+func (m *redisInstance) roundTrip(cmds [][]interface{}) ([]interface{}, error) {
+	if m.conn == nil {
+		if err := m.connect(); err != nil {
+			return nil, err
+		}
+	}
+	replies, err := m.doRoundTrip(cmds)
+	if err != nil {
+		logger.Errorf("redis %s error: %v", m.addr, err)
+		m.disconnect()
+	}
+	return replies, err
+}
+
+func (m *redisInstance) doRoundTrip(cmds [][]interface{}) ([]interface{}, error) {
+	if m.cfg.timeout > 0 {
+		m.conn.SetDeadline(time.Now().Add(m.cfg.timeout))
+	}
+	for _, cmd := range cmds {
+		if err := writeCommand(m.w, cmd); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.w.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	for i := range replies {
+		reply, err := readReply(m.r)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func (m *redisInstance) connect() error {
+	conn, r, w, err := dial(m.addr, m.cfg)
+	if err != nil {
+		return err
+	}
+	m.conn, m.r, m.w = conn, r, w
+	return nil
+}
+
+func (m *redisInstance) disconnect() {
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+	}
+}
+
+// dial 建立连接并认证、选择数据库
+func dial(addr string, cfg *redisConfig) (net.Conn, *bufio.Reader, *bufio.Writer, error) {
+	conn, err := net.DialTimeout("tcp", addr, cfg.dialTimeout)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	var cmds [][]interface{}
+	if cfg.password != "" {
+		cmds = append(cmds, []interface{}{"AUTH", cfg.password})
+	}
+	if cfg.db != 0 {
+		cmds = append(cmds, []interface{}{"SELECT", cfg.db})
+	}
+	if len(cmds) > 0 {
+		conn.SetDeadline(time.Now().Add(cfg.dialTimeout))
+		for _, cmd := range cmds {
+			if err = writeCommand(w, cmd); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		for i := 0; i < len(cmds) && err == nil; i++ {
+			var reply interface{}
+			if reply, err = readReply(r); err == nil {
+				if redisErr, ok := reply.(Error); ok {
+					err = redisErr
+				}
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, nil, err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, r, w, nil
+}
+
+// Redis RESP协议的键值缓存客户端,每个实例一个连接和协程顺序执行命令,异步回调在主线程执行
+type Redis struct {
+	p       virgo.IProcedure
+	addr    string
+	arrInst []*redisInstance
+	wg      *sync.WaitGroup
+	cfg     *redisConfig
+	subs    map[*Subscription]struct{}
+	mtx     sync.RWMutex
+	closed  bool
+}
+
+// NewRedis 新建
+func NewRedis(p virgo.IProcedure) *Redis {
+	return &Redis{
+		p:  p,
+		wg: &sync.WaitGroup{},
+		cfg: &redisConfig{
+			dialTimeout: defaultDialTimeout,
+			timeout:     defaultTimeout,
+			queueSize:   defaultQueueSize,
+		},
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// SetPassword 设置密码,Open前调用
+func (m *Redis) SetPassword(password string) {
+	m.cfg.password = password
+}
+
+// SetDB 设置数据库序号,Open前调用
+func (m *Redis) SetDB(db int) {
+	m.cfg.db = db
+}
+
+// SetTimeout 设置连接超时及单次读写超时,0为不限,Open前调用
+func (m *Redis) SetTimeout(dialTimeout time.Duration, timeout time.Duration) {
+	m.cfg.dialTimeout = dialTimeout
+	m.cfg.timeout = timeout
+}
+
+// SetQueueSize 设置每个实例的队列长度,Open前调用
+func (m *Redis) SetQueueSize(size int) {
+	m.cfg.queueSize = size
+}
+
+// Open 连接,instNum为实例数
+func (m *Redis) Open(addr string, instNum int32) error {
+	m.addr = addr
+	m.arrInst = make([]*redisInstance, 0, instNum)
+	for i := int32(0); i < instNum; i++ {
+		inst := &redisInstance{p: m.p}
+		if err := inst.open(addr, m.wg, m.cfg); err != nil {
+			m.Close()
+			return err
+		}
+		m.arrInst = append(m.arrInst, inst)
+	}
+	return nil
+}
+
+// Close 等待已入队的命令执行完毕后关闭连接,同时关闭所有订阅,之后的命令返回ErrClosed
+func (m *Redis) Close() {
+	m.mtx.Lock()
+	if m.closed {
+		m.mtx.Unlock()
+		return
+	}
+	m.closed = true
+	subs := m.subs
+	m.subs = make(map[*Subscription]struct{})
+	m.mtx.Unlock()
+	for s := range subs {
+		s.Close()
+	}
+
+	for _, inst := range m.arrInst {
+		inst.close()
+	}
+	m.wg.Wait()
+}
+
+// Do 执行命令,服务器返回错误时err为Error
+func (m *Redis) Do(idx uint32, cmd string, args ...interface{}) (interface{}, error) {
+	ret := <-m.push(idx, &request{cmds: [][]interface{}{commandArgs(cmd, args)}})
+	err, _ := ret[1].(error)
+	return ret[0], err
+}
+
+// AsyncDo 执行命令,回调参数为ctx, reply, err
+func (m *Redis) AsyncDo(ctx interface{}, cb func([]interface{}), idx uint32, cmd string, args ...interface{}) {
+	m.push(idx, &request{
+		cmds:  [][]interface{}{commandArgs(cmd, args)},
+		async: true,
+		cb:    cb,
+		ctx:   ctx,
+	})
+}
+
+// Pipe 批量发送管道中的命令,返回各命令的回复,服务器返回的错误以Error保存在对应位置
+func (m *Redis) Pipe(idx uint32, pl *Pipeline) ([]interface{}, error) {
+	if len(pl.cmds) == 0 {
+		return nil, nil
+	}
+	ret := <-m.push(idx, &request{cmds: pl.cmds, pipeline: true})
+	replies, _ := ret[0].([]interface{})
+	err, _ := ret[1].(error)
+	return replies, err
+}
+
+// AsyncPipe 批量发送管道中的命令,回调参数为ctx, []interface{}, err
+func (m *Redis) AsyncPipe(ctx interface{}, cb func([]interface{}), idx uint32, pl *Pipeline) {
+	if len(pl.cmds) == 0 {
+		if cb != nil {
+			m.p.SyncTask(cb, ctx, []interface{}{}, nil)
+		}
+		return
+	}
+	m.push(idx, &request{
+		cmds:     pl.cmds,
+		pipeline: true,
+		async:    true,
+		cb:       cb,
+		ctx:      ctx,
+	})
+}
+
+// push 入队,持有读锁保证Close前入队的命令排在结束标记之前
+func (m *Redis) push(idx uint32, req *request) chan []interface{} {
+	if !req.async {
+		req.callbackChan = make(chan []interface{}, 1)
+	}
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	if m.closed || len(m.arrInst) == 0 {
+		if req.async {
+			if req.cb != nil {
+				m.p.SyncTask(req.cb, req.ctx, nil, ErrClosed)
+			}
+		} else {
+			req.callbackChan <- []interface{}{nil, ErrClosed}
+		}
+		return req.callbackChan
+	}
+	inst := m.arrInst[idx%uint32(len(m.arrInst))]
+	inst.reqChan <- req
+	return req.callbackChan
+}
+
+func commandArgs(cmd string, args []interface{}) []interface{} {
+	cmdArgs := make([]interface{}, 0, len(args)+1)
+	cmdArgs = append(cmdArgs, cmd)
+	return append(cmdArgs, args...)
+}
+
+// Pipeline 管道,命令在同一实例上一次发送
+type Pipeline struct {
+	cmds [][]interface{}
+}
+
+// NewPipeline 新建
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Add 添加命令
+func (pl *Pipeline) Add(cmd string, args ...interface{}) *Pipeline {
+	pl.cmds = append(pl.cmds, commandArgs(cmd, args))
+	return pl
+}
+
+// Len 命令数
+func (pl *Pipeline) Len() int {
+	return len(pl.cmds)
+}
+
+func isPubSubCommand(cmd string) bool {
+	switch strings.ToUpper(cmd) {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/panlibin/virgo/database/redis/redistest"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+func openTest(t *testing.T) (*Redis, *virgotest.Procedure, *redistest.Server) {
+	srv, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := virgotest.NewProcedure()
+	r := NewRedis(p)
+	if err = r.Open(srv.Addr(), 2); err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return r, p, srv
+}
+
+func TestDoAndPipeline(t *testing.T) {
+	r, p, srv := openTest(t)
+	defer srv.Close()
+	defer r.Close()
+
+	if _, err := r.Do(0, "SET", "name", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := String(r.Do(1, "GET", "name")); err != nil || name != "alice" {
+		t.Fatalf("GET %q %v", name, err)
+	}
+	if _, err := String(r.Do(0, "GET", "missing")); err != ErrNil {
+		t.Fatalf("expected ErrNil, got %v", err)
+	}
+	if _, err := r.Do(0, "INCR", "name"); err == nil {
+		t.Fatal("expected server error")
+	}
+
+	pl := NewPipeline().
+		Add("ZADD", "rank", 10, "a", 30, "b", 20, "c").
+		Add("ZINCRBY", "rank", 15, "a").
+		Add("ZREVRANGE", "rank", 0, -1).
+		Add("HGET", "name", "field")
+	var replies []interface{}
+	var pipeErr error
+	r.AsyncPipe("ctx", func(args []interface{}) {
+		replies, _ = args[1].([]interface{})
+		pipeErr, _ = args[2].(error)
+	}, 0, pl)
+	if !p.RunOne(time.Second) {
+		t.Fatal("callback not delivered")
+	}
+	if pipeErr != nil || len(replies) != 4 {
+		t.Fatalf("pipeline %v %v", replies, pipeErr)
+	}
+	if rank, _ := Strings(replies[2], nil); len(rank) != 3 || rank[0] != "b" || rank[1] != "a" {
+		t.Fatalf("unexpected rank %v", rank)
+	}
+	if _, ok := replies[3].(Error); !ok {
+		t.Fatalf("expected Error reply, got %v", replies[3])
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	r, _, srv := openTest(t)
+	defer srv.Close()
+	defer r.Close()
+
+	srv.CloseClients()
+	// 连接断开后第一次执行失败,之后重连
+	var err error
+	for i := 0; i < 2; i++ {
+		if _, err = r.Do(0, "PING"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	r, p, srv := openTest(t)
+	defer srv.Close()
+	defer r.Close()
+
+	var got []string
+	sub, err := r.PSubscribe(func(args []interface{}) {
+		got = append(got, args[0].(string)+"="+string(args[1].([]byte))+"@"+args[2].(string))
+	}, "guild.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	if n, err := r.Publish(0, "guild.1", "hello"); err != nil || n != 1 {
+		t.Fatalf("publish %d %v", n, err)
+	}
+	if !p.RunOne(time.Second) {
+		t.Fatal("message not delivered")
+	}
+	if len(got) != 1 || got[0] != "guild.1=hello@guild.*" {
+		t.Fatalf("unexpected messages %v", got)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	r, p, srv := openTest(t)
+	defer srv.Close()
+	r.Close()
+	r.Close()
+
+	// 关闭后的命令立即返回ErrClosed,不会阻塞
+	for i := 0; i < defaultQueueSize+1; i++ {
+		if _, err := r.Do(0, "GET", "name"); err != ErrClosed {
+			t.Fatalf("Do after close: %v", err)
+		}
+	}
+	if _, err := r.Pipe(1, NewPipeline().Add("GET", "name")); err != ErrClosed {
+		t.Fatalf("Pipe after close: %v", err)
+	}
+	var asyncErr error
+	r.AsyncDo(nil, func(args []interface{}) {
+		asyncErr, _ = args[2].(error)
+	}, 0, "GET", "name")
+	if !p.RunOne(time.Second) || asyncErr != ErrClosed {
+		t.Fatalf("AsyncDo after close: %v", asyncErr)
+	}
+}
@@ -0,0 +1,699 @@
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status 状态回复,如 +OK
+type Status string
+
+// Error 错误回复
+type Error string
+
+// Handler 自定义命令处理,args不含命令名.
+// 返回值: Status, Error, int/int64, string/[]byte(字符串), []interface{}(数组), nil(空值)
+type Handler func(args []string) interface{}
+
+var errRedisSyntax = Error("ERR syntax error")
+var errRedisWrongType = Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+var errRedisNotInt = Error("ERR value is not an integer or out of range")
+var errRedisNotFloat = Error("ERR value is not a valid float")
+
+type redisEntry struct {
+	value    interface{} // string, map[string]string, map[string]float64
+	expireAt time.Time
+}
+
+// Server 进程内的RESP服务,支持字符串、哈希、有序集合、过期及发布订阅的常用命令,用于测试
+type Server struct {
+	listener net.Listener
+	mtx      sync.Mutex
+	data     map[string]*redisEntry
+	handlers map[string]Handler
+	subs     map[*redisClient]struct{}
+	clients  map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+type redisClient struct {
+	mtx      sync.Mutex
+	w        *bufio.Writer
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// NewServer 在本地随机端口启动
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		data:     make(map[string]*redisEntry),
+		handlers: make(map[string]Handler),
+		subs:     make(map[*redisClient]struct{}),
+		clients:  make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr 监听地址
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Handle 注册或覆盖命令处理
+func (s *Server) Handle(cmd string, h Handler) {
+	s.mtx.Lock()
+	s.handlers[strings.ToUpper(cmd)] = h
+	s.mtx.Unlock()
+}
+
+// Flush 清空数据
+func (s *Server) Flush() {
+	s.mtx.Lock()
+	s.data = make(map[string]*redisEntry)
+	s.mtx.Unlock()
+}
+
+// CloseClients 断开所有客户端连接,用于测试重连
+func (s *Server) CloseClients() {
+	s.mtx.Lock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+}
+
+// Close 停止服务并断开所有连接
+func (s *Server) Close() {
+	s.listener.Close()
+	s.CloseClients()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mtx.Lock()
+		s.clients[conn] = struct{}{}
+		s.mtx.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	c := &redisClient{
+		w:        bufio.NewWriter(conn),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	defer func() {
+		s.mtx.Lock()
+		delete(s.subs, c)
+		delete(s.clients, conn)
+		s.mtx.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		cmd := strings.ToUpper(args[0])
+		var replies []interface{}
+		switch cmd {
+		case "SUBSCRIBE", "PSUBSCRIBE":
+			replies = s.subscribe(c, cmd, args[1:])
+		default:
+			replies = []interface{}{s.execute(cmd, args[1:])}
+		}
+		c.mtx.Lock()
+		for _, reply := range replies {
+			writeRedisReply(c.w, reply)
+		}
+		err = c.w.Flush()
+		c.mtx.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) subscribe(c *redisClient, cmd string, names []string) []interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.subs[c] = struct{}{}
+	kind := strings.ToLower(cmd)
+	replies := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		if cmd == "SUBSCRIBE" {
+			c.channels[name] = struct{}{}
+		} else {
+			c.patterns[name] = struct{}{}
+		}
+		replies = append(replies, []interface{}{kind, name, len(c.channels) + len(c.patterns)})
+	}
+	return replies
+}
+
+func (s *Server) publish(channel string, message string) int {
+	n := 0
+	for c := range s.subs {
+		var msgs []interface{}
+		if _, ok := c.channels[channel]; ok {
+			msgs = append(msgs, []interface{}{"message", channel, message})
+		}
+		for pattern := range c.patterns {
+			if ok, _ := path.Match(pattern, channel); ok {
+				msgs = append(msgs, []interface{}{"pmessage", pattern, channel, message})
+			}
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		c.mtx.Lock()
+		for _, msg := range msgs {
+			writeRedisReply(c.w, msg)
+		}
+		c.w.Flush()
+		c.mtx.Unlock()
+		n += len(msgs)
+	}
+	return n
+}
+
+func (s *Server) execute(cmd string, args []string) interface{} {
+	s.mtx.Lock()
+	h, ok := s.handlers[cmd]
+	s.mtx.Unlock()
+	if ok {
+		return h(args)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	switch cmd {
+	case "PING":
+		if len(args) > 0 {
+			return args[0]
+		}
+		return Status("PONG")
+	case "AUTH", "SELECT":
+		return Status("OK")
+	case "PUBLISH":
+		if len(args) != 2 {
+			return errRedisSyntax
+		}
+		return s.publish(args[0], args[1])
+	case "GET":
+		if len(args) != 1 {
+			return errRedisSyntax
+		}
+		return s.getString(args[0])
+	case "SET":
+		return s.set(args)
+	case "DEL":
+		n := 0
+		for _, key := range args {
+			if s.entry(key) != nil {
+				delete(s.data, key)
+				n++
+			}
+		}
+		return n
+	case "EXISTS":
+		n := 0
+		for _, key := range args {
+			if s.entry(key) != nil {
+				n++
+			}
+		}
+		return n
+	case "INCR", "INCRBY", "DECR", "DECRBY":
+		return s.incr(cmd, args)
+	case "EXPIRE", "PEXPIRE":
+		return s.expire(cmd, args)
+	case "TTL":
+		return s.ttl(args)
+	case "HSET", "HGET", "HDEL", "HGETALL", "HINCRBY":
+		return s.hash(cmd, args)
+	case "ZADD", "ZINCRBY", "ZSCORE", "ZREM", "ZCARD", "ZRANGE", "ZREVRANGE", "ZRANK", "ZREVRANK":
+		return s.zset(cmd, args)
+	default:
+		return Error("ERR unknown command '" + cmd + "'")
+	}
+}
+
+// entry 未过期的键,调用时持有锁
+func (s *Server) entry(key string) *redisEntry {
+	e, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !e.expireAt.IsZero() && !time.Now().Before(e.expireAt) {
+		delete(s.data, key)
+		return nil
+	}
+	return e
+}
+
+func (s *Server) getString(key string) interface{} {
+	e := s.entry(key)
+	if e == nil {
+		return nil
+	}
+	v, ok := e.value.(string)
+	if !ok {
+		return errRedisWrongType
+	}
+	return v
+}
+
+// set SET key value [EX seconds|PX milliseconds] [NX|XX]
+func (s *Server) set(args []string) interface{} {
+	if len(args) < 2 {
+		return errRedisSyntax
+	}
+	e := &redisEntry{value: args[1]}
+	nx, xx := false, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return errRedisSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errRedisNotInt
+			}
+			unit := time.Second
+			if strings.ToUpper(args[i]) == "PX" {
+				unit = time.Millisecond
+			}
+			e.expireAt = time.Now().Add(time.Duration(n) * unit)
+			i++
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return errRedisSyntax
+		}
+	}
+	exist := s.entry(args[0]) != nil
+	if (nx && exist) || (xx && !exist) {
+		return nil
+	}
+	s.data[args[0]] = e
+	return Status("OK")
+}
+
+func (s *Server) incr(cmd string, args []string) interface{} {
+	delta := int64(1)
+	if cmd == "INCRBY" || cmd == "DECRBY" {
+		if len(args) != 2 {
+			return errRedisSyntax
+		}
+		var err error
+		if delta, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return errRedisNotInt
+		}
+	} else if len(args) != 1 {
+		return errRedisSyntax
+	}
+	if cmd == "DECR" || cmd == "DECRBY" {
+		delta = -delta
+	}
+	e := s.entry(args[0])
+	var n int64
+	if e != nil {
+		str, ok := e.value.(string)
+		if !ok {
+			return errRedisWrongType
+		}
+		var err error
+		if n, err = strconv.ParseInt(str, 10, 64); err != nil {
+			return errRedisNotInt
+		}
+	} else {
+		e = &redisEntry{}
+		s.data[args[0]] = e
+	}
+	n += delta
+	e.value = strconv.FormatInt(n, 10)
+	return n
+}
+
+func (s *Server) expire(cmd string, args []string) interface{} {
+	if len(args) != 2 {
+		return errRedisSyntax
+	}
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errRedisNotInt
+	}
+	e := s.entry(args[0])
+	if e == nil {
+		return 0
+	}
+	unit := time.Second
+	if cmd == "PEXPIRE" {
+		unit = time.Millisecond
+	}
+	e.expireAt = time.Now().Add(time.Duration(n) * unit)
+	return 1
+}
+
+func (s *Server) ttl(args []string) interface{} {
+	if len(args) != 1 {
+		return errRedisSyntax
+	}
+	e := s.entry(args[0])
+	if e == nil {
+		return -2
+	}
+	if e.expireAt.IsZero() {
+		return -1
+	}
+	return int64(time.Until(e.expireAt).Seconds() + 0.5)
+}
+
+func (s *Server) hash(cmd string, args []string) interface{} {
+	if len(args) < 1 {
+		return errRedisSyntax
+	}
+	var h map[string]string
+	if e := s.entry(args[0]); e != nil {
+		var ok bool
+		if h, ok = e.value.(map[string]string); !ok {
+			return errRedisWrongType
+		}
+	}
+
+	switch cmd {
+	case "HSET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return errRedisSyntax
+		}
+		if h == nil {
+			h = make(map[string]string)
+			s.data[args[0]] = &redisEntry{value: h}
+		}
+		n := 0
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return n
+	case "HGET":
+		if len(args) != 2 {
+			return errRedisSyntax
+		}
+		if v, ok := h[args[1]]; ok {
+			return v
+		}
+		return nil
+	case "HDEL":
+		n := 0
+		for _, field := range args[1:] {
+			if _, ok := h[field]; ok {
+				delete(h, field)
+				n++
+			}
+		}
+		if h != nil && len(h) == 0 {
+			delete(s.data, args[0])
+		}
+		return n
+	case "HGETALL":
+		fields := make([]string, 0, len(h))
+		for field := range h {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		arr := make([]interface{}, 0, len(h)*2)
+		for _, field := range fields {
+			arr = append(arr, field, h[field])
+		}
+		return arr
+	case "HINCRBY":
+		if len(args) != 3 {
+			return errRedisSyntax
+		}
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errRedisNotInt
+		}
+		if h == nil {
+			h = make(map[string]string)
+			s.data[args[0]] = &redisEntry{value: h}
+		}
+		var n int64
+		if v, ok := h[args[1]]; ok {
+			if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return errRedisNotInt
+			}
+		}
+		n += delta
+		h[args[1]] = strconv.FormatInt(n, 10)
+		return n
+	}
+	return errRedisSyntax
+}
+
+type zmember struct {
+	member string
+	score  float64
+}
+
+// sortedMembers 按分数升序,分数相同按成员字典序
+func sortedMembers(z map[string]float64) []zmember {
+	arr := make([]zmember, 0, len(z))
+	for member, score := range z {
+		arr = append(arr, zmember{member: member, score: score})
+	}
+	sort.Slice(arr, func(i, j int) bool {
+		if arr[i].score != arr[j].score {
+			return arr[i].score < arr[j].score
+		}
+		return arr[i].member < arr[j].member
+	})
+	return arr
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+func (s *Server) zset(cmd string, args []string) interface{} {
+	if len(args) < 1 {
+		return errRedisSyntax
+	}
+	var z map[string]float64
+	if e := s.entry(args[0]); e != nil {
+		var ok bool
+		if z, ok = e.value.(map[string]float64); !ok {
+			return errRedisWrongType
+		}
+	}
+
+	switch cmd {
+	case "ZADD", "ZINCRBY":
+		if len(args) < 3 || len(args)%2 != 1 || (cmd == "ZINCRBY" && len(args) != 3) {
+			return errRedisSyntax
+		}
+		if z == nil {
+			z = make(map[string]float64)
+			s.data[args[0]] = &redisEntry{value: z}
+		}
+		n := 0
+		for i := 1; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return errRedisNotFloat
+			}
+			old, exist := z[args[i+1]]
+			if !exist {
+				n++
+			}
+			if cmd == "ZINCRBY" {
+				z[args[i+1]] = old + score
+				return formatScore(old + score)
+			}
+			z[args[i+1]] = score
+		}
+		return n
+	case "ZSCORE":
+		if len(args) != 2 {
+			return errRedisSyntax
+		}
+		if score, ok := z[args[1]]; ok {
+			return formatScore(score)
+		}
+		return nil
+	case "ZREM":
+		n := 0
+		for _, member := range args[1:] {
+			if _, ok := z[member]; ok {
+				delete(z, member)
+				n++
+			}
+		}
+		if z != nil && len(z) == 0 {
+			delete(s.data, args[0])
+		}
+		return n
+	case "ZCARD":
+		return len(z)
+	case "ZRANK", "ZREVRANK":
+		if len(args) != 2 {
+			return errRedisSyntax
+		}
+		arr := sortedMembers(z)
+		for i, m := range arr {
+			if m.member == args[1] {
+				if cmd == "ZREVRANK" {
+					return len(arr) - 1 - i
+				}
+				return i
+			}
+		}
+		return nil
+	case "ZRANGE", "ZREVRANGE":
+		if len(args) < 3 {
+			return errRedisSyntax
+		}
+		withScores := len(args) == 4 && strings.ToUpper(args[3]) == "WITHSCORES"
+		if len(args) > 4 || (len(args) == 4 && !withScores) {
+			return errRedisSyntax
+		}
+		start, err1 := strconv.Atoi(args[1])
+		stop, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return errRedisNotInt
+		}
+		arr := sortedMembers(z)
+		if cmd == "ZREVRANGE" {
+			for i, j := 0, len(arr)-1; i < j; i, j = i+1, j-1 {
+				arr[i], arr[j] = arr[j], arr[i]
+			}
+		}
+		if start < 0 {
+			start += len(arr)
+		}
+		if stop < 0 {
+			stop += len(arr)
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= len(arr) {
+			stop = len(arr) - 1
+		}
+		result := make([]interface{}, 0)
+		for i := start; i <= stop; i++ {
+			result = append(result, arr[i].member)
+			if withScores {
+				result = append(result, formatScore(arr[i].score))
+			}
+		}
+		return result
+	}
+	return errRedisSyntax
+}
+
+// readRedisCommand 读取一条命令,支持RESP数组及空格分隔的内联命令
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRedisLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = readRedisLine(r); err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errors.New("redistest: invalid bulk string")
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readRedisLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeRedisReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case Status:
+		w.WriteString("+" + string(v) + "\r\n")
+	case Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []byte:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n")
+		w.Write(v)
+		w.WriteString("\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeRedisReply(w, item)
+		}
+	case nil:
+		w.WriteString("$-1\r\n")
+	default:
+		w.WriteString("-ERR unsupported reply type\r\n")
+	}
+}
@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrNil 空回复
+var ErrNil = errors.New("redis: nil reply")
+
+// Error 服务器返回的错误
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// String 回复转换为string
+func String(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case []byte:
+		return string(v), nil
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case nil:
+		return "", ErrNil
+	default:
+		return "", fmt.Errorf("redis: unexpected type %T for string", reply)
+	}
+}
+
+// Bytes 回复转换为[]byte
+func Bytes(reply interface{}, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch v := reply.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case nil:
+		return nil, ErrNil
+	default:
+		return nil, fmt.Errorf("redis: unexpected type %T for bytes", reply)
+	}
+}
+
+// Int64 回复转换为int64
+func Int64(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case nil:
+		return 0, ErrNil
+	default:
+		return 0, fmt.Errorf("redis: unexpected type %T for int64", reply)
+	}
+}
+
+// Float64 回复转换为float64,用于有序集合分数
+func Float64(reply interface{}, err error) (float64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	case int64:
+		return float64(v), nil
+	case nil:
+		return 0, ErrNil
+	default:
+		return 0, fmt.Errorf("redis: unexpected type %T for float64", reply)
+	}
+}
+
+// Strings 数组回复转换为[]string,空元素为""
+func Strings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := reply.([]interface{})
+	if !ok {
+		if reply == nil {
+			return nil, ErrNil
+		}
+		return nil, fmt.Errorf("redis: unexpected type %T for strings", reply)
+	}
+	result := make([]string, len(arr))
+	for i, v := range arr {
+		if v == nil {
+			continue
+		}
+		if result[i], err = String(v, nil); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// StringMap 键值交替的数组回复(如HGETALL)转换为map
+func StringMap(reply interface{}, err error) (map[string]string, error) {
+	arr, err := Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(arr)%2 != 0 {
+		return nil, errors.New("redis: odd number of elements for map")
+	}
+	result := make(map[string]string, len(arr)/2)
+	for i := 0; i < len(arr); i += 2 {
+		result[arr[i]] = arr[i+1]
+	}
+	return result, nil
+}
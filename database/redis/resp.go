@@ -0,0 +1,116 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var errProtocol = errors.New("redis: protocol error")
+
+// writeCommand 按RESP数组编码命令
+func writeCommand(w *bufio.Writer, args []interface{}) error {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(len(args)))
+	w.WriteString("\r\n")
+	for _, arg := range args {
+		var buf []byte
+		switch v := arg.(type) {
+		case string:
+			buf = []byte(v)
+		case []byte:
+			buf = v
+		case int:
+			buf = strconv.AppendInt(nil, int64(v), 10)
+		case int32:
+			buf = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			buf = strconv.AppendInt(nil, v, 10)
+		case uint32:
+			buf = strconv.AppendUint(nil, uint64(v), 10)
+		case uint64:
+			buf = strconv.AppendUint(nil, v, 10)
+		case float64:
+			buf = strconv.AppendFloat(nil, v, 'g', -1, 64)
+		case bool:
+			if v {
+				buf = []byte{'1'}
+			} else {
+				buf = []byte{'0'}
+			}
+		case nil:
+		default:
+			buf = []byte(fmt.Sprint(v))
+		}
+		w.WriteByte('$')
+		w.WriteString(strconv.Itoa(len(buf)))
+		w.WriteString("\r\n")
+		w.Write(buf)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply 读取一个回复: 状态为string, 错误为Error, 整数为int64, 字符串为[]byte, 数组为[]interface{}, 空值为nil
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	default:
+		return nil, errProtocol
+	}
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+
+	logger "github.com/panlibin/vglog"
+)
+
+type forcePrimaryKey struct{}
+
+// WithPrimary 标记读操作走主库,用于写后立即读
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+func isForcePrimary(ctx context.Context) bool {
+	force, _ := ctx.Value(forcePrimaryKey{}).(bool)
+	return force
+}
+
+type dbReplica struct {
+	dsn     string
+	db      *sql.DB
+	healthy int32
+}
+
+// replicaSet 只读从库,keepAlive时ping失败的从库摘除,恢复后重新加入
+type replicaSet struct {
+	replicas []*dbReplica
+	next     uint32
+}
+
+func openReplicaSet(driverName string, dsns []string, instNum int32) (*replicaSet, error) {
+	rs := &replicaSet{
+		replicas: make([]*dbReplica, 0, len(dsns)),
+	}
+	for _, dsn := range dsns {
+		db, err := sql.Open(driverName, dsn)
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+		db.SetMaxOpenConns(int(instNum))
+		db.SetMaxIdleConns(int(instNum))
+		r := &dbReplica{dsn: dsn, db: db}
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		err = db.PingContext(ctx)
+		cancel()
+		if err != nil {
+			logger.Errorf("replica %s unavailable: %v", maskDSN(dsn), err)
+		} else {
+			r.healthy = 1
+		}
+		rs.replicas = append(rs.replicas, r)
+	}
+	return rs, nil
+}
+
+// pick 轮询选择健康的从库,全部不可用时返回nil
+func (rs *replicaSet) pick() *sql.DB {
+	n := uint32(len(rs.replicas))
+	for i := uint32(0); i < n; i++ {
+		r := rs.replicas[atomic.AddUint32(&rs.next, 1)%n]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			return r.db
+		}
+	}
+	return nil
+}
+
+// ping 逐个检查从库,每个从库的超时为healthCheckTimeout
+func (rs *replicaSet) ping(parent context.Context) {
+	for _, r := range rs.replicas {
+		ctx, cancel := context.WithTimeout(parent, healthCheckTimeout)
+		err := r.db.PingContext(ctx)
+		cancel()
+		if parent.Err() != nil {
+			return
+		}
+		if err != nil {
+			if atomic.CompareAndSwapInt32(&r.healthy, 1, 0) {
+				logger.Errorf("replica %s removed: %v", maskDSN(r.dsn), err)
+			}
+		} else if atomic.CompareAndSwapInt32(&r.healthy, 0, 1) {
+			logger.Infof("replica %s recovered", maskDSN(r.dsn))
+		}
+	}
+}
+
+func (rs *replicaSet) close() {
+	for _, r := range rs.replicas {
+		r.db.Close()
+	}
+}
+
+// reader 读操作使用的连接,优先健康的从库
+func (m *dbInstance) reader(ctx context.Context) *sql.DB {
+	if m.replicas != nil && !isForcePrimary(ctx) {
+		if db := m.replicas.pick(); db != nil {
+			return db
+		}
+	}
+	return m.db
+}
+
+// readerFor 读操作使用的连接,记录是否由从库执行
+func (m *dbInstance) readerFor(ctx context.Context, op *Operation) *sql.DB {
+	db := m.reader(ctx)
+	if op.queryCtx != nil {
+		op.queryCtx.replica = db != m.db
+	}
+	return db
+}
@@ -0,0 +1,101 @@
+package database_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/database/dbtest"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+func TestReplicaRouting(t *testing.T) {
+	r1 := dbtest.NewRecorder()
+	r2 := dbtest.NewRecorder()
+	r2.SetPingError(errors.New("down"))
+	db, rec := openTestDB(t, virgotest.NewProcedure(), 1, func(db *database.DB) {
+		db.SetReplicas(r1.DSN(), r2.DSN())
+		db.SetHealthCheck(time.Millisecond * 10)
+	})
+	defer db.Close()
+
+	// 读走健康的从库,写走主库
+	for i := 0; i < 4; i++ {
+		if _, err := db.QueryAll(0, nil, "SELECT a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(0, "UPDATE a"); err != nil {
+		t.Fatal(err)
+	}
+	if n1, n2, n := len(r1.Statements()), len(r2.Statements()), len(rec.Statements()); n1 != 4 || n2 != 0 || n != 1 {
+		t.Fatalf("replica1 %d, replica2 %d, primary %d", n1, n2, n)
+	}
+
+	// WithPrimary的读走主库
+	rec.Reset()
+	if _, err := db.QueryAllContext(database.WithPrimary(context.Background()), 0, nil, "SELECT b"); err != nil {
+		t.Fatal(err)
+	}
+	if stmts := rec.Statements(); len(stmts) != 1 || stmts[0].Query != "SELECT b" {
+		t.Fatalf("primary statements %v", stmts)
+	}
+
+	// 从库全部不可用时回退到主库,恢复后重新走从库
+	r1.SetPingError(errors.New("down"))
+	waitRouting(t, db, rec, "SELECT c")
+	r2.SetPingError(nil)
+	waitRouting(t, db, r2, "SELECT d")
+}
+
+// waitRouting 等待健康检查生效,直到读操作到达want
+func waitRouting(t *testing.T, db *database.DB, want *dbtest.Recorder, query string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		want.Reset()
+		if _, err := db.QueryAll(0, nil, query); err != nil {
+			t.Fatal(err)
+		}
+		if len(want.Statements()) == 1 {
+			return
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	t.Fatalf("%s not routed", query)
+}
+
+func TestReplicaBreaker(t *testing.T) {
+	r1 := dbtest.NewRecorder()
+	db, rec := openTestDB(t, virgotest.NewProcedure(), 1, func(db *database.DB) {
+		db.SetReplicas(r1.DSN())
+		db.SetCircuitBreaker(2, time.Minute)
+	})
+	defer db.Close()
+
+	// 从库的连接错误不使主库熔断
+	r1.Expect("SELECT a").WillReturnError(driver.ErrBadConn)
+	for i := 0; i < 4; i++ {
+		if _, err := db.QueryAll(0, nil, "SELECT a"); err == nil {
+			t.Fatal("expected replica error")
+		}
+	}
+	if state := db.CircuitState(); state != database.CircuitClosed {
+		t.Fatalf("state %v after replica errors", state)
+	}
+	if _, err := db.Exec(0, "UPDATE a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 主库的连接错误仍然熔断
+	rec.Expect("UPDATE b").WillReturnError(driver.ErrBadConn)
+	for i := 0; i < 2; i++ {
+		db.Exec(0, "UPDATE b")
+	}
+	if _, err := db.Exec(0, "UPDATE a"); err != database.ErrCircuitOpen {
+		t.Fatalf("primary error %v", err)
+	}
+}
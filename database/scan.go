@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// RowScanner 行扫描函数,在数据库协程内对结果集的每一行调用
+type RowScanner func(rows *sql.Rows) (interface{}, error)
+
+// ScanMap 将一行扫描为 列名->值, []byte类型的值转换为string
+func ScanMap(rows *sql.Rows) (interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err = rows.Scan(pointers...); err != nil {
+		return nil, err
+	}
+
+	record := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if buf, ok := values[i].([]byte); ok {
+			record[column] = string(buf)
+		} else {
+			record[column] = values[i]
+		}
+	}
+	return record, nil
+}
+
+// scanAll 读取全部行并关闭结果集
+func scanAll(rows *sql.Rows, scanner RowScanner) ([]interface{}, error) {
+	defer rows.Close()
+
+	if scanner == nil {
+		scanner = ScanMap
+	}
+
+	result := make([]interface{}, 0, 8)
+	for rows.Next() {
+		item, err := scanner(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,356 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	logger "github.com/panlibin/vglog"
+	"github.com/panlibin/virgo"
+)
+
+const defaultVirtualNodes = 160
+
+// ErrShardNum 分片数量无效
+var ErrShardNum = errors.New("database: shard number must be positive")
+
+// IShardStrategy 分片策略,分片键映射到分片序号
+type IShardStrategy interface {
+	Shard(key uint64) int
+	ShardNum() int
+}
+
+// ModuloStrategy 取模分片
+type ModuloStrategy struct {
+	shardNum uint64
+}
+
+// NewModuloStrategy 新建,shardNum需大于0
+func NewModuloStrategy(shardNum int) (*ModuloStrategy, error) {
+	if shardNum <= 0 {
+		return nil, ErrShardNum
+	}
+	return &ModuloStrategy{shardNum: uint64(shardNum)}, nil
+}
+
+// Shard 分片序号
+func (s *ModuloStrategy) Shard(key uint64) int {
+	return int(key % s.shardNum)
+}
+
+// ShardNum 分片数量
+func (s *ModuloStrategy) ShardNum() int {
+	return int(s.shardNum)
+}
+
+// RangeStrategy 范围分片,第i个分片负责 [upperBounds[i-1], upperBounds[i]),超出最大上界的键落在最后一个分片
+type RangeStrategy struct {
+	upperBounds []uint64
+}
+
+// NewRangeStrategy 新建,upperBounds为各分片的上界(不含),不能为空且需严格递增
+func NewRangeStrategy(upperBounds ...uint64) (*RangeStrategy, error) {
+	if len(upperBounds) == 0 {
+		return nil, ErrShardNum
+	}
+	for i := 1; i < len(upperBounds); i++ {
+		if upperBounds[i] <= upperBounds[i-1] {
+			return nil, fmt.Errorf("database: range upper bound %d not increasing", upperBounds[i])
+		}
+	}
+	return &RangeStrategy{upperBounds: upperBounds}, nil
+}
+
+// Shard 分片序号
+func (s *RangeStrategy) Shard(key uint64) int {
+	idx := sort.Search(len(s.upperBounds), func(i int) bool {
+		return key < s.upperBounds[i]
+	})
+	if idx >= len(s.upperBounds) {
+		idx = len(s.upperBounds) - 1
+	}
+	return idx
+}
+
+// ShardNum 分片数量
+func (s *RangeStrategy) ShardNum() int {
+	return len(s.upperBounds)
+}
+
+// ConsistentHashStrategy 一致性哈希分片
+type ConsistentHashStrategy struct {
+	shardNum int
+	ring     []uint32
+	owners   map[uint32]int
+}
+
+// NewConsistentHashStrategy 新建,shardNum需大于0,virtualNodes为每个分片的虚拟节点数
+func NewConsistentHashStrategy(shardNum int, virtualNodes int) (*ConsistentHashStrategy, error) {
+	if shardNum <= 0 {
+		return nil, ErrShardNum
+	}
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	s := &ConsistentHashStrategy{
+		shardNum: shardNum,
+		ring:     make([]uint32, 0, shardNum*virtualNodes),
+		owners:   make(map[uint32]int, shardNum*virtualNodes),
+	}
+	for shard := 0; shard < shardNum; shard++ {
+		for i := 0; i < virtualNodes; i++ {
+			h := fnv.New32a()
+			h.Write([]byte(strconv.Itoa(shard) + "#" + strconv.Itoa(i)))
+			sum := h.Sum32()
+			if _, exist := s.owners[sum]; exist {
+				continue
+			}
+			s.owners[sum] = shard
+			s.ring = append(s.ring, sum)
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool {
+		return s.ring[i] < s.ring[j]
+	})
+	return s, nil
+}
+
+// Shard 分片序号
+func (s *ConsistentHashStrategy) Shard(key uint64) int {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], key)
+	h := fnv.New32a()
+	h.Write(buf[:])
+	sum := h.Sum32()
+	idx := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i] >= sum
+	})
+	if idx >= len(s.ring) {
+		idx = 0
+	}
+	return s.owners[s.ring[idx]]
+}
+
+// ShardNum 分片数量
+func (s *ConsistentHashStrategy) ShardNum() int {
+	return s.shardNum
+}
+
+// ShardedMysql 分库路由,每个DSN对应一个Mysql,按分片键路由到物理库,
+// 分片键同时决定库内的实例队列,同一分片键的操作保持顺序
+type ShardedMysql struct {
+	p        virgo.IProcedure
+	strategy IShardStrategy
+	shards   []IDatabase
+	opened   []*Mysql
+	setup    func(*Mysql)
+}
+
+// NewShardedMysql 新建
+func NewShardedMysql(p virgo.IProcedure, strategy IShardStrategy) *ShardedMysql {
+	return &ShardedMysql{
+		p:        p,
+		strategy: strategy,
+	}
+}
+
+// SetShardSetup 设置每个分片Open前的配置函数
+func (s *ShardedMysql) SetShardSetup(f func(*Mysql)) {
+	s.setup = f
+}
+
+// Open 连接所有分片,DSN数量需与分片策略的分片数量一致,任一失败时关闭已连接的分片
+func (s *ShardedMysql) Open(dsns []string, instNum int32) error {
+	if len(dsns) == 0 {
+		return errors.New("database: no shard dsn")
+	}
+	if n := s.strategy.ShardNum(); n != len(dsns) {
+		return fmt.Errorf("database: strategy has %d shards but %d dsns given", n, len(dsns))
+	}
+	s.opened = make([]*Mysql, 0, len(dsns))
+	s.shards = make([]IDatabase, 0, len(dsns))
+	for i, dsn := range dsns {
+		m := NewMysql(s.p)
+		if s.setup != nil {
+			s.setup(m)
+		}
+		if err := m.Open(dsn, instNum); err != nil {
+			s.Close()
+			return fmt.Errorf("database: open shard %d: %v", i, err)
+		}
+		s.opened = append(s.opened, m)
+		s.shards = append(s.shards, m)
+	}
+	return nil
+}
+
+// Attach 使用已打开的数据库作为分片,用于包装后的数据库,数量需与分片策略的分片数量一致.
+// Close不关闭这些数据库
+func (s *ShardedMysql) Attach(shards ...IDatabase) error {
+	if n := s.strategy.ShardNum(); n != len(shards) {
+		return fmt.Errorf("database: strategy has %d shards but %d databases given", n, len(shards))
+	}
+	s.shards = shards
+	return nil
+}
+
+// Close 关闭Open连接的分片
+func (s *ShardedMysql) Close() {
+	for _, m := range s.opened {
+		m.Close()
+	}
+	s.opened = nil
+	s.shards = nil
+}
+
+// Shards 所有分片
+func (s *ShardedMysql) Shards() []IDatabase {
+	return s.shards
+}
+
+// Shard 分片键对应的分片
+func (s *ShardedMysql) Shard(key uint64) IDatabase {
+	idx := s.strategy.Shard(key)
+	if idx < 0 || idx >= len(s.shards) {
+		logger.Errorf("shard index %d out of range, key %d", idx, key)
+		idx = int(key % uint64(len(s.shards)))
+	}
+	return s.shards[idx]
+}
+
+// Migrate 在所有分片上执行未执行的结构迁移,分片需实现Migrate(*Migrator) error
+func (s *ShardedMysql) Migrate(mg *Migrator) error {
+	for i, shard := range s.shards {
+		m, ok := shard.(interface {
+			Migrate(*Migrator) error
+		})
+		if !ok {
+			return fmt.Errorf("database: shard %d %T does not support migrate", i, shard)
+		}
+		if err := m.Migrate(mg); err != nil {
+			return fmt.Errorf("database: migrate shard %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Query 查询多行
+func (s *ShardedMysql) Query(key uint64, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.Shard(key).Query(shardDbIdx(key), query, args...)
+}
+
+// QueryContext 查询多行
+func (s *ShardedMysql) QueryContext(ctx context.Context, key uint64, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.Shard(key).QueryContext(ctx, shardDbIdx(key), query, args...)
+}
+
+// QueryRow 查询一行
+func (s *ShardedMysql) QueryRow(key uint64, query string, args ...interface{}) *sql.Row {
+	return s.Shard(key).QueryRow(shardDbIdx(key), query, args...)
+}
+
+// QueryRowContext 查询一行
+func (s *ShardedMysql) QueryRowContext(ctx context.Context, key uint64, query string, args ...interface{}) *sql.Row {
+	return s.Shard(key).QueryRowContext(ctx, shardDbIdx(key), query, args...)
+}
+
+// Exec 执行
+func (s *ShardedMysql) Exec(key uint64, query string, args ...interface{}) (sql.Result, error) {
+	return s.Shard(key).Exec(shardDbIdx(key), query, args...)
+}
+
+// ExecContext 执行
+func (s *ShardedMysql) ExecContext(ctx context.Context, key uint64, query string, args ...interface{}) (sql.Result, error) {
+	return s.Shard(key).ExecContext(ctx, shardDbIdx(key), query, args...)
+}
+
+// QueryAll 查询多行并读取全部行
+func (s *ShardedMysql) QueryAll(key uint64, scanner RowScanner, query string, args ...interface{}) ([]interface{}, error) {
+	return s.Shard(key).QueryAll(shardDbIdx(key), scanner, query, args...)
+}
+
+// QueryAllContext 查询多行并读取全部行
+func (s *ShardedMysql) QueryAllContext(ctx context.Context, key uint64, scanner RowScanner, query string, args ...interface{}) ([]interface{}, error) {
+	return s.Shard(key).QueryAllContext(ctx, shardDbIdx(key), scanner, query, args...)
+}
+
+// Transaction 执行事务
+func (s *ShardedMysql) Transaction(key uint64, f func(*sql.Tx) error) error {
+	return s.Shard(key).Transaction(shardDbIdx(key), f)
+}
+
+// TransactionContext 执行事务
+func (s *ShardedMysql) TransactionContext(ctx context.Context, key uint64, f func(*sql.Tx) error) error {
+	return s.Shard(key).TransactionContext(ctx, shardDbIdx(key), f)
+}
+
+// AsyncQuery 查询多行,回调
+func (s *ShardedMysql) AsyncQuery(ctx interface{}, cb func([]interface{}), key uint64, query string, args ...interface{}) {
+	s.Shard(key).AsyncQuery(ctx, cb, shardDbIdx(key), query, args...)
+}
+
+// AsyncQueryContext 查询多行,回调
+func (s *ShardedMysql) AsyncQueryContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), key uint64, query string, args ...interface{}) {
+	s.Shard(key).AsyncQueryContext(execCtx, ctx, cb, shardDbIdx(key), query, args...)
+}
+
+// AsyncQueryRow 查询一行,回调
+func (s *ShardedMysql) AsyncQueryRow(ctx interface{}, cb func([]interface{}), key uint64, query string, args ...interface{}) {
+	s.Shard(key).AsyncQueryRow(ctx, cb, shardDbIdx(key), query, args...)
+}
+
+// AsyncQueryRowContext 查询一行,回调
+func (s *ShardedMysql) AsyncQueryRowContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), key uint64, query string, args ...interface{}) {
+	s.Shard(key).AsyncQueryRowContext(execCtx, ctx, cb, shardDbIdx(key), query, args...)
+}
+
+// AsyncExec 执行,回调
+func (s *ShardedMysql) AsyncExec(ctx interface{}, cb func([]interface{}), key uint64, query string, args ...interface{}) {
+	s.Shard(key).AsyncExec(ctx, cb, shardDbIdx(key), query, args...)
+}
+
+// AsyncExecContext 执行,回调
+func (s *ShardedMysql) AsyncExecContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), key uint64, query string, args ...interface{}) {
+	s.Shard(key).AsyncExecContext(execCtx, ctx, cb, shardDbIdx(key), query, args...)
+}
+
+// TryAsyncExec 执行,回调,队列满时不阻塞,返回ErrQueueFull
+func (s *ShardedMysql) TryAsyncExec(ctx interface{}, cb func([]interface{}), key uint64, query string, args ...interface{}) error {
+	return s.Shard(key).TryAsyncExec(ctx, cb, shardDbIdx(key), query, args...)
+}
+
+// AsyncQueryAll 查询多行并读取全部行,回调
+func (s *ShardedMysql) AsyncQueryAll(ctx interface{}, cb func([]interface{}), key uint64, scanner RowScanner, query string, args ...interface{}) {
+	s.Shard(key).AsyncQueryAll(ctx, cb, shardDbIdx(key), scanner, query, args...)
+}
+
+// AsyncQueryAllContext 查询多行并读取全部行,回调
+func (s *ShardedMysql) AsyncQueryAllContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), key uint64, scanner RowScanner, query string, args ...interface{}) {
+	s.Shard(key).AsyncQueryAllContext(execCtx, ctx, cb, shardDbIdx(key), scanner, query, args...)
+}
+
+// AsyncTransaction 执行事务,回调
+func (s *ShardedMysql) AsyncTransaction(ctx interface{}, cb func([]interface{}), key uint64, f func(*sql.Tx) error) {
+	s.Shard(key).AsyncTransaction(ctx, cb, shardDbIdx(key), f)
+}
+
+// AsyncTransactionContext 执行事务,回调
+func (s *ShardedMysql) AsyncTransactionContext(execCtx context.Context, ctx interface{}, cb func([]interface{}), key uint64, f func(*sql.Tx) error) {
+	s.Shard(key).AsyncTransactionContext(execCtx, ctx, cb, shardDbIdx(key), f)
+}
+
+// shardDbIdx 分片键打散后作为库内实例序号,
+// 避免与取模等分片策略相关,导致分片数与实例数有公因数时同一分片只用到部分实例
+func shardDbIdx(key uint64) uint32 {
+	key ^= key >> 33
+	key *= 0xff51afd7ed558ccd
+	key ^= key >> 33
+	key *= 0xc4ceb9fe1a85ec53
+	key ^= key >> 33
+	return uint32(key)
+}
@@ -0,0 +1,112 @@
+package database
+
+import "testing"
+
+func TestShardStrategy(t *testing.T) {
+	if _, err := NewModuloStrategy(0); err != ErrShardNum {
+		t.Fatalf("modulo 0 error %v", err)
+	}
+	if _, err := NewConsistentHashStrategy(0, 0); err != ErrShardNum {
+		t.Fatalf("consistent hash 0 error %v", err)
+	}
+	if _, err := NewRangeStrategy(); err != ErrShardNum {
+		t.Fatalf("empty range error %v", err)
+	}
+	if _, err := NewRangeStrategy(100, 100); err == nil {
+		t.Fatal("expected error on non-increasing bounds")
+	}
+
+	modulo, _ := NewModuloStrategy(4)
+	for key, want := range map[uint64]int{0: 0, 5: 1, 11: 3} {
+		if got := modulo.Shard(key); got != want {
+			t.Errorf("modulo shard %d = %d, want %d", key, got, want)
+		}
+	}
+
+	ranges, _ := NewRangeStrategy(100, 1000)
+	for key, want := range map[uint64]int{0: 0, 99: 0, 100: 1, 999: 1, 5000: 1} {
+		if got := ranges.Shard(key); got != want {
+			t.Errorf("range shard %d = %d, want %d", key, got, want)
+		}
+	}
+
+	// 一致性哈希:结果稳定,分布大致均匀,增加分片时多数键不迁移
+	hash4, _ := NewConsistentHashStrategy(4, 0)
+	hash5, _ := NewConsistentHashStrategy(5, 0)
+	counts := make([]int, 4)
+	moved := 0
+	const keyNum = 10000
+	for key := uint64(0); key < keyNum; key++ {
+		shard := hash4.Shard(key)
+		if shard != hash4.Shard(key) {
+			t.Fatalf("unstable shard for key %d", key)
+		}
+		counts[shard]++
+		if hash5.Shard(key) != shard {
+			moved++
+		}
+	}
+	for shard, n := range counts {
+		if n < keyNum/8 {
+			t.Errorf("shard %d got %d keys", shard, n)
+		}
+	}
+	if moved > keyNum/3 {
+		t.Errorf("%d keys moved after adding a shard", moved)
+	}
+}
+
+func TestShardDbIdx(t *testing.T) {
+	// 分片数与实例数相同时,同一分片的键仍分布到所有实例
+	const shardNum, instNum = 4, 4
+	modulo, _ := NewModuloStrategy(shardNum)
+	used := make(map[int]map[uint32]struct{})
+	for key := uint64(0); key < 1000; key++ {
+		shard := modulo.Shard(key)
+		if used[shard] == nil {
+			used[shard] = make(map[uint32]struct{})
+		}
+		used[shard][shardDbIdx(key)%instNum] = struct{}{}
+	}
+	for shard, insts := range used {
+		if len(insts) != instNum {
+			t.Errorf("shard %d uses %d instances", shard, len(insts))
+		}
+	}
+}
+
+func TestShardedOpen(t *testing.T) {
+	modulo, _ := NewModuloStrategy(4)
+	s := NewShardedMysql(nil, modulo)
+	if err := s.Open([]string{"a", "b"}, 1); err == nil {
+		t.Fatal("expected error on shard number mismatch")
+	}
+	if len(s.Shards()) != 0 {
+		t.Fatal("shards opened on mismatch")
+	}
+}
+
+type fakeShard struct {
+	IDatabase
+	id int
+}
+
+func TestShardedAttach(t *testing.T) {
+	modulo, _ := NewModuloStrategy(2)
+	s := NewShardedMysql(nil, modulo)
+	if err := s.Attach(&fakeShard{id: 0}); err == nil {
+		t.Fatal("expected error on shard number mismatch")
+	}
+	if err := s.Attach(&fakeShard{id: 0}, &fakeShard{id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for key := uint64(0); key < 4; key++ {
+		if got := s.Shard(key).(*fakeShard).id; got != int(key%2) {
+			t.Fatalf("key %d routed to shard %d", key, got)
+		}
+	}
+	if err := s.Migrate(&Migrator{}); err == nil {
+		t.Fatal("expected migrate error on shard without Migrate")
+	}
+	s.Close()
+}
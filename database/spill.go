@@ -0,0 +1,220 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	logger "github.com/panlibin/vglog"
+)
+
+// spillItem 积压的操作,queryCtx非空时为无法溢出的操作,保存在内存中
+type spillItem struct {
+	ctx       interface{}
+	cb        func([]interface{})
+	journalID uint64
+	queryCtx  *queryContext
+}
+
+// spillQueue 队列满时异步写操作溢出到磁盘,按顺序回放到队列.
+// 溢出期间该实例的后续操作全部排在积压之后:异步写操作进入溢出文件,
+// 其它操作(同步操作、查询、事务及无法编码的写操作)按顺序保存在内存中,保证同一实例的操作顺序;
+// 回调仍保存在内存,进程退出后不可恢复
+type spillQueue struct {
+	inst    *dbInstance
+	path    string
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	file    *os.File
+	writer  *bufio.Writer
+	reader  *bufio.Reader
+	items   []spillItem
+	closing bool
+	done    chan struct{}
+}
+
+func openSpillQueue(inst *dbInstance, path string) (*spillQueue, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	sq := &spillQueue{
+		inst: inst,
+		path: path,
+		file: file,
+		done: make(chan struct{}),
+	}
+	sq.cond = sync.NewCond(&sq.mtx)
+	sq.reset()
+	go sq.drain()
+	return sq, nil
+}
+
+// add 没有积压且队列未满时直接入队;有积压时排在积压之后;
+// 没有积压且队列已满时异步写操作写入溢出文件,其它操作返回false,由调用方阻塞入队
+func (sq *spillQueue) add(queryCtx *queryContext) bool {
+	sq.mtx.Lock()
+	defer sq.mtx.Unlock()
+
+	if len(sq.items) == 0 {
+		select {
+		case sq.inst.queryChan <- queryCtx:
+			return true
+		default:
+		}
+		if sq.closing || !queryCtx.async || queryCtx.queryType != queryTypeExec {
+			return false
+		}
+		logger.Warningf("database queue %d full, spill to %s", sq.inst.idx, sq.path)
+	}
+
+	if queryCtx.async && queryCtx.queryType == queryTypeExec {
+		err := sq.write(queryCtx)
+		if err == nil {
+			sq.items = append(sq.items, spillItem{ctx: queryCtx.ctx, cb: queryCtx.cb, journalID: queryCtx.journalID})
+			sq.cond.Signal()
+			return true
+		}
+		logger.Errorf("spill operation error: %v", err)
+	}
+	sq.items = append(sq.items, spillItem{queryCtx: queryCtx})
+	sq.cond.Signal()
+	return true
+}
+
+// write 写入溢出文件,调用时持有锁
+func (sq *spillQueue) write(queryCtx *queryContext) error {
+	buf, err := encodeOp(&opRecord{dbIdx: sq.inst.idx, query: queryCtx.query, args: queryCtx.args})
+	if err != nil {
+		return err
+	}
+	var head [4]byte
+	binary.LittleEndian.PutUint32(head[:], uint32(len(buf)))
+	if _, err = sq.writer.Write(head[:]); err == nil {
+		_, err = sq.writer.Write(buf)
+	}
+	return err
+}
+
+// pending 等待回放的操作数
+func (sq *spillQueue) pending() int {
+	sq.mtx.Lock()
+	defer sq.mtx.Unlock()
+	return len(sq.items)
+}
+
+func (sq *spillQueue) drain() {
+	defer close(sq.done)
+	for {
+		sq.mtx.Lock()
+		for len(sq.items) == 0 && !sq.closing {
+			sq.cond.Wait()
+		}
+		if len(sq.items) == 0 {
+			sq.mtx.Unlock()
+			return
+		}
+		item := sq.items[0]
+		var op *opRecord
+		var err error
+		if item.queryCtx == nil {
+			op, err = sq.next()
+		}
+		sq.mtx.Unlock()
+
+		if item.queryCtx != nil {
+			sq.inst.queryChan <- item.queryCtx
+		} else if err == nil {
+			sq.inst.queryChan <- &queryContext{
+				execCtx:   context.Background(),
+				query:     op.query,
+				args:      op.args,
+				queryType: queryTypeExec,
+				async:     true,
+				ctx:       item.ctx,
+				cb:        item.cb,
+				journalID: item.journalID,
+			}
+		} else {
+			logger.Errorf("read spilled operation error: %v", err)
+			if item.cb != nil {
+				sq.inst.p.SyncTask(item.cb, item.ctx, nil, err)
+			}
+		}
+
+		sq.mtx.Lock()
+		sq.items = sq.items[1:]
+		if len(sq.items) == 0 {
+			sq.reset()
+			logger.Infof("database queue %d spill drained", sq.inst.idx)
+		}
+		sq.mtx.Unlock()
+	}
+}
+
+// next 读取下一条溢出记录,调用时持有锁
+func (sq *spillQueue) next() (*opRecord, error) {
+	if err := sq.writer.Flush(); err != nil {
+		return nil, err
+	}
+	var head [4]byte
+	if _, err := io.ReadFull(sq.reader, head[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.LittleEndian.Uint32(head[:]))
+	if _, err := io.ReadFull(sq.reader, buf); err != nil {
+		return nil, err
+	}
+	return decodeOp(buf)
+}
+
+// reset 积压清空后截断文件,调用时持有锁
+func (sq *spillQueue) reset() {
+	sq.file.Truncate(0)
+	sq.file.Seek(0, io.SeekStart)
+	sq.writer = bufio.NewWriter(&fileAppender{file: sq.file})
+	sq.reader = bufio.NewReader(&fileCursor{file: sq.file})
+	sq.items = nil
+}
+
+// close 等待积压回放完成
+func (sq *spillQueue) close() {
+	sq.mtx.Lock()
+	sq.closing = true
+	sq.cond.Signal()
+	sq.mtx.Unlock()
+	<-sq.done
+	sq.file.Close()
+	os.Remove(sq.path)
+}
+
+// fileAppender 追加写入,与读取位置互不影响
+type fileAppender struct {
+	file *os.File
+}
+
+func (f *fileAppender) Write(p []byte) (int, error) {
+	off, err := f.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	return f.file.WriteAt(p, off)
+}
+
+// fileCursor 从头顺序读取
+type fileCursor struct {
+	file *os.File
+	off  int64
+}
+
+func (f *fileCursor) Read(p []byte) (int, error) {
+	n, err := f.file.ReadAt(p, f.off)
+	f.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,45 @@
+package database_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+func TestSpillOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 1, func(db *database.DB) {
+		db.SetQueueSize(1)
+		db.SetSpillDir(dir)
+	})
+	defer db.Close()
+	rec.Expect("UPDATE slow").WillDelay(time.Millisecond * 100)
+
+	// 实例阻塞在慢语句上,第二条写入占满队列,第三条溢出到磁盘
+	db.AsyncExec(nil, nil, 0, "UPDATE slow SET a=1")
+	time.Sleep(time.Millisecond * 20)
+	db.AsyncExec(nil, nil, 0, "INSERT INTO t (a) VALUES (?)", 1)
+	db.AsyncExec(nil, nil, 0, "INSERT INTO t (a) VALUES (?)", 2)
+	if depth := db.QueueDepth()[0]; depth != 2 {
+		t.Fatalf("queue depth %d", depth)
+	}
+
+	// 同步查询排在溢出的写入之后
+	if _, err = db.QueryAll(0, nil, "SELECT a FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	stmts := rec.Statements()
+	if len(stmts) != 4 || stmts[2].Args[0] != int64(2) || stmts[3].Query != "SELECT a FROM t" {
+		t.Fatalf("statements %v", stmts)
+	}
+}
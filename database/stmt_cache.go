@@ -0,0 +1,199 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	logger "github.com/panlibin/vglog"
+)
+
+// stmtKey 预编译语句属于具体的连接池,主库和从库分别缓存
+type stmtKey struct {
+	db    *sql.DB
+	query string
+}
+
+type stmtEntry struct {
+	key  stmtKey
+	stmt *sql.Stmt
+}
+
+// stmtCache 预编译语句缓存,仅在所属dbInstance协程内使用.
+// 通过Prepare预编译的热点语句常驻,其余按LRU淘汰
+type stmtCache struct {
+	size   int
+	lru    *list.List
+	items  map[stmtKey]*list.Element
+	hot    map[string]struct{}
+	pinned map[stmtKey]*sql.Stmt
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{
+		size:   size,
+		lru:    list.New(),
+		items:  make(map[stmtKey]*list.Element, size),
+		hot:    make(map[string]struct{}),
+		pinned: make(map[stmtKey]*sql.Stmt),
+	}
+}
+
+func (c *stmtCache) get(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	key := stmtKey{db: db, query: query}
+	if stmt, exist := c.pinned[key]; exist {
+		return stmt, nil
+	}
+	if elem, exist := c.items[key]; exist {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*stmtEntry).stmt, nil
+	}
+	if _, exist := c.hot[query]; exist {
+		return c.prepareHot(ctx, key)
+	}
+	if c.size <= 0 {
+		return nil, nil
+	}
+
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.items[key] = c.lru.PushFront(&stmtEntry{key: key, stmt: stmt})
+	for c.lru.Len() > c.size {
+		elem := c.lru.Back()
+		entry := c.lru.Remove(elem).(*stmtEntry)
+		delete(c.items, entry.key)
+		entry.stmt.Close()
+	}
+	return stmt, nil
+}
+
+// pin 标记为热点语句并在db上预编译,热点语句在其他连接池上首次使用时预编译
+func (c *stmtCache) pin(ctx context.Context, db *sql.DB, query string) error {
+	c.hot[query] = struct{}{}
+	key := stmtKey{db: db, query: query}
+	if _, exist := c.pinned[key]; exist {
+		return nil
+	}
+	if elem, exist := c.items[key]; exist {
+		entry := c.lru.Remove(elem).(*stmtEntry)
+		delete(c.items, key)
+		c.pinned[key] = entry.stmt
+		return nil
+	}
+	_, err := c.prepareHot(ctx, key)
+	return err
+}
+
+func (c *stmtCache) prepareHot(ctx context.Context, key stmtKey) (*sql.Stmt, error) {
+	stmt, err := key.db.PrepareContext(ctx, key.query)
+	if err != nil {
+		return nil, err
+	}
+	c.pinned[key] = stmt
+	return stmt, nil
+}
+
+// reprepare 连接断开后丢弃旧语句重新预编译
+func (c *stmtCache) reprepare(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	key := stmtKey{db: db, query: query}
+	if stmt, exist := c.pinned[key]; exist {
+		stmt.Close()
+		delete(c.pinned, key)
+	}
+	if elem, exist := c.items[key]; exist {
+		entry := c.lru.Remove(elem).(*stmtEntry)
+		delete(c.items, key)
+		entry.stmt.Close()
+	}
+	return c.get(ctx, db, query)
+}
+
+func (c *stmtCache) close() {
+	for _, stmt := range c.pinned {
+		stmt.Close()
+	}
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*stmtEntry).stmt.Close()
+	}
+	c.pinned = make(map[stmtKey]*sql.Stmt)
+	c.items = make(map[stmtKey]*list.Element)
+	c.lru.Init()
+}
+
+// isConnError 连接类错误,包括连接失效和网络错误
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isConnError 通用连接错误及方言特有的连接错误
+func (c *dbConfig) isConnError(err error) bool {
+	return isConnError(err) || c.dialect.IsConnError(err)
+}
+
+// stmt 获取缓存的预编译语句,未开启缓存时返回nil
+func (m *dbInstance) stmt(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	if m.stmts == nil {
+		return nil, nil
+	}
+	return m.stmts.get(ctx, db, query)
+}
+
+func (m *dbInstance) doQuery(ctx context.Context, db *sql.DB, query string, args []interface{}) (*sql.Rows, error) {
+	stmt, err := m.stmt(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return db.QueryContext(ctx, query, args...)
+	}
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil && m.cfg.isConnError(err) {
+		logger.Warningf("reprepare statement: %v", err)
+		if stmt, err = m.stmts.reprepare(ctx, db, query); err != nil {
+			return nil, err
+		}
+		rows, err = stmt.QueryContext(ctx, args...)
+	}
+	return rows, err
+}
+
+func (m *dbInstance) doQueryRow(ctx context.Context, db *sql.DB, query string, args []interface{}) *sql.Row {
+	stmt, err := m.stmt(ctx, db, query)
+	if err != nil || stmt == nil {
+		return db.QueryRowContext(ctx, query, args...)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+// doExec 仅在语句确定未发送时(driver.ErrBadConn)重试,避免重复执行
+func (m *dbInstance) doExec(ctx context.Context, db *sql.DB, query string, args []interface{}) (sql.Result, error) {
+	stmt, err := m.stmt(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return db.ExecContext(ctx, query, args...)
+	}
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil && m.cfg.isConnError(err) {
+		logger.Warningf("reprepare statement: %v", err)
+		retry := errors.Is(err, driver.ErrBadConn)
+		newStmt, prepErr := m.stmts.reprepare(ctx, db, query)
+		if prepErr != nil {
+			return nil, prepErr
+		}
+		if retry {
+			res, err = newStmt.ExecContext(ctx, args...)
+		}
+	}
+	return res, err
+}
@@ -0,0 +1,126 @@
+package database_test
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+func TestStmtCacheEvict(t *testing.T) {
+	db, rec := openTestDB(t, virgotest.NewProcedure(), 1, func(db *database.DB) {
+		db.SetStmtCacheSize(2)
+	})
+
+	// 超过容量时关闭最久未使用的语句
+	for _, query := range []string{"UPDATE a", "UPDATE b", "UPDATE a", "UPDATE c", "UPDATE a", "UPDATE b"} {
+		if _, err := db.Exec(0, query); err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+	if got := rec.Prepared(); !reflect.DeepEqual(got, []string{"UPDATE a", "UPDATE b", "UPDATE c", "UPDATE b"}) {
+		t.Fatalf("prepared %v", got)
+	}
+	n := rec.OpenStmts()
+	db.Close()
+	if n != 2 {
+		t.Fatalf("open statements %d", n)
+	}
+	if n = rec.OpenStmts(); n != 0 {
+		t.Fatalf("open statements after close %d", n)
+	}
+}
+
+func TestStmtCachePin(t *testing.T) {
+	db, rec := openTestDB(t, virgotest.NewProcedure(), 2, func(db *database.DB) {
+		db.SetStmtCacheSize(1)
+	})
+	defer db.Close()
+	rec.Expect("SELECT a").WillReturnRows([]string{"id"}, []interface{}{1}, []interface{}{2})
+
+	// Prepare的语句不参与淘汰
+	if err := db.Prepare("SELECT hot"); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"SELECT hot", "UPDATE b", "UPDATE c", "SELECT hot"} {
+		if _, err := db.Exec(0, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 每个实例各自预编译
+	if got := rec.Prepared(); !reflect.DeepEqual(got, []string{"SELECT hot", "SELECT hot", "UPDATE b", "UPDATE c"}) {
+		t.Fatalf("prepared %v", got)
+	}
+
+	// 淘汰时结果集仍在读取,语句在结果集关闭后才关闭,结果集占用一个连接
+	rows, err := db.Query(0, "SELECT a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(0, "UPDATE d"); err != nil {
+		t.Fatal(err)
+	}
+	if n := rec.OpenStmts(); n != 4 {
+		t.Fatalf("open statements while rows in use %d", n)
+	}
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if err = rows.Err(); err != nil || count != 2 {
+		t.Fatalf("rows %d, err %v", count, err)
+	}
+	rows.Close()
+	if n := rec.OpenStmts(); n != 3 {
+		t.Fatalf("open statements after rows closed %d", n)
+	}
+}
+
+func TestStmtCacheReprepare(t *testing.T) {
+	db, rec := openTestDB(t, virgotest.NewProcedure(), 1, func(db *database.DB) {
+		db.SetStmtCacheSize(4)
+	})
+	defer db.Close()
+
+	if _, err := db.Exec(0, "UPDATE a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Prepare("UPDATE hot"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 连接断开时database/sql重试后仍失败,关闭旧语句重新预编译并重试一次
+	rec.Reset()
+	rec.Expect("UPDATE a").WillReturnError(driver.ErrBadConn).Times(3)
+	rec.Expect("UPDATE hot").WillReturnError(driver.ErrBadConn).Times(3)
+	for _, query := range []string{"UPDATE a", "UPDATE hot"} {
+		if _, err := db.Exec(0, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	prepared := 0
+	for _, query := range rec.Prepared() {
+		if query == "UPDATE a" || query == "UPDATE hot" {
+			prepared++
+		}
+	}
+	if prepared < 2 {
+		t.Fatalf("not reprepared: %v", rec.Prepared())
+	}
+
+	// 重新预编译的语句继续缓存,连接更换后由database/sql在新连接上预编译
+	for i := 0; i < 2; i++ {
+		rec.Reset()
+		for _, query := range []string{"UPDATE a", "UPDATE hot"} {
+			if _, err := db.Exec(0, query); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if got := rec.Prepared(); len(got) != 0 {
+		t.Fatalf("prepared again %v", got)
+	}
+}
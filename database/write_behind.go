@@ -0,0 +1,411 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+	"github.com/panlibin/virgo"
+)
+
+const defaultWriteBehindBatchSize = 100
+const writeBehindStopRetry = 3
+const writeBehindRetryDelay = time.Second
+
+// ErrEntityExist 实体已注册
+var ErrEntityExist = errors.New("database: entity already registered")
+
+type wbEntity struct {
+	key       interface{}
+	dbIdx     uint32
+	obj       reflect.Value
+	meta      *tableMeta
+	allDirty  bool
+	dirtyCols map[string]struct{}
+	removing  bool
+}
+
+func (e *wbEntity) dirty() bool {
+	return e.allDirty || len(e.dirtyCols) > 0
+}
+
+// columns 本次需要写入的列,主键列始终在前
+func (e *wbEntity) columns() []*columnMeta {
+	cols := make([]*columnMeta, 0, len(e.meta.columns))
+	cols = append(cols, e.meta.pkColumns...)
+	for _, col := range e.meta.columns {
+		if col.pk {
+			continue
+		}
+		if _, ok := e.dirtyCols[col.name]; ok || e.allDirty {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+type wbBatch struct {
+	dbIdx uint32
+	query string
+	args  []interface{}
+	items []*wbEntity
+	cols  [][]string
+}
+
+// WriteBehind 脏数据回写,实体常驻内存,主线程标记脏字段,定时或下线时批量写入数据库.
+// 所有方法均在主线程调用
+type WriteBehind struct {
+	p         virgo.IProcedure
+	db        IDatabase
+	interval  time.Duration
+	batchSize int
+	entities  map[interface{}]*wbEntity
+	dirtySet  map[interface{}]*wbEntity
+	orphans   []*wbEntity
+	timer     *time.Timer
+	stopped   bool
+	wg        sync.WaitGroup
+	failedMtx sync.Mutex
+	failed    []*wbBatch
+	queueMtx  sync.Mutex
+	queue     []*wbBatch
+	writing   bool
+}
+
+// NewWriteBehind 新建,interval为定时回写间隔,batchSize为单条语句最大行数.
+// db为*DB时回写在调用时即进入实例队列,与之后主线程发起的同实例操作保持先后顺序;
+// 其他实现由单独协程按顺序写入,只保证回写之间的顺序
+func NewWriteBehind(p virgo.IProcedure, db IDatabase, interval time.Duration, batchSize int) *WriteBehind {
+	if batchSize <= 0 {
+		batchSize = defaultWriteBehindBatchSize
+	}
+	return &WriteBehind{
+		p:         p,
+		db:        db,
+		interval:  interval,
+		batchSize: batchSize,
+		entities:  make(map[interface{}]*wbEntity),
+		dirtySet:  make(map[interface{}]*wbEntity),
+	}
+}
+
+// Start 开始定时回写
+func (w *WriteBehind) Start() {
+	if w.interval > 0 {
+		w.timer = w.p.AfterFunc(w.interval, w.tick)
+	}
+}
+
+// Register 注册实体,obj为带db tag的结构体指针,写入路由到dbIdx
+func (w *WriteBehind) Register(key interface{}, dbIdx uint32, obj interface{}) error {
+	if _, exist := w.entities[key]; exist {
+		return ErrEntityExist
+	}
+	meta, v, err := entityMeta(obj)
+	if err != nil {
+		return err
+	}
+	if len(meta.pkColumns) == 0 {
+		return ErrNoPrimaryKey
+	}
+	w.entities[key] = &wbEntity{
+		key:       key,
+		dbIdx:     dbIdx,
+		obj:       v,
+		meta:      meta,
+		dirtyCols: make(map[string]struct{}),
+	}
+	return nil
+}
+
+// MarkDirty 标记脏列,columns为列名,为空时整行标脏
+func (w *WriteBehind) MarkDirty(key interface{}, columns ...string) {
+	e, exist := w.entities[key]
+	if !exist {
+		return
+	}
+	if len(columns) == 0 {
+		e.allDirty = true
+	}
+	for _, name := range columns {
+		if _, ok := e.meta.colByName[name]; !ok {
+			logger.Warningf("write behind: unknown column %s.%s", e.meta.table, name)
+			continue
+		}
+		e.dirtyCols[name] = struct{}{}
+	}
+	if e.dirty() {
+		w.dirtySet[key] = e
+	}
+}
+
+// Flush 立即回写指定实体
+func (w *WriteBehind) Flush(key interface{}) {
+	e, exist := w.dirtySet[key]
+	if !exist {
+		return
+	}
+	w.flush([]*wbEntity{e})
+}
+
+// FlushAll 立即回写所有脏实体
+func (w *WriteBehind) FlushAll() {
+	w.flush(w.takeDirty())
+}
+
+// Unregister 回写并移除实体,用于下线
+func (w *WriteBehind) Unregister(key interface{}) {
+	e, exist := w.entities[key]
+	if !exist {
+		return
+	}
+	w.Flush(key)
+	e.removing = true
+	delete(w.entities, key)
+}
+
+// Stop 停止定时回写并同步写入所有脏实体,在主线程调用(如OnRelease中).
+// 写入及失败重试在单独协程中按顺序执行,每批重试writeBehindStopRetry次,仍失败则返回错误,
+// 未写入的实体保留脏标记,可再次调用Stop重试
+func (w *WriteBehind) Stop() error {
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+
+	w.wg.Wait()
+	w.mergeFailed(nil)
+
+	var lastErr error
+	batches := w.buildBatches(w.takeDirty())
+	if len(batches) > 0 {
+		w.wg.Add(1)
+		w.p.AsyncTask(func([]interface{}) {
+			defer w.wg.Done()
+			lastErr = w.writeBatches(batches)
+		})
+		w.wg.Wait()
+	}
+	w.mergeFailed(nil)
+	return lastErr
+}
+
+// writeBatches 依次写入,失败的批次间隔writeBehindRetryDelay重试,仍失败的加入failed
+func (w *WriteBehind) writeBatches(batches []*wbBatch) (lastErr error) {
+	for _, batch := range batches {
+		var err error
+		for i := 0; i < writeBehindStopRetry; i++ {
+			if i > 0 {
+				time.Sleep(writeBehindRetryDelay)
+			}
+			if _, err = w.db.Exec(batch.dbIdx, batch.query, batch.args...); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("database: write behind flush %d rows failed: %v", len(batch.items), err)
+			logger.Errorf("%v", lastErr)
+			w.failedMtx.Lock()
+			w.failed = append(w.failed, batch)
+			w.failedMtx.Unlock()
+		}
+	}
+	return
+}
+
+func (w *WriteBehind) tick([]interface{}) {
+	if w.stopped {
+		return
+	}
+	w.FlushAll()
+	w.timer = w.p.AfterFunc(w.interval, w.tick)
+}
+
+func (w *WriteBehind) takeDirty() []*wbEntity {
+	arr := make([]*wbEntity, 0, len(w.dirtySet)+len(w.orphans))
+	for _, e := range w.dirtySet {
+		arr = append(arr, e)
+	}
+	arr = append(arr, w.orphans...)
+	w.orphans = nil
+	return arr
+}
+
+func (w *WriteBehind) clearDirty(e *wbEntity) {
+	if w.dirtySet[e.key] == e {
+		delete(w.dirtySet, e.key)
+	}
+}
+
+// flush 按顺序入队,保证同一实体的写入先后有序
+func (w *WriteBehind) flush(arr []*wbEntity) {
+	db, direct := w.db.(*DB)
+	for _, batch := range w.buildBatches(arr) {
+		w.wg.Add(1)
+		if !direct {
+			w.enqueue(batch)
+			continue
+		}
+		callbackChan := db.pushOperator(batch.dbIdx, &queryContext{
+			execCtx:   context.Background(),
+			query:     batch.query,
+			args:      batch.args,
+			queryType: queryTypeExec,
+		})
+		w.p.AsyncTask(func(args []interface{}) {
+			ret := <-callbackChan
+			close(callbackChan)
+			err, _ := ret[1].(error)
+			w.written(args[0].(*wbBatch), err)
+		}, batch)
+	}
+}
+
+// enqueue 加入回写队列,由单独协程依次写入
+func (w *WriteBehind) enqueue(batch *wbBatch) {
+	w.queueMtx.Lock()
+	w.queue = append(w.queue, batch)
+	start := !w.writing
+	w.writing = true
+	w.queueMtx.Unlock()
+	if start {
+		w.p.AsyncTask(w.drain)
+	}
+}
+
+func (w *WriteBehind) drain([]interface{}) {
+	for {
+		w.queueMtx.Lock()
+		if len(w.queue) == 0 {
+			w.writing = false
+			w.queueMtx.Unlock()
+			return
+		}
+		batch := w.queue[0]
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		w.queueMtx.Unlock()
+
+		_, err := w.db.Exec(batch.dbIdx, batch.query, batch.args...)
+		w.written(batch, err)
+	}
+}
+
+// written 回写完成,失败的批次交由主线程重新标脏
+func (w *WriteBehind) written(batch *wbBatch, err error) {
+	defer w.wg.Done()
+	if err == nil {
+		return
+	}
+	w.failedMtx.Lock()
+	w.failed = append(w.failed, batch)
+	w.failedMtx.Unlock()
+	w.p.SyncTask(w.mergeFailed)
+}
+
+// buildBatches 清除脏标记并按 dbIdx+表+列 分组生成多行upsert
+func (w *WriteBehind) buildBatches(arr []*wbEntity) []*wbBatch {
+	groups := make(map[string][]*wbEntity)
+	groupCols := make(map[string][]*columnMeta)
+	keys := make([]string, 0, 4)
+	for _, e := range arr {
+		if !e.dirty() {
+			w.clearDirty(e)
+			continue
+		}
+		cols := e.columns()
+		names := make([]string, len(cols))
+		for i, col := range cols {
+			names[i] = col.name
+		}
+		groupKey := fmt.Sprintf("%d|%s|%s", e.dbIdx, e.meta.table, strings.Join(names, ","))
+		if _, ok := groups[groupKey]; !ok {
+			keys = append(keys, groupKey)
+			groupCols[groupKey] = cols
+		}
+		groups[groupKey] = append(groups[groupKey], e)
+	}
+	sort.Strings(keys)
+
+	batches := make([]*wbBatch, 0, len(keys))
+	for _, groupKey := range keys {
+		entities := groups[groupKey]
+		cols := groupCols[groupKey]
+		for start := 0; start < len(entities); start += w.batchSize {
+			end := start + w.batchSize
+			if end > len(entities) {
+				end = len(entities)
+			}
+			batches = append(batches, w.buildBatch(entities[start:end], cols))
+		}
+	}
+	return batches
+}
+
+func (w *WriteBehind) buildBatch(entities []*wbEntity, cols []*columnMeta) *wbBatch {
+	meta := entities[0].meta
+	names := make([]string, len(cols))
+	keys := make([]string, 0, len(meta.pkColumns))
+	for i, col := range cols {
+		names[i] = col.name
+		if col.pk {
+			keys = append(keys, col.name)
+		}
+	}
+
+	batch := &wbBatch{
+		dbIdx: entities[0].dbIdx,
+		query: w.db.Dialect().Upsert(meta.table, names, keys, len(entities)),
+		args:  make([]interface{}, 0, len(cols)*len(entities)),
+		items: entities,
+		cols:  make([][]string, len(entities)),
+	}
+	for i, e := range entities {
+		for _, col := range cols {
+			batch.args = append(batch.args, snapshotArg(e.obj.FieldByIndex(col.index)))
+		}
+		batch.cols[i] = names
+		e.allDirty = false
+		e.dirtyCols = make(map[string]struct{})
+		w.clearDirty(e)
+	}
+	return batch
+}
+
+// mergeFailed 写入失败的实体重新标脏,等待下次回写,已移除的实体单独保留
+func (w *WriteBehind) mergeFailed([]interface{}) {
+	w.failedMtx.Lock()
+	failed := w.failed
+	w.failed = nil
+	w.failedMtx.Unlock()
+
+	for _, batch := range failed {
+		for i, e := range batch.items {
+			for _, name := range batch.cols[i] {
+				e.dirtyCols[name] = struct{}{}
+			}
+			if e.removing {
+				w.orphans = append(w.orphans, e)
+			} else {
+				w.dirtySet[e.key] = e
+			}
+		}
+	}
+}
+
+// snapshotArg 字段当前值,[]byte复制一份,避免写入前主线程修改底层数组
+func snapshotArg(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 && !v.IsNil() {
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(cp, v)
+		return cp.Interface()
+	}
+	return v.Interface()
+}
@@ -0,0 +1,171 @@
+package database_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panlibin/virgo/database"
+	"github.com/panlibin/virgo/virgotest"
+)
+
+type wbUser struct {
+	ID    int64  `db:"id,pk"`
+	Name  string `db:"name"`
+	Level int    `db:"level"`
+	Exp   int64  `db:"exp"`
+}
+
+func (wbUser) TableName() string {
+	return "user"
+}
+
+func TestWriteBehind(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 1, nil)
+	defer db.Close()
+
+	w := database.NewWriteBehind(p, db, 0, 10)
+	u1 := &wbUser{ID: 1, Name: "alice"}
+	u2 := &wbUser{ID: 2, Name: "bob"}
+	if err := w.Register(1, 0, u1); err != nil {
+		t.Fatal(err)
+	}
+	w.Register(2, 0, u2)
+	if err := w.Register(1, 0, u1); err != database.ErrEntityExist {
+		t.Fatalf("register twice error %v", err)
+	}
+
+	// 多次标脏合并为一次写入,同列集合的实体合并为一条语句
+	u1.Level = 2
+	w.MarkDirty(1, "level")
+	u1.Level = 3
+	w.MarkDirty(1, "level", "name")
+	u2.Level = 5
+	w.MarkDirty(2, "name", "level")
+	w.FlushAll()
+	w.FlushAll()
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	stmts := rec.Statements()
+	if len(stmts) != 1 {
+		t.Fatalf("statements %v", stmts)
+	}
+	if !strings.Contains(stmts[0].Query, "(`id`,`name`,`level`)") {
+		t.Fatalf("query %s", stmts[0].Query)
+	}
+	args := []interface{}{int64(1), "alice", int64(3), int64(2), "bob", int64(5)}
+	if got := stmts[0].Args; !reflect.DeepEqual(got, args) && !reflect.DeepEqual(got, append(args[3:], args[:3]...)) {
+		t.Fatalf("args %v", got)
+	}
+
+	// Stop时同步写入剩余的脏实体,包括已下线的实体
+	rec.Reset()
+	u1.Exp = 100
+	w.MarkDirty(1, "exp")
+	w.MarkDirty(2)
+	w.Unregister(2)
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	stmts = rec.Statements()
+	if len(stmts) != 2 {
+		t.Fatalf("statements %v", stmts)
+	}
+	queries := stmts[0].Query + "\n" + stmts[1].Query
+	if !strings.Contains(queries, "(`id`,`exp`)") || !strings.Contains(queries, "(`id`,`name`,`level`,`exp`)") {
+		t.Fatalf("queries %s", queries)
+	}
+
+	// 写入失败的实体保留脏标记,再次Stop时重试
+	if testing.Short() {
+		return
+	}
+	rec.Reset()
+	rec.Expect("INSERT INTO `user`").WillReturnError(errors.New("broken")).Times(3)
+	w.MarkDirty(1, "name")
+	if err := w.Stop(); err == nil {
+		t.Fatal("expected stop error")
+	}
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if stmts = rec.Statements(); len(stmts) != 4 {
+		t.Fatalf("statements %d", len(stmts))
+	}
+	if p.RunPending(); len(rec.Statements()) != 4 {
+		t.Fatal("unexpected write after stop")
+	}
+}
+
+type wbBlob struct {
+	ID   int64  `db:"id,pk"`
+	Data []byte `db:"data"`
+}
+
+func (wbBlob) TableName() string {
+	return "blob"
+}
+
+func TestWriteBehindCopyBytes(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 1, nil)
+	defer db.Close()
+
+	// 阻塞数据库协程,保证回写执行前主线程已修改字段
+	rec.Expect("SELECT SLEEP").WillDelay(time.Millisecond * 50)
+	db.AsyncExec(nil, nil, 0, "SELECT SLEEP(1)")
+
+	w := database.NewWriteBehind(p, db, 0, 10)
+	b := &wbBlob{ID: 1, Data: []byte{1, 2}}
+	w.Register(1, 0, b)
+	w.MarkDirty(1, "data")
+	w.FlushAll()
+	b.Data[0] = 9
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	stmts := rec.Statements()
+	if len(stmts) != 2 || !reflect.DeepEqual(stmts[1].Args, []interface{}{int64(1), []byte{1, 2}}) {
+		t.Fatalf("statements %v", stmts)
+	}
+}
+
+// wrappedDB 包装IDatabase,模拟装饰后的数据库
+type wrappedDB struct {
+	database.IDatabase
+}
+
+func TestWriteBehindInterface(t *testing.T) {
+	p := virgotest.NewProcedure()
+	db, rec := openTestDB(t, p, 2, nil)
+	defer db.Close()
+
+	w := database.NewWriteBehind(p, wrappedDB{db}, 0, 10)
+	u := &wbUser{ID: 1, Name: "alice"}
+	w.Register(1, 0, u)
+
+	// 回写按顺序执行,失败的实体重新标脏
+	rec.Expect("INSERT INTO `user`").WithArgs(int64(1), int64(1)).WillReturnError(errors.New("broken"))
+	for level := 1; level <= 3; level++ {
+		u.Level = level
+		w.MarkDirty(1, "level")
+		w.FlushAll()
+	}
+	if !p.RunOne(time.Second) {
+		t.Fatal("failure not merged")
+	}
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	var levels []interface{}
+	for _, stmt := range rec.Statements() {
+		levels = append(levels, stmt.Args[1])
+	}
+	if !reflect.DeepEqual(levels, []interface{}{int64(1), int64(2), int64(3), int64(3)}) {
+		t.Fatalf("written levels %v", levels)
+	}
+}
@@ -0,0 +1,74 @@
+package virgo
+
+import (
+	"time"
+)
+
+// 退出码
+const (
+	ExitCodeOK             = 0 // 正常停止
+	ExitCodeFailure        = 1 // 服务主动以失败停止
+	ExitCodePanic          = 2 // 未捕获的panic,由go运行时产生
+	ExitCodeAlreadyRunning = 3 // 已有实例在运行
+	ExitCodePidFile        = 4 // pid文件读写失败
+	ExitCodeSupervisor     = 5 // 守护进程无法启动或重启子进程
+)
+
+const (
+	_EnvSupervisedChild     = "VIRGO_SUPERVISED_CHILD"
+	_DefaultMinBackoff      = time.Second
+	_DefaultMaxBackoff      = time.Minute
+	_DefaultStableRunPeriod = time.Minute
+)
+
+type launchConfig struct {
+	pidFile     string
+	supervise   bool
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxRestarts int
+}
+
+// LaunchOption 启动选项
+type LaunchOption func(*launchConfig)
+
+// WithPidFile 写入pid文件,同时保证单实例运行
+func WithPidFile(path string) LaunchOption {
+	return func(c *launchConfig) {
+		c.pidFile = path
+	}
+}
+
+// WithSupervisor 守护模式,子进程异常退出后按退避时间重启
+func WithSupervisor(minBackoff time.Duration, maxBackoff time.Duration) LaunchOption {
+	return func(c *launchConfig) {
+		c.supervise = true
+		if minBackoff > 0 {
+			c.minBackoff = minBackoff
+		}
+		if maxBackoff > 0 {
+			c.maxBackoff = maxBackoff
+		}
+		if c.maxBackoff < c.minBackoff {
+			c.maxBackoff = c.minBackoff
+		}
+	}
+}
+
+// WithMaxRestarts 守护模式下最大连续重启次数,0为不限
+func WithMaxRestarts(n int) LaunchOption {
+	return func(c *launchConfig) {
+		c.maxRestarts = n
+	}
+}
+
+func newLaunchConfig(opts []LaunchOption) *launchConfig {
+	cfg := &launchConfig{
+		minBackoff: _DefaultMinBackoff,
+		maxBackoff: _DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
@@ -0,0 +1,137 @@
+package virgo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const envHelperRunFile = "VIRGO_TEST_RUN_FILE"
+const envHelperFailRuns = "VIRGO_TEST_FAIL_RUNS"
+
+func TestLaunchOptions(t *testing.T) {
+	cfg := newLaunchConfig(nil)
+	if cfg.supervise || cfg.pidFile != "" || cfg.minBackoff != _DefaultMinBackoff || cfg.maxBackoff != _DefaultMaxBackoff {
+		t.Fatalf("unexpected default config %+v", cfg)
+	}
+
+	cfg = newLaunchConfig([]LaunchOption{
+		WithPidFile("run/app.pid"),
+		WithSupervisor(time.Second*5, time.Second),
+		WithMaxRestarts(3),
+	})
+	if cfg.pidFile != "run/app.pid" || !cfg.supervise || cfg.maxRestarts != 3 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	// 最大退避不小于最小退避
+	if cfg.minBackoff != time.Second*5 || cfg.maxBackoff != time.Second*5 {
+		t.Fatalf("unexpected backoff %v %v", cfg.minBackoff, cfg.maxBackoff)
+	}
+}
+
+func TestPidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "virgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run", "app.pid")
+
+	// 遗留的pid文件未被锁定,直接接管
+	os.MkdirAll(filepath.Dir(path), 0755)
+	ioutil.WriteFile(path, []byte("999999999\n"), 0644)
+	pf, code := acquirePidFile(path)
+	if code != ExitCodeOK {
+		t.Fatalf("acquire stale pid file code %d", code)
+	}
+	if pid, err := readPidFile(path); err != nil || pid != os.Getpid() {
+		t.Fatalf("pid file content %d %v", pid, err)
+	}
+
+	if _, code = acquirePidFile(path); code != ExitCodeAlreadyRunning {
+		t.Fatalf("second acquire code %d", code)
+	}
+	if code = Launch(nil, WithPidFile(path)); code != ExitCodeAlreadyRunning {
+		t.Fatalf("launch code %d", code)
+	}
+
+	pf.release()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("pid file not removed: %v", err)
+	}
+	pf, code = acquirePidFile(path)
+	if code != ExitCodeOK {
+		t.Fatalf("reacquire code %d", code)
+	}
+	pf.release()
+}
+
+// TestSupervisorHelper 守护测试的子进程,记录运行次数,前n次以失败退出
+func TestSupervisorHelper(t *testing.T) {
+	runFile := os.Getenv(envHelperRunFile)
+	if runFile == "" || os.Getenv(_EnvSupervisedChild) == "" {
+		t.Skip("helper process")
+	}
+	f, err := os.OpenFile(runFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		os.Exit(ExitCodeFailure)
+	}
+	f.WriteString("run\n")
+	f.Close()
+
+	buf, _ := ioutil.ReadFile(runFile)
+	failRuns, _ := strconv.Atoi(os.Getenv(envHelperFailRuns))
+	if strings.Count(string(buf), "run") <= failRuns {
+		os.Exit(ExitCodeFailure)
+	}
+	os.Exit(ExitCodeOK)
+}
+
+func runSupervisor(t *testing.T, failRuns int, opts ...LaunchOption) (int, int, time.Duration) {
+	dir, err := ioutil.TempDir("", "virgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runFile := filepath.Join(dir, "runs")
+
+	os.Setenv(envHelperRunFile, runFile)
+	os.Setenv(envHelperFailRuns, strconv.Itoa(failRuns))
+	defer os.Unsetenv(envHelperRunFile)
+	defer os.Unsetenv(envHelperFailRuns)
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestSupervisorHelper$"}
+	defer func() { os.Args = args }()
+
+	startTime := time.Now()
+	code := supervise(newLaunchConfig(opts))
+	elapsed := time.Since(startTime)
+	buf, _ := ioutil.ReadFile(runFile)
+	return code, strings.Count(string(buf), "run"), elapsed
+}
+
+func TestSupervisor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("spawns processes")
+	}
+	backoff := time.Millisecond * 50
+
+	// 失败两次后正常退出,退避依次为 backoff, 2*backoff
+	code, runs, elapsed := runSupervisor(t, 2, WithSupervisor(backoff, time.Second))
+	if code != ExitCodeOK || runs != 3 {
+		t.Fatalf("code %d, runs %d", code, runs)
+	}
+	if elapsed < backoff*3 {
+		t.Fatalf("restart backoff not applied, elapsed %v", elapsed)
+	}
+
+	// 超过最大重启次数
+	code, runs, _ = runSupervisor(t, 100, WithSupervisor(time.Millisecond, time.Millisecond), WithMaxRestarts(2))
+	if code != ExitCodeSupervisor || runs != 3 {
+		t.Fatalf("code %d, runs %d", code, runs)
+	}
+}
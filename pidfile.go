@@ -0,0 +1,106 @@
+package virgo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	logger "github.com/panlibin/vglog"
+)
+
+const pidFileRetry = 3
+
+var errFileLocked = errors.New("file locked by another process")
+
+// pidFile 运行期间持有文件锁,锁由操作系统在进程退出时释放,遗留的pid文件不影响下次启动
+type pidFile struct {
+	path string
+	file *os.File
+}
+
+// acquirePidFile 锁定并写入pid文件,已被其他实例锁定时返回ExitCodeAlreadyRunning
+func acquirePidFile(path string) (*pidFile, int) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Errorf("create pid file dir error: %v", err)
+		return nil, ExitCodePidFile
+	}
+
+	for retry := 0; retry < pidFileRetry; retry++ {
+		f, err := openLockedFile(path)
+		if err == errFileLocked {
+			if oldPid, err := readPidFile(path); err == nil {
+				logger.Errorf("already running, pid %d", oldPid)
+			} else {
+				logger.Errorf("already running, pid file %s locked", path)
+			}
+			return nil, ExitCodeAlreadyRunning
+		}
+		if err != nil {
+			logger.Errorf("open pid file error: %v", err)
+			return nil, ExitCodePidFile
+		}
+
+		// 加锁前文件可能已被退出的实例删除并由新实例重建,锁定的不是当前路径上的文件时重试
+		if !samePath(f, path) {
+			f.Close()
+			continue
+		}
+
+		if err = writePid(f); err != nil {
+			f.Close()
+			logger.Errorf("write pid file error: %v", err)
+			return nil, ExitCodePidFile
+		}
+		return &pidFile{path: path, file: f}, ExitCodeOK
+	}
+
+	logger.Errorf("acquire pid file %s failed", path)
+	return nil, ExitCodePidFile
+}
+
+// release 持有锁时删除文件再解锁.无法删除打开中文件的平台在关闭后重试删除,
+// 此时其他实例若已打开该文件,删除失败,不会误删
+func (pf *pidFile) release() {
+	err := os.Remove(pf.path)
+	pf.file.Close()
+	if err != nil {
+		os.Remove(pf.path)
+	}
+}
+
+func writePid(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func samePath(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, pi)
+}
+
+func readPidFile(path string) (int, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(buf)))
+}
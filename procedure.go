@@ -45,6 +45,7 @@ type Procedure struct {
 	runningQue *taskQueue
 	pendingQue *taskQueue
 	cond       *sync.Cond
+	exitCode   int32
 }
 
 // NewProcedure 创建
@@ -108,6 +109,17 @@ func (p *Procedure) Stop() {
 	})
 }
 
+// Exit 以指定退出码停止
+func (p *Procedure) Exit(code int) {
+	atomic.StoreInt32(&p.exitCode, int32(code))
+	p.Stop()
+}
+
+// ExitCode 退出码
+func (p *Procedure) ExitCode() int {
+	return int(atomic.LoadInt32(&p.exitCode))
+}
+
 func (p *Procedure) run() {
 	p.wg.Add(1)
 	go func() {
@@ -152,7 +164,7 @@ func (p *Procedure) pushTask(pTask *task) {
 
 func (p *Procedure) waitQuit() {
 	go func() {
-		sigChan := make(chan os.Signal)
+		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 		bQuit := false
 		for !bQuit {
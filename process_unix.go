@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package virgo
+
+import (
+	"os"
+	"syscall"
+)
+
+// openLockedFile 打开并以排他锁锁定文件,锁随文件关闭或进程退出释放,已被锁定时返回errFileLocked
+func openLockedFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errFileLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
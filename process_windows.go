@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package virgo
+
+import (
+	"os"
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// openLockedFile 以独占写方式打开文件,其他进程只能读取,文件关闭或进程退出后释放,已被占用时返回errFileLocked
+func openLockedFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, syscall.FILE_SHARE_READ,
+		nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errorSharingViolation {
+			return nil, errFileLocked
+		}
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
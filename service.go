@@ -1,14 +1,37 @@
 package virgo
 
+import "os"
+
 // IService 服务接口
 type IService interface {
 	OnInit(*Procedure)
 	OnRelease()
 }
 
-// Launch 启动服务
-func Launch(s IService) {
+// Launch 启动服务,返回退出码
+func Launch(s IService, opts ...LaunchOption) int {
+	cfg := newLaunchConfig(opts)
+
+	supervised := os.Getenv(_EnvSupervisedChild) != ""
+	if !supervised && cfg.pidFile != "" {
+		pf, code := acquirePidFile(cfg.pidFile)
+		if code != ExitCodeOK {
+			return code
+		}
+		defer pf.release()
+	}
+
+	if !supervised && cfg.supervise {
+		return supervise(cfg)
+	}
+
 	p := NewProcedure(s)
 	p.Start()
 	p.waitQuit()
+
+	code := p.ExitCode()
+	if supervised {
+		os.Exit(code)
+	}
+	return code
 }
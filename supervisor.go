@@ -0,0 +1,103 @@
+package virgo
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+)
+
+// supervise 守护模式,以子进程运行服务,异常退出时重启
+func supervise(cfg *launchConfig) int {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	backoff := cfg.minBackoff
+	restarts := 0
+	for {
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), _EnvSupervisedChild+"=1")
+
+		startTime := time.Now()
+		if err := cmd.Start(); err != nil {
+			logger.Errorf("start child process error: %v", err)
+			return ExitCodeSupervisor
+		}
+		logger.Infof("child process started, pid %d", cmd.Process.Pid)
+
+		exitChan := make(chan error, 1)
+		go func() {
+			exitChan <- cmd.Wait()
+		}()
+
+		quit := false
+		var err error
+		for waiting := true; waiting; {
+			select {
+			case sig := <-sigChan:
+				if sig == os.Signal(syscall.SIGINT) || sig == os.Signal(syscall.SIGTERM) {
+					quit = true
+				}
+				if e := cmd.Process.Signal(sig); e != nil && quit {
+					cmd.Process.Kill()
+				}
+			case err = <-exitChan:
+				waiting = false
+			}
+		}
+
+		code := exitCodeOf(err)
+		if quit || code == ExitCodeOK || code == ExitCodeAlreadyRunning {
+			logger.Infof("child process exited, code %d", code)
+			return code
+		}
+
+		if time.Since(startTime) >= _DefaultStableRunPeriod {
+			backoff = cfg.minBackoff
+			restarts = 0
+		}
+		restarts++
+		if cfg.maxRestarts > 0 && restarts > cfg.maxRestarts {
+			logger.Errorf("child process exited, code %d, restart limit reached", code)
+			return ExitCodeSupervisor
+		}
+
+		logger.Errorf("child process exited, code %d, restart in %v", code, backoff)
+		timer := time.NewTimer(backoff)
+		for waiting := true; waiting; {
+			select {
+			case sig := <-sigChan:
+				if sig == os.Signal(syscall.SIGINT) || sig == os.Signal(syscall.SIGTERM) {
+					timer.Stop()
+					return code
+				}
+			case <-timer.C:
+				waiting = false
+			}
+		}
+
+		backoff *= 2
+		if backoff > cfg.maxBackoff {
+			backoff = cfg.maxBackoff
+		}
+	}
+}
+
+func exitCodeOf(err error) int {
+	if err == nil {
+		return ExitCodeOK
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+	}
+	return ExitCodeFailure
+}
@@ -0,0 +1,79 @@
+package nethelper
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"sync"
+)
+
+// Codec 消息体编解码,Name用于按名称注册和查找
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var codecMtx sync.RWMutex
+var codecs = make(map[string]Codec)
+
+func init() {
+	RegisterCodec(JSONCodec{})
+	RegisterCodec(GobCodec{})
+	RegisterCodec(BinaryCodec{})
+}
+
+// RegisterCodec 注册编解码,同名时覆盖,可用于接入protobuf等
+func RegisterCodec(c Codec) {
+	codecMtx.Lock()
+	codecs[c.Name()] = c
+	codecMtx.Unlock()
+}
+
+// GetCodec 按名称查找编解码
+func GetCodec(name string) (Codec, bool) {
+	codecMtx.RLock()
+	c, ok := codecs[name]
+	codecMtx.RUnlock()
+	return c, ok
+}
+
+// JSONCodec json编解码
+type JSONCodec struct{}
+
+// Name 名称
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+// Marshal 编码
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal 解码
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec gob编解码,每条消息独立编码,包含类型信息
+type GobCodec struct{}
+
+// Name 名称
+func (GobCodec) Name() string {
+	return "gob"
+}
+
+// Marshal 编码
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal 解码
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
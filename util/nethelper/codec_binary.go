@@ -0,0 +1,369 @@
+package nethelper
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+var errBinaryShort = errors.New("nethelper: binary data too short")
+
+// binaryMaxPrealloc 切片及map按长度前缀预分配的最大元素数
+const binaryMaxPrealloc = 64
+
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+// BinaryCodec 紧凑二进制编解码,无字段名及类型信息,收发双方的结构体定义必须一致.
+// 整数为变长编码,浮点为小端定长,字符串、切片和map带长度前缀,指针带存在标记,
+// 结构体按顺序编码导出字段,`binary:"-"`的字段跳过.
+// 同时实现encoding.BinaryMarshaler和BinaryUnmarshaler的类型(如time.Time)使用自身编码
+type BinaryCodec struct{}
+
+// Name 名称
+func (BinaryCodec) Name() string {
+	return "binary"
+}
+
+// Marshal 编码
+func (BinaryCodec) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, errors.New("nethelper: binary marshal nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	return appendBinary(nil, rv)
+}
+
+// Unmarshal 解码,v为非空指针
+func (BinaryCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("nethelper: binary unmarshal requires a non-nil pointer, got %T", v)
+	}
+	d := &binaryDecoder{data: data}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("nethelper: binary unmarshal %d trailing bytes", len(d.data)-d.pos)
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, x int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+// binaryCustom 类型同时实现BinaryMarshaler和BinaryUnmarshaler时使用自身编码
+func binaryCustom(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return t.Kind() != reflect.Ptr && pt.Implements(binaryMarshalerType) && pt.Implements(binaryUnmarshalerType)
+}
+
+func appendBinary(buf []byte, v reflect.Value) ([]byte, error) {
+	if binaryCustom(v.Type()) {
+		if !v.CanAddr() {
+			addr := reflect.New(v.Type())
+			addr.Elem().Set(v)
+			v = addr.Elem()
+		}
+		b, err := v.Addr().Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = appendUvarint(buf, uint64(len(b)))
+		return append(buf, b...), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUvarint(buf, v.Uint()), nil
+	case reflect.Float32:
+		var tmp [4]byte
+		binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(float32(v.Float())))
+		return append(buf, tmp[:]...), nil
+	case reflect.Float64:
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v.Float()))
+		return append(buf, tmp[:]...), nil
+	case reflect.String:
+		buf = appendUvarint(buf, uint64(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf = appendUvarint(buf, uint64(v.Len()))
+			return append(buf, v.Bytes()...), nil
+		}
+		buf = appendUvarint(buf, uint64(v.Len()))
+		return appendElems(buf, v)
+	case reflect.Array:
+		return appendElems(buf, v)
+	case reflect.Map:
+		return appendMap(buf, v)
+	case reflect.Struct:
+		var err error
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !binaryField(t.Field(i)) {
+				continue
+			}
+			if buf, err = appendBinary(buf, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendBinary(append(buf, 1), v.Elem())
+	}
+	return nil, fmt.Errorf("nethelper: binary codec unsupported type %v", v.Type())
+}
+
+func appendElems(buf []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		if buf, err = appendBinary(buf, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendMap 键按编码后的字节排序,相同内容的编码结果相同
+func appendMap(buf []byte, v reflect.Value) ([]byte, error) {
+	type entry struct {
+		key []byte
+		val reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := appendBinary(nil, iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{key: key, val: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	var err error
+	buf = appendUvarint(buf, uint64(len(entries)))
+	for _, e := range entries {
+		buf = append(buf, e.key...)
+		if buf, err = appendBinary(buf, e.val); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func binaryField(f reflect.StructField) bool {
+	return f.PkgPath == "" && f.Tag.Get("binary") != "-"
+}
+
+type binaryDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *binaryDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, errBinaryShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *binaryDecoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, errBinaryShort
+	}
+	d.pos += n
+	return x, nil
+}
+
+func (d *binaryDecoder) varint() (int64, error) {
+	x, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, errBinaryShort
+	}
+	d.pos += n
+	return x, nil
+}
+
+// length 读取长度前缀,不超过剩余字节数,避免异常数据导致大量分配
+func (d *binaryDecoder) length() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		return 0, errBinaryShort
+	}
+	return int(n), nil
+}
+
+func (d *binaryDecoder) decode(v reflect.Value) error {
+	if binaryCustom(v.Type()) {
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		b, _ := d.next(n)
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := d.varint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(x) {
+			return fmt.Errorf("nethelper: binary value %d overflows %v", x, v.Type())
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(x) {
+			return fmt.Errorf("nethelper: binary value %d overflows %v", x, v.Type())
+		}
+		v.SetUint(x)
+	case reflect.Float32:
+		b, err := d.next(4)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	case reflect.Float64:
+		b, err := d.next(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.String:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		b, _ := d.next(n)
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, _ := d.next(n)
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		// 元素可能远大于其编码长度,按解码进度扩容,预分配不超过binaryMaxPrealloc
+		elemType := v.Type().Elem()
+		s := reflect.MakeSlice(v.Type(), 0, minInt(n, binaryMaxPrealloc))
+		for i := 0; i < n; i++ {
+			s = reflect.Append(s, reflect.Zero(elemType))
+			if err = d.decode(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		t := v.Type()
+		m := reflect.MakeMapWithSize(t, minInt(n, binaryMaxPrealloc))
+		for i := 0; i < n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			if err = d.decode(key); err != nil {
+				return err
+			}
+			val := reflect.New(t.Elem()).Elem()
+			if err = d.decode(val); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, val)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !binaryField(t.Field(i)) {
+				continue
+			}
+			if err := d.decode(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err = d.decode(elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+	default:
+		return fmt.Errorf("nethelper: binary codec unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,109 @@
+package nethelper
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type codecItem struct {
+	ID    int32
+	Count uint16
+}
+
+type codecMsg struct {
+	Name    string
+	Level   int64
+	Rate    float64
+	Online  bool
+	Data    []byte
+	Items   []codecItem
+	Attrs   map[string]int
+	Parent  *codecItem
+	Created time.Time
+	Pos     [2]float32
+	Skip    string `binary:"-"`
+}
+
+type nameCodec struct {
+	JSONCodec
+}
+
+func (nameCodec) Name() string {
+	return "custom"
+}
+
+func TestCodecs(t *testing.T) {
+	src := codecMsg{
+		Name:    "bob",
+		Level:   -42,
+		Rate:    1.5,
+		Online:  true,
+		Data:    []byte{1, 2, 3},
+		Items:   []codecItem{{ID: 1, Count: 2}, {ID: -3, Count: 4}},
+		Attrs:   map[string]int{"a": 1, "b": 2},
+		Parent:  &codecItem{ID: 7},
+		Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Pos:     [2]float32{1, 2},
+	}
+	for _, name := range []string{"json", "gob", "binary"} {
+		c, ok := GetCodec(name)
+		if !ok {
+			t.Fatalf("codec %s not registered", name)
+		}
+		buf, err := c.Marshal(&src)
+		if err != nil {
+			t.Fatalf("%s marshal: %v", name, err)
+		}
+		var dst codecMsg
+		if err = c.Unmarshal(buf, &dst); err != nil {
+			t.Fatalf("%s unmarshal: %v", name, err)
+		}
+		if !dst.Created.Equal(src.Created) {
+			t.Fatalf("%s created %v", name, dst.Created)
+		}
+		dst.Created = src.Created
+		if !reflect.DeepEqual(dst, src) {
+			t.Fatalf("%s round trip %+v", name, dst)
+		}
+	}
+
+	// 跳过的字段不编码,截断的数据返回错误
+	src.Skip = "skip"
+	buf, _ := BinaryCodec{}.Marshal(&src)
+	var dst codecMsg
+	if err := (BinaryCodec{}).Unmarshal(buf, &dst); err != nil || dst.Skip != "" {
+		t.Fatalf("skip %q %v", dst.Skip, err)
+	}
+	if err := (BinaryCodec{}).Unmarshal(buf[:len(buf)-1], &dst); err == nil {
+		t.Fatal("expected error on truncated data")
+	}
+
+	RegisterCodec(nameCodec{})
+	if c, ok := GetCodec("custom"); !ok || c.Name() != "custom" {
+		t.Fatal("custom codec not registered")
+	}
+}
+
+func TestBinaryMalformed(t *testing.T) {
+	// 长度前缀声称有大量大元素,实际数据很短,不应按长度前缀分配
+	buf := appendUvarint(nil, 1<<16)
+	buf = append(buf, make([]byte, 1<<16)...)
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	before := ms.TotalAlloc
+
+	var dst [][4096]int64
+	if err := (BinaryCodec{}).Unmarshal(buf, &dst); err == nil {
+		t.Fatal("expected error on malformed data")
+	}
+	var m map[int32][4096]int64
+	if err := (BinaryCodec{}).Unmarshal(buf, &m); err == nil {
+		t.Fatal("expected error on malformed data")
+	}
+	runtime.ReadMemStats(&ms)
+	if alloc := ms.TotalAlloc - before; alloc > 64<<20 {
+		t.Fatalf("allocated %d bytes for %d bytes of input", alloc, len(buf))
+	}
+}
@@ -0,0 +1,164 @@
+package nethelper
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	logger "github.com/panlibin/vglog"
+)
+
+// ErrInvalidHandler 处理函数必须是func(*Session, *T)
+var ErrInvalidHandler = errors.New("nethelper: handler must be func(*Session, *T)")
+
+// ErrUnregisteredMessage 消息类型未注册
+var ErrUnregisteredMessage = errors.New("nethelper: message type not registered")
+
+var sessionType = reflect.TypeOf((*Session)(nil))
+
+type route struct {
+	typ reflect.Type
+	fn  reflect.Value
+}
+
+// Router 按消息ID解码并分发到处理函数,实现ISessionHandler,可直接作为服务器的handler.
+// 处理函数在主线程调用,注册在服务器启动前完成
+type Router struct {
+	codec   Codec
+	routes  map[uint32]*route
+	ids     map[reflect.Type]uint32
+	onOpen  func(s *Session)
+	onClose func(s *Session, err error)
+	unknown func(s *Session, msgID uint32, msg []byte)
+}
+
+// NewRouter 新建
+func NewRouter(codec Codec) *Router {
+	return &Router{
+		codec:  codec,
+		routes: make(map[uint32]*route),
+		ids:    make(map[reflect.Type]uint32),
+	}
+}
+
+// Codec 消息体编解码
+func (r *Router) Codec() Codec {
+	return r.codec
+}
+
+// Register 注册消息ID的处理函数,handler为func(*Session, *T),T为消息类型
+func (r *Router) Register(msgID uint32, handler interface{}) error {
+	fn := reflect.ValueOf(handler)
+	ft := fn.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() != 2 || ft.NumOut() != 0 ||
+		ft.In(0) != sessionType || ft.In(1).Kind() != reflect.Ptr {
+		return ErrInvalidHandler
+	}
+	if err := r.bind(msgID, ft.In(1)); err != nil {
+		return err
+	}
+	r.routes[msgID] = &route{typ: ft.In(1).Elem(), fn: fn}
+	return nil
+}
+
+// RegisterMessage 注册只发送不处理的消息类型,msg为该类型的指针,用于Send查找消息ID
+func (r *Router) RegisterMessage(msgID uint32, msg interface{}) error {
+	typ := reflect.TypeOf(msg)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		return fmt.Errorf("nethelper: message must be a pointer, got %T", msg)
+	}
+	return r.bind(msgID, typ)
+}
+
+func (r *Router) bind(msgID uint32, typ reflect.Type) error {
+	if old, ok := r.ids[typ]; ok && old != msgID {
+		return fmt.Errorf("nethelper: message type %v already registered as %d", typ, old)
+	}
+	if _, ok := r.routes[msgID]; ok {
+		return fmt.Errorf("nethelper: message id %d already registered", msgID)
+	}
+	for t, id := range r.ids {
+		if id == msgID && t != typ {
+			return fmt.Errorf("nethelper: message id %d already registered", msgID)
+		}
+	}
+	r.ids[typ] = msgID
+	return nil
+}
+
+// SetOnOpen 设置会话建立回调
+func (r *Router) SetOnOpen(f func(s *Session)) {
+	r.onOpen = f
+}
+
+// SetOnClose 设置会话关闭回调
+func (r *Router) SetOnClose(f func(s *Session, err error)) {
+	r.onClose = f
+}
+
+// SetUnknownHandler 设置未注册消息的处理函数,默认记录日志后丢弃
+func (r *Router) SetUnknownHandler(f func(s *Session, msgID uint32, msg []byte)) {
+	r.unknown = f
+}
+
+// MessageID 消息对应的ID
+func (r *Router) MessageID(msg interface{}) (uint32, bool) {
+	msgID, ok := r.ids[reflect.TypeOf(msg)]
+	return msgID, ok
+}
+
+// Encode 编码消息,返回消息ID及消息体
+func (r *Router) Encode(msg interface{}) (uint32, []byte, error) {
+	msgID, ok := r.MessageID(msg)
+	if !ok {
+		return 0, nil, ErrUnregisteredMessage
+	}
+	buf, err := r.codec.Marshal(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	return msgID, buf, nil
+}
+
+// Send 编码并发送已注册类型的消息
+func (r *Router) Send(s *Session, msg interface{}) error {
+	msgID, buf, err := r.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return s.Send(msgID, buf)
+}
+
+// OnOpen 实现ISessionHandler
+func (r *Router) OnOpen(s *Session) {
+	if r.onOpen != nil {
+		r.onOpen(s)
+	}
+}
+
+// OnClose 实现ISessionHandler
+func (r *Router) OnClose(s *Session, err error) {
+	if r.onClose != nil {
+		r.onClose(s, err)
+	}
+}
+
+// OnMessage 实现ISessionHandler,解码失败时关闭会话
+func (r *Router) OnMessage(s *Session, msgID uint32, msg []byte) {
+	rt, ok := r.routes[msgID]
+	if !ok {
+		if r.unknown != nil {
+			r.unknown(s, msgID, msg)
+		} else {
+			logger.Warningf("session %d unknown message %d", s.ID(), msgID)
+		}
+		return
+	}
+	v := reflect.New(rt.typ)
+	if err := r.codec.Unmarshal(msg, v.Interface()); err != nil {
+		logger.Warningf("session %d decode message %d error: %v", s.ID(), msgID, err)
+		s.Close()
+		return
+	}
+	rt.fn.Call([]reflect.Value{reflect.ValueOf(s), v})
+}
@@ -0,0 +1,70 @@
+package nethelper
+
+import (
+	"net"
+	"testing"
+
+	"github.com/panlibin/virgo/virgotest"
+)
+
+type loginReq struct {
+	Name string
+}
+
+type loginResp struct {
+	Welcome string
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter(JSONCodec{})
+	var got string
+	if err := r.Register(1, func(s *Session, req *loginReq) {
+		got = req.Name
+		r.Send(s, &loginResp{Welcome: "hi " + req.Name})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterMessage(2, &loginResp{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(3, func(s *Session, req loginReq) {}); err != ErrInvalidHandler {
+		t.Fatalf("expected ErrInvalidHandler, got %v", err)
+	}
+	if err := r.RegisterMessage(1, &loginResp{}); err == nil {
+		t.Fatal("expected duplicate id error")
+	}
+	var unknown uint32
+	r.SetUnknownHandler(func(s *Session, msgID uint32, msg []byte) { unknown = msgID })
+
+	p := virgotest.NewProcedure()
+	srv := NewTCPServer(p, r)
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	runOne(t, p)
+
+	tr := newTCPTransport(conn, LittleEndian)
+	tr.WriteMessage(9, nil)
+	tr.WriteMessage(1, []byte(`{"Name":"bob"}`))
+	tr.Flush()
+	runOne(t, p)
+	runOne(t, p)
+	if unknown != 9 || got != "bob" {
+		t.Fatalf("unknown %d, got %q", unknown, got)
+	}
+
+	msgID, msg, err := tr.ReadMessage()
+	var resp loginResp
+	if err == nil {
+		err = JSONCodec{}.Unmarshal(msg, &resp)
+	}
+	if err != nil || msgID != 2 || resp.Welcome != "hi bob" {
+		t.Fatalf("response %d %+v %v", msgID, resp, err)
+	}
+}
@@ -0,0 +1,287 @@
+package nethelper
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+	"github.com/panlibin/virgo"
+)
+
+const defaultSendQueueSize = 256
+const defaultWriteTimeout = time.Second * 10
+
+// ErrSessionClosed 会话已关闭
+var ErrSessionClosed = errors.New("nethelper: session closed")
+
+// ErrSendQueueFull 发送队列已满,对端接收过慢,会话被关闭
+var ErrSendQueueFull = errors.New("nethelper: send queue full")
+
+// ErrIdleTimeout 超过空闲时间未收到消息
+var ErrIdleTimeout = errors.New("nethelper: idle timeout")
+
+// ISessionHandler 会话事件,均在主线程回调.OnClose之后不再有该会话的OnMessage
+type ISessionHandler interface {
+	OnOpen(s *Session)
+	OnClose(s *Session, err error)
+	OnMessage(s *Session, msgID uint32, msg []byte)
+}
+
+// transport 会话的底层连接,负责消息帧的编解码,读写分别只在一个协程调用
+type transport interface {
+	ReadMessage() (msgID uint32, msg []byte, err error)
+	WriteMessage(msgID uint32, msg []byte) error
+	Flush() error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+type outMessage struct {
+	msgID uint32
+	msg   []byte
+}
+
+// Session 连接会话,读写各一个协程,消息及事件回调到主线程
+type Session struct {
+	id        uint64
+	t         transport
+	g         *sessionGroup
+	sendChan  chan outMessage
+	closeChan chan struct{}
+	closeOnce sync.Once
+	errMtx    sync.Mutex
+	closeErr  error
+	closed    int32
+	userData  interface{}
+}
+
+// ID 会话id,同一服务器内唯一
+func (s *Session) ID() uint64 {
+	return s.id
+}
+
+// RemoteAddr 对端地址
+func (s *Session) RemoteAddr() net.Addr {
+	return s.t.RemoteAddr()
+}
+
+// SetUserData 关联业务数据,主线程调用
+func (s *Session) SetUserData(data interface{}) {
+	s.userData = data
+}
+
+// UserData 关联的业务数据,主线程调用
+func (s *Session) UserData() interface{} {
+	return s.userData
+}
+
+// Send 消息入发送队列,不阻塞.队列满时关闭会话
+func (s *Session) Send(msgID uint32, msg []byte) error {
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return ErrSessionClosed
+	}
+	select {
+	case s.sendChan <- outMessage{msgID: msgID, msg: msg}:
+		return nil
+	default:
+		logger.Warningf("session %d send queue full, close", s.id)
+		s.shutdown(ErrSendQueueFull)
+		return ErrSendQueueFull
+	}
+}
+
+// Close 发送队列中的消息写完后关闭
+func (s *Session) Close() {
+	s.close(nil, false)
+}
+
+// shutdown 立即关闭,丢弃未发送的消息
+func (s *Session) shutdown(err error) {
+	s.close(err, true)
+}
+
+func (s *Session) close(err error, immediate bool) {
+	s.closeOnce.Do(func() {
+		s.errMtx.Lock()
+		s.closeErr = err
+		s.errMtx.Unlock()
+		atomic.StoreInt32(&s.closed, 1)
+		close(s.closeChan)
+		if immediate {
+			s.t.Close()
+		}
+	})
+}
+
+func (s *Session) start() {
+	s.g.wg.Add(2)
+	go s.readLoop()
+	go s.writeLoop()
+}
+
+// readLoop 读取消息,退出时回调OnClose
+func (s *Session) readLoop() {
+	defer s.g.wg.Done()
+	idleTimeout := s.g.cfg.idleTimeout
+	for {
+		if idleTimeout > 0 {
+			s.t.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		msgID, msg, err := s.t.ReadMessage()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				err = ErrIdleTimeout
+			}
+			s.shutdown(err)
+			break
+		}
+		s.g.p.SyncTask(s.g.onMessage, s, msgID, msg)
+	}
+
+	s.g.remove(s)
+	s.errMtx.Lock()
+	err := s.closeErr
+	s.errMtx.Unlock()
+	s.g.p.SyncTask(s.g.onClose, s, err)
+}
+
+// writeLoop 写入消息,队列为空时刷新缓冲.主动关闭时写完队列中的消息
+func (s *Session) writeLoop() {
+	defer s.g.wg.Done()
+	for {
+		select {
+		case m := <-s.sendChan:
+			if err := s.write(m); err != nil {
+				s.shutdown(err)
+				return
+			}
+		case <-s.closeChan:
+			for {
+				select {
+				case m := <-s.sendChan:
+					if s.write(m) != nil {
+						s.t.Close()
+						return
+					}
+				default:
+					s.t.Flush()
+					s.t.Close()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Session) write(m outMessage) error {
+	s.t.SetWriteDeadline(time.Now().Add(defaultWriteTimeout))
+	if err := s.t.WriteMessage(m.msgID, m.msg); err != nil {
+		return err
+	}
+	if len(s.sendChan) == 0 {
+		return s.t.Flush()
+	}
+	return nil
+}
+
+type sessionConfig struct {
+	endian        EndianType
+	maxConn       int
+	idleTimeout   time.Duration
+	sendQueueSize int
+}
+
+// sessionGroup 服务器的会话集合,负责连接数限制和事件分发
+type sessionGroup struct {
+	p        virgo.IProcedure
+	handler  ISessionHandler
+	cfg      sessionConfig
+	mtx      sync.Mutex
+	sessions map[uint64]*Session
+	nextID   uint64
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func newSessionGroup(p virgo.IProcedure, handler ISessionHandler) *sessionGroup {
+	return &sessionGroup{
+		p:        p,
+		handler:  handler,
+		cfg:      sessionConfig{sendQueueSize: defaultSendQueueSize},
+		sessions: make(map[uint64]*Session),
+	}
+}
+
+// add 创建会话并回调OnOpen,超过连接数上限或已停止时返回nil
+func (g *sessionGroup) add(t transport) *Session {
+	g.mtx.Lock()
+	if g.closed || (g.cfg.maxConn > 0 && len(g.sessions) >= g.cfg.maxConn) {
+		g.mtx.Unlock()
+		return nil
+	}
+	g.nextID++
+	s := &Session{
+		id:        g.nextID,
+		t:         t,
+		g:         g,
+		sendChan:  make(chan outMessage, g.cfg.sendQueueSize),
+		closeChan: make(chan struct{}),
+	}
+	g.sessions[s.id] = s
+	g.mtx.Unlock()
+
+	g.p.SyncTask(g.onOpen, s)
+	s.start()
+	return s
+}
+
+func (g *sessionGroup) remove(s *Session) {
+	g.mtx.Lock()
+	delete(g.sessions, s.id)
+	g.mtx.Unlock()
+}
+
+func (g *sessionGroup) count() int {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return len(g.sessions)
+}
+
+func (g *sessionGroup) get(id uint64) *Session {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.sessions[id]
+}
+
+// closeAll 拒绝新连接并关闭所有会话,等待读写协程退出
+func (g *sessionGroup) closeAll() {
+	g.mtx.Lock()
+	g.closed = true
+	sessions := make([]*Session, 0, len(g.sessions))
+	for _, s := range g.sessions {
+		sessions = append(sessions, s)
+	}
+	g.mtx.Unlock()
+	for _, s := range sessions {
+		s.Close()
+	}
+	g.wg.Wait()
+}
+
+func (g *sessionGroup) onOpen(args []interface{}) {
+	g.handler.OnOpen(args[0].(*Session))
+}
+
+func (g *sessionGroup) onClose(args []interface{}) {
+	err, _ := args[1].(error)
+	g.handler.OnClose(args[0].(*Session), err)
+}
+
+func (g *sessionGroup) onMessage(args []interface{}) {
+	g.handler.OnMessage(args[0].(*Session), args[1].(uint32), args[2].([]byte))
+}
@@ -0,0 +1,148 @@
+package nethelper
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panlibin/virgo/virgotest"
+)
+
+// runOne 执行一个主线程任务
+func runOne(t *testing.T, p *virgotest.Procedure) {
+	t.Helper()
+	if !p.RunOne(time.Second) {
+		t.Fatal("no task delivered")
+	}
+}
+
+// echoHandler 原样回复消息,记录事件
+type echoHandler struct {
+	events []string
+}
+
+func (h *echoHandler) OnOpen(s *Session) {
+	h.events = append(h.events, "open")
+}
+
+func (h *echoHandler) OnClose(s *Session, err error) {
+	h.events = append(h.events, "close")
+}
+
+func (h *echoHandler) OnMessage(s *Session, msgID uint32, msg []byte) {
+	h.events = append(h.events, "msg:"+string(msg))
+	s.Send(msgID+1, msg)
+}
+
+// recordHandler 只记录事件
+type recordHandler struct {
+	events []string
+}
+
+func (h *recordHandler) OnOpen(s *Session) {
+	h.events = append(h.events, "open")
+}
+
+func (h *recordHandler) OnClose(s *Session, err error) {
+	h.events = append(h.events, "close")
+}
+
+func (h *recordHandler) OnMessage(s *Session, msgID uint32, msg []byte) {
+	h.events = append(h.events, "msg:"+string(msg))
+}
+
+func TestTCPServer(t *testing.T) {
+	p := virgotest.NewProcedure()
+	h := &echoHandler{}
+	srv := NewTCPServer(p, h)
+	srv.SetMaxConn(1)
+	srv.SetIdleTimeout(time.Millisecond * 200)
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	runOne(t, p)
+
+	buf, _ := DefaultTCPWrite(7, []byte("hi"), LittleEndian)
+	conn.Write(buf)
+	runOne(t, p)
+	tr := newTCPTransport(conn, LittleEndian)
+	msgID, msg, err := tr.ReadMessage()
+	if err != nil || msgID != 8 || string(msg) != "hi" {
+		t.Fatalf("echo %d %q %v", msgID, msg, err)
+	}
+
+	// 超过连接数上限的连接被关闭
+	conn2, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn2.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected rejected connection")
+	}
+	conn2.Close()
+
+	// 空闲超时后关闭
+	runOne(t, p)
+	if len(h.events) != 3 || h.events[1] != "msg:hi" || h.events[2] != "close" {
+		t.Fatalf("unexpected events %v", h.events)
+	}
+	if srv.SessionCount() != 0 {
+		t.Fatalf("session count %d", srv.SessionCount())
+	}
+}
+
+func TestWsServer(t *testing.T) {
+	ps := virgotest.NewProcedure()
+	srv := NewWsServer(ps, &echoHandler{})
+	srv.SetPingInterval(time.Millisecond * 20)
+	srv.SetIdleTimeout(time.Millisecond * 100)
+	hs := httptest.NewServer(&handlerWrapper{srv.ServeHTTP})
+	defer hs.Close()
+	defer srv.Stop()
+	addr := "ws" + strings.TrimPrefix(hs.URL, "http") + "/ws"
+
+	pc := virgotest.NewProcedure()
+	h := &recordHandler{}
+	client := NewWsClient(pc, h)
+	defer client.Close()
+	cs, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runOne(t, pc)
+	runOne(t, ps)
+
+	// 回复ping保持连接,超过空闲时间仍可收发
+	time.Sleep(time.Millisecond * 200)
+	cs.Send(7, []byte("hi"))
+	runOne(t, ps)
+	runOne(t, pc)
+	if len(h.events) != 2 || h.events[1] != "msg:hi" {
+		t.Fatalf("unexpected events %v", h.events)
+	}
+
+	// 不同源的握手被拒绝
+	evil := NewWsClient(virgotest.NewProcedure(), &recordHandler{})
+	evil.SetOrigin("http://evil.example.com")
+	if _, err = evil.Dial(addr); !errors.Is(err, ErrWsHandshake) {
+		t.Fatalf("expected handshake error, got %v", err)
+	}
+
+	cs.Close()
+	runOne(t, ps)
+	runOne(t, pc)
+	if h.events[2] != "close" || srv.SessionCount() != 0 {
+		t.Fatalf("unexpected events %v, session count %d", h.events, srv.SessionCount())
+	}
+}
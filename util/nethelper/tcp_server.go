@@ -0,0 +1,182 @@
+package nethelper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+	"github.com/panlibin/virgo"
+)
+
+var errMessageTooShort = errors.New("message too short")
+
+// tcpTransport 按DefaultTCPRead/DefaultTCPWrite分帧: 长度 + 消息ID + 消息体
+type tcpTransport struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	endian EndianType
+}
+
+func newTCPTransport(conn net.Conn, endian EndianType) *tcpTransport {
+	return &tcpTransport{
+		conn:   conn,
+		r:      bufio.NewReader(conn),
+		w:      bufio.NewWriter(conn),
+		endian: endian,
+	}
+}
+
+func (t *tcpTransport) ReadMessage() (uint32, []byte, error) {
+	buf, err := DefaultTCPRead(t.r, t.endian)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(buf) < MessageIDSize {
+		return 0, nil, errMessageTooShort
+	}
+	var msgID uint32
+	if t.endian == LittleEndian {
+		msgID = binary.LittleEndian.Uint32(buf)
+	} else {
+		msgID = binary.BigEndian.Uint32(buf)
+	}
+	return msgID, buf[MessageIDSize:], nil
+}
+
+func (t *tcpTransport) WriteMessage(msgID uint32, msg []byte) error {
+	buf, err := DefaultTCPWrite(msgID, msg, t.endian)
+	if err != nil {
+		return err
+	}
+	_, err = t.w.Write(buf)
+	return err
+}
+
+func (t *tcpTransport) Flush() error {
+	return t.w.Flush()
+}
+
+func (t *tcpTransport) SetReadDeadline(d time.Time) error {
+	return t.conn.SetReadDeadline(d)
+}
+
+func (t *tcpTransport) SetWriteDeadline(d time.Time) error {
+	return t.conn.SetWriteDeadline(d)
+}
+
+func (t *tcpTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// TCPServer tcp服务器,每个连接一个会话,会话事件在主线程回调
+type TCPServer struct {
+	g        *sessionGroup
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewTCPServer 新建
+func NewTCPServer(p virgo.IProcedure, handler ISessionHandler) *TCPServer {
+	return &TCPServer{
+		g: newSessionGroup(p, handler),
+	}
+}
+
+// SetEndian 设置消息长度及ID的大小端,Start前调用
+func (s *TCPServer) SetEndian(endianType EndianType) {
+	s.g.cfg.endian = endianType
+}
+
+// SetMaxConn 设置最大连接数,超过时新连接直接关闭,Start前调用
+func (s *TCPServer) SetMaxConn(n int) {
+	s.g.cfg.maxConn = n
+}
+
+// SetIdleTimeout 设置空闲超时,超过时间未收到消息时关闭会话,Start前调用
+func (s *TCPServer) SetIdleTimeout(d time.Duration) {
+	s.g.cfg.idleTimeout = d
+}
+
+// SetSendQueueSize 设置每个会话的发送队列长度,Start前调用
+func (s *TCPServer) SetSendQueueSize(size int) {
+	s.g.cfg.sendQueueSize = size
+}
+
+// Start 启动
+func (s *TCPServer) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Errorf("start tcp server error: %v", err)
+		return err
+	}
+	s.listener = ln
+	s.wg.Add(1)
+	go s.accept()
+	logger.Infof("tcp server listen on %s", ln.Addr())
+	return nil
+}
+
+// Addr 监听地址
+func (s *TCPServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Stop 停止接受连接,关闭所有会话并等待读写协程退出
+func (s *TCPServer) Stop() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.wg.Wait()
+	s.g.closeAll()
+}
+
+// Session 按id查找会话
+func (s *TCPServer) Session(id uint64) *Session {
+	return s.g.get(id)
+}
+
+// SessionCount 当前会话数
+func (s *TCPServer) SessionCount() int {
+	return s.g.count()
+}
+
+func (s *TCPServer) accept() {
+	defer s.wg.Done()
+	var delay time.Duration
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if isClosedErr(err) {
+				return
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			logger.Warningf("tcp accept error: %v, retry in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		if s.g.add(newTCPTransport(conn, s.g.cfg.endian)) == nil {
+			logger.Warningf("tcp connection from %s rejected, too many connections", conn.RemoteAddr())
+			conn.Close()
+		}
+	}
+}
+
+// isClosedErr 监听已关闭.net.ErrClosed需要Go 1.16,之前的版本只能按错误信息判断
+func isClosedErr(err error) bool {
+	return strings.Contains(err.Error(), "use of closed network connection")
+}
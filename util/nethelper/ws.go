@@ -0,0 +1,324 @@
+package nethelper
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// websocket帧操作码
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xA
+)
+
+// websocket关闭状态码
+const (
+	wsCloseNormal          = 1000
+	wsCloseProtocolError   = 1002
+	wsCloseMessageTooLarge = 1009
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+const wsMaxControlPayload = 125
+const wsCloseTimeout = time.Second
+
+var errWsProtocol = errors.New("websocket protocol error")
+var errWsMessageTooLarge = errors.New("websocket message too large")
+
+// wsAcceptKey 握手应答的Sec-WebSocket-Accept
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// wsTransport RFC 6455 帧编解码,消息为二进制帧,内容按DefaultWsWrite编码: 消息ID + 消息体.
+// 控制帧在读协程内处理,写操作加锁以便与会话写协程并发
+type wsTransport struct {
+	conn        net.Conn
+	r           *bufio.Reader
+	w           *bufio.Writer
+	endian      EndianType
+	client      bool
+	idleTimeout time.Duration
+	writeMtx    sync.Mutex
+	closeOnce   sync.Once
+	closeChan   chan struct{}
+	closeCode   int
+	fragments   []byte
+	fragmented  bool
+}
+
+func newWsTransport(conn net.Conn, r *bufio.Reader, endian EndianType, client bool, idleTimeout time.Duration, pingInterval time.Duration) *wsTransport {
+	t := &wsTransport{
+		conn:        conn,
+		r:           r,
+		w:           bufio.NewWriter(conn),
+		endian:      endian,
+		client:      client,
+		idleTimeout: idleTimeout,
+		closeChan:   make(chan struct{}),
+		closeCode:   wsCloseNormal,
+	}
+	if pingInterval > 0 {
+		go t.keepAlive(pingInterval)
+	}
+	return t
+}
+
+// keepAlive 定时发送ping,对端回复的pong延长空闲超时
+func (t *wsTransport) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.writeControl(wsOpPing, nil); err != nil {
+				return
+			}
+		case <-t.closeChan:
+			return
+		}
+	}
+}
+
+func (t *wsTransport) ReadMessage() (uint32, []byte, error) {
+	for {
+		fin, opcode, payload, err := t.readFrame()
+		if err != nil {
+			if err == errWsMessageTooLarge {
+				t.closeCode = wsCloseMessageTooLarge
+			} else if err == errWsProtocol {
+				t.closeCode = wsCloseProtocolError
+			}
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			t.extendDeadline()
+			if err = t.writeControl(wsOpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			t.extendDeadline()
+			continue
+		case wsOpClose:
+			return 0, nil, io.EOF
+		case wsOpText, wsOpBinary:
+			if t.fragmented {
+				t.closeCode = wsCloseProtocolError
+				return 0, nil, errWsProtocol
+			}
+			t.fragments = append(t.fragments[:0], payload...)
+			t.fragmented = !fin
+		case wsOpContinuation:
+			if !t.fragmented {
+				t.closeCode = wsCloseProtocolError
+				return 0, nil, errWsProtocol
+			}
+			if len(t.fragments)+len(payload) > int(MessageMaxLength)+MessageIDSize {
+				t.closeCode = wsCloseMessageTooLarge
+				return 0, nil, errWsMessageTooLarge
+			}
+			t.fragments = append(t.fragments, payload...)
+			t.fragmented = !fin
+		default:
+			t.closeCode = wsCloseProtocolError
+			return 0, nil, errWsProtocol
+		}
+		if !fin {
+			continue
+		}
+
+		if len(t.fragments) < MessageIDSize {
+			return 0, nil, errMessageTooShort
+		}
+		var msgID uint32
+		if t.endian == LittleEndian {
+			msgID = binary.LittleEndian.Uint32(t.fragments)
+		} else {
+			msgID = binary.BigEndian.Uint32(t.fragments)
+		}
+		msg := make([]byte, len(t.fragments)-MessageIDSize)
+		copy(msg, t.fragments[MessageIDSize:])
+		return msgID, msg, nil
+	}
+}
+
+// readFrame 读取一帧,服务端要求帧带掩码,客户端要求帧不带掩码
+func (t *wsTransport) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(t.r, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		err = errWsProtocol
+		return
+	}
+	masked := head[1]&0x80 != 0
+	if masked == t.client {
+		err = errWsProtocol
+		return
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(t.r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(t.r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsOpClose && (length > wsMaxControlPayload || !fin) {
+		err = errWsProtocol
+		return
+	}
+	if length > uint64(MessageMaxLength)+uint64(MessageIDSize) {
+		err = errWsMessageTooLarge
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(t.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(t.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// writeFrame 写入一帧到缓冲,调用时持有writeMtx
+func (t *wsTransport) writeFrame(opcode byte, payload []byte) error {
+	head := make([]byte, 0, 14)
+	head = append(head, 0x80|opcode)
+	var maskBit byte
+	if t.client {
+		maskBit = 0x80
+	}
+	length := len(payload)
+	switch {
+	case length <= 125:
+		head = append(head, maskBit|byte(length))
+	case length <= 0xFFFF:
+		head = append(head, maskBit|126, byte(length>>8), byte(length))
+	default:
+		head = append(head, maskBit|127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		head = append(head, ext[:]...)
+	}
+
+	if t.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		head = append(head, mask[:]...)
+		masked := make([]byte, length)
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	if _, err := t.w.Write(head); err != nil {
+		return err
+	}
+	_, err := t.w.Write(payload)
+	return err
+}
+
+// writeControl 立即发送控制帧
+func (t *wsTransport) writeControl(opcode byte, payload []byte) error {
+	t.writeMtx.Lock()
+	defer t.writeMtx.Unlock()
+	t.conn.SetWriteDeadline(time.Now().Add(defaultWriteTimeout))
+	if err := t.writeFrame(opcode, payload); err != nil {
+		return err
+	}
+	return t.w.Flush()
+}
+
+func (t *wsTransport) extendDeadline() {
+	if t.idleTimeout > 0 {
+		t.conn.SetReadDeadline(time.Now().Add(t.idleTimeout))
+	}
+}
+
+func (t *wsTransport) WriteMessage(msgID uint32, msg []byte) error {
+	buf, err := DefaultWsWrite(msgID, msg, t.endian)
+	if err != nil {
+		return err
+	}
+	t.writeMtx.Lock()
+	defer t.writeMtx.Unlock()
+	return t.writeFrame(wsOpBinary, buf)
+}
+
+func (t *wsTransport) Flush() error {
+	t.writeMtx.Lock()
+	defer t.writeMtx.Unlock()
+	return t.w.Flush()
+}
+
+func (t *wsTransport) SetReadDeadline(d time.Time) error {
+	return t.conn.SetReadDeadline(d)
+}
+
+func (t *wsTransport) SetWriteDeadline(d time.Time) error {
+	return t.conn.SetWriteDeadline(d)
+}
+
+func (t *wsTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+// Close 尽量发送关闭帧后断开连接
+func (t *wsTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closeChan)
+		t.writeMtx.Lock()
+		t.conn.SetWriteDeadline(time.Now().Add(wsCloseTimeout))
+		var payload [2]byte
+		binary.BigEndian.PutUint16(payload[:], uint16(t.closeCode))
+		if t.writeFrame(wsOpClose, payload[:]) == nil {
+			t.w.Flush()
+		}
+		t.writeMtx.Unlock()
+		err = t.conn.Close()
+	})
+	return err
+}
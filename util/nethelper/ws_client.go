@@ -0,0 +1,171 @@
+package nethelper
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/panlibin/virgo"
+)
+
+// ErrWsHandshake websocket握手失败
+var ErrWsHandshake = errors.New("nethelper: websocket handshake failed")
+
+const defaultDialTimeout = time.Second * 10
+
+// WsClient websocket客户端,用于机器人及测试,每次Dial一个会话,会话事件在主线程回调
+type WsClient struct {
+	g            *sessionGroup
+	pingInterval time.Duration
+	dialTimeout  time.Duration
+	origin       string
+	tlsConfig    *tls.Config
+}
+
+// NewWsClient 新建
+func NewWsClient(p virgo.IProcedure, handler ISessionHandler) *WsClient {
+	return &WsClient{
+		g:           newSessionGroup(p, handler),
+		dialTimeout: defaultDialTimeout,
+	}
+}
+
+// SetEndian 设置消息ID的大小端,Dial前调用
+func (c *WsClient) SetEndian(endianType EndianType) {
+	c.g.cfg.endian = endianType
+}
+
+// SetIdleTimeout 设置空闲超时,Dial前调用
+func (c *WsClient) SetIdleTimeout(d time.Duration) {
+	c.g.cfg.idleTimeout = d
+}
+
+// SetSendQueueSize 设置每个会话的发送队列长度,Dial前调用
+func (c *WsClient) SetSendQueueSize(size int) {
+	c.g.cfg.sendQueueSize = size
+}
+
+// SetPingInterval 设置ping间隔,默认0为不发送,Dial前调用
+func (c *WsClient) SetPingInterval(d time.Duration) {
+	c.pingInterval = d
+}
+
+// SetDialTimeout 设置连接及握手超时,Dial前调用
+func (c *WsClient) SetDialTimeout(d time.Duration) {
+	c.dialTimeout = d
+}
+
+// SetOrigin 设置握手的Origin头,Dial前调用
+func (c *WsClient) SetOrigin(origin string) {
+	c.origin = origin
+}
+
+// SetTLSConfig 设置wss连接的tls配置,Dial前调用
+func (c *WsClient) SetTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
+// Dial 连接ws://或wss://地址并握手,成功后返回会话
+func (c *WsClient) Dial(rawurl string) (*Session, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: c.dialTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = dialer.Dial("tcp", host)
+	case "wss":
+		cfg := c.tlsConfig
+		if cfg == nil {
+			cfg = &tls.Config{ServerName: u.Hostname()}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, cfg)
+	default:
+		return nil, fmt.Errorf("nethelper: unsupported websocket scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := c.handshake(conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t := newWsTransport(conn, r, c.g.cfg.endian, true, c.g.cfg.idleTimeout, c.pingInterval)
+	s := c.g.add(t)
+	if s == nil {
+		t.Close()
+		return nil, ErrSessionClosed
+	}
+	return s, nil
+}
+
+// Close 关闭所有会话并等待读写协程退出
+func (c *WsClient) Close() {
+	c.g.closeAll()
+}
+
+func (c *WsClient) handshake(conn net.Conn, u *url.URL) (*bufio.Reader, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if c.origin != "" {
+		req.Header.Set("Origin", c.origin)
+	}
+
+	conn.SetDeadline(time.Now().Add(c.dialTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContains(resp.Header, "Upgrade", "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return nil, fmt.Errorf("%w: %s", ErrWsHandshake, resp.Status)
+	}
+	return r, nil
+}
@@ -0,0 +1,169 @@
+package nethelper
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	logger "github.com/panlibin/vglog"
+	"github.com/panlibin/virgo"
+)
+
+const defaultPingInterval = time.Second * 30
+
+// WsServer websocket服务器,挂载到HTTPServer: s.Handle(pattern, ws.ServeHTTP).
+// 每个连接一个会话,会话事件在主线程回调
+type WsServer struct {
+	g            *sessionGroup
+	pingInterval time.Duration
+	checkOrigin  func(r *http.Request) bool
+}
+
+// NewWsServer 新建
+func NewWsServer(p virgo.IProcedure, handler ISessionHandler) *WsServer {
+	return &WsServer{
+		g:            newSessionGroup(p, handler),
+		pingInterval: defaultPingInterval,
+		checkOrigin:  sameOrigin,
+	}
+}
+
+// SetEndian 设置消息ID的大小端,挂载前调用
+func (s *WsServer) SetEndian(endianType EndianType) {
+	s.g.cfg.endian = endianType
+}
+
+// SetMaxConn 设置最大连接数,超过时拒绝握手,挂载前调用
+func (s *WsServer) SetMaxConn(n int) {
+	s.g.cfg.maxConn = n
+}
+
+// SetIdleTimeout 设置空闲超时,超过时间未收到消息或pong时关闭会话,应大于ping间隔,挂载前调用
+func (s *WsServer) SetIdleTimeout(d time.Duration) {
+	s.g.cfg.idleTimeout = d
+}
+
+// SetSendQueueSize 设置每个会话的发送队列长度,挂载前调用
+func (s *WsServer) SetSendQueueSize(size int) {
+	s.g.cfg.sendQueueSize = size
+}
+
+// SetPingInterval 设置ping间隔,0为不发送,默认30秒,挂载前调用
+func (s *WsServer) SetPingInterval(d time.Duration) {
+	s.pingInterval = d
+}
+
+// SetCheckOrigin 设置Origin检查,默认只允许无Origin或与Host相同的请求,挂载前调用
+func (s *WsServer) SetCheckOrigin(f func(r *http.Request) bool) {
+	s.checkOrigin = f
+}
+
+// SetAllowedOrigins 只允许指定的Origin,如"https://example.com",挂载前调用
+func (s *WsServer) SetAllowedOrigins(origins ...string) {
+	allowed := make(map[string]struct{}, len(origins))
+	for _, origin := range origins {
+		allowed[strings.ToLower(origin)] = struct{}{}
+	}
+	s.checkOrigin = func(r *http.Request) bool {
+		_, ok := allowed[strings.ToLower(r.Header.Get("Origin"))]
+		return ok
+	}
+}
+
+// Stop 关闭所有会话并等待读写协程退出,之后的握手均被拒绝
+func (s *WsServer) Stop() {
+	s.g.closeAll()
+}
+
+// Session 按id查找会话
+func (s *WsServer) Session(id uint64) *Session {
+	return s.g.get(id)
+}
+
+// SessionCount 当前会话数
+func (s *WsServer) SessionCount() int {
+	return s.g.count()
+}
+
+// ServeHTTP 完成握手并创建会话
+func (s *WsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return
+	}
+	if s.checkOrigin != nil && !s.checkOrigin(r) {
+		logger.Warningf("websocket origin %s from %s rejected", r.Header.Get("Origin"), r.RemoteAddr)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if s.g.cfg.maxConn > 0 && s.g.count() >= s.g.cfg.maxConn {
+		logger.Warningf("websocket connection from %s rejected, too many connections", r.RemoteAddr)
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		logger.Errorf("websocket hijack error: %v", err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	conn.SetWriteDeadline(time.Now().Add(defaultWriteTimeout))
+	_, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"))
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	t := newWsTransport(conn, rw.Reader, s.g.cfg.endian, false, s.g.cfg.idleTimeout, s.pingInterval)
+	if s.g.add(t) == nil {
+		logger.Warningf("websocket connection from %s rejected, too many connections", r.RemoteAddr)
+		t.Close()
+	}
+}
+
+// sameOrigin 无Origin的非浏览器请求或Origin与Host相同时允许
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// headerContains 头部是否包含逗号分隔的token,不区分大小写
+func headerContains(h http.Header, name string, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
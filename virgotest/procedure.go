@@ -0,0 +1,70 @@
+// Package virgotest 测试辅助
+package virgotest
+
+import "time"
+
+const procedureQueueSize = 4096
+
+// Procedure 测试用主线程,SyncTask的任务由测试协程调用RunOne/RunPending执行
+type Procedure struct {
+	tasks chan func()
+}
+
+// NewProcedure 新建
+func NewProcedure() *Procedure {
+	return &Procedure{
+		tasks: make(chan func(), procedureQueueSize),
+	}
+}
+
+// SyncTask 任务入队,等待测试协程执行
+func (p *Procedure) SyncTask(f func([]interface{}), args ...interface{}) {
+	p.tasks <- func() {
+		f(args)
+	}
+}
+
+// AsyncTask 新协程执行
+func (p *Procedure) AsyncTask(f func([]interface{}), args ...interface{}) {
+	go f(args)
+}
+
+// AfterFunc 到期后入队
+func (p *Procedure) AfterFunc(d time.Duration, f func([]interface{}), args ...interface{}) *time.Timer {
+	return time.AfterFunc(d, func() {
+		p.SyncTask(f, args...)
+	})
+}
+
+// Start 无操作
+func (p *Procedure) Start() {}
+
+// Stop 无操作
+func (p *Procedure) Stop() {}
+
+// RunOne 执行一个任务,timeout内没有任务时返回false
+func (p *Procedure) RunOne(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case f := <-p.tasks:
+		f()
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// RunPending 执行已入队的任务,返回执行数量
+func (p *Procedure) RunPending() int {
+	n := 0
+	for {
+		select {
+		case f := <-p.tasks:
+			f()
+			n++
+		default:
+			return n
+		}
+	}
+}